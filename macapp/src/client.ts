@@ -3,7 +3,7 @@ import * as path from 'path'
 import { promisify } from 'util'
 import axios from 'axios';
 import { json } from 'stream/consumers';
-import { ResultSource } from "./types";
+import { ConnectorSettings, ResultSource, SyncError, SyncEvent, SyncRun } from "./types";
 
 const app =
   process && process.type === "renderer"
@@ -13,6 +13,38 @@ const verbis = app.isPackaged
   ? path.join(process.resourcesPath, "ollama")
   : path.resolve(process.cwd(), "..", "verbis");
 
+// The API token is written by the backend on boot
+const apiTokenPath = path.join(app.getPath("home"), ".verbis", "api_token");
+let apiToken: string | null = null;
+
+function api_token(): string | null {
+  if (!apiToken) {
+    try {
+      apiToken = fs.readFileSync(apiTokenPath, "utf8").trim();
+    } catch (error) {
+      console.error("Unable to read API token:", error);
+      return null;
+    }
+  }
+  return apiToken;
+}
+
+axios.interceptors.request.use((config) => {
+  const token = api_token();
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+axios.interceptors.response.use(undefined, (error) => {
+  // The token may have been regenerated, read it again on the next request
+  if (error.response?.status === 401) {
+    apiToken = null;
+  }
+  return Promise.reject(error);
+});
+
 export async function connector_init(connector_name: string) {
   try {
     const response = await axios.get(
@@ -63,6 +95,32 @@ export async function connector_auth_setup(connector_id: string) {
   }
 }
 
+export async function get_connector_settings(connector_id: string): Promise<ConnectorSettings> {
+  try {
+    const response = await axios.get(
+      `http://localhost:8081/connectors/${connector_id}/settings`
+    );
+    console.log("Get Connector Settings Response:", response.data);
+    return response.data;
+  } catch (error) {
+    console.error("Error in Get Connector Settings:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function update_connector_settings(connector_id: string, settings: any): Promise<ConnectorSettings> {
+  try {
+    const response = await axios.put(
+      `http://localhost:8081/connectors/${connector_id}/settings`,
+      settings);
+    console.log("Update Connector Settings Response:", response.data);
+    return response.data;
+  } catch (error) {
+    console.error("Error in Update Connector Settings:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
 export async function get_config() {
   try {
     const response = await axios.get(
@@ -88,6 +146,120 @@ export async function update_config(config: any) {
   }
 }
 
+export async function connector_sync(connector_id: string) {
+  try {
+    const response = await axios.post(
+      `http://localhost:8081/connectors/${connector_id}/sync`
+    );
+    console.log("Connector Sync Response:", response.data);
+  } catch (error) {
+    console.error("Error in Connector Sync:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function connector_pause(connector_id: string) {
+  try {
+    const response = await axios.post(
+      `http://localhost:8081/connectors/${connector_id}/pause`
+    );
+    console.log("Connector Pause Response:", response.data);
+  } catch (error) {
+    console.error("Error in Connector Pause:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function connector_resume(connector_id: string) {
+  try {
+    const response = await axios.post(
+      `http://localhost:8081/connectors/${connector_id}/resume`
+    );
+    console.log("Connector Resume Response:", response.data);
+  } catch (error) {
+    console.error("Error in Connector Resume:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function connector_cancel(connector_id: string) {
+  try {
+    const response = await axios.post(
+      `http://localhost:8081/connectors/${connector_id}/cancel`
+    );
+    console.log("Connector Cancel Response:", response.data);
+  } catch (error) {
+    console.error("Error in Connector Cancel:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function list_connector_runs(connector_id: string): Promise<SyncRun[]> {
+  try {
+    const response = await axios.get(
+      `http://localhost:8081/connectors/${connector_id}/runs`
+    );
+    console.log("List Connector Runs Response:", response.data);
+    return response.data;
+  } catch (error) {
+    console.error("Error in List Connector Runs:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function list_connector_errors(connector_id: string): Promise<SyncError[]> {
+  try {
+    const response = await axios.get(
+      `http://localhost:8081/connectors/${connector_id}/errors`
+    );
+    console.log("List Connector Errors Response:", response.data);
+    return response.data;
+  } catch (error) {
+    console.error("Error in List Connector Errors:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+export async function retry_connector_errors(connector_id: string) {
+  try {
+    const response = await axios.post(
+      `http://localhost:8081/connectors/${connector_id}/errors/retry`
+    );
+    console.log("Retry Connector Errors Response:", response.data);
+    return response.data;
+  } catch (error) {
+    console.error("Error in Retry Connector Errors:", error);
+    throw error; // Rethrow or handle as needed
+  }
+}
+
+// subscribe_events calls onEvent with every sync event until the returned
+// EventSource is closed
+export function subscribe_events(onEvent: (event: SyncEvent) => void): EventSource {
+  const source = new EventSource(
+    `http://localhost:8081/events?access_token=${encodeURIComponent(
+      api_token() ?? ""
+    )}`
+  );
+  const types: SyncEvent["type"][] = [
+    "sync_started",
+    "document_processed",
+    "chunk_added",
+    "sync_error",
+    "sync_finished",
+    "auth_invalidated",
+  ];
+  for (const type of types) {
+    source.addEventListener(type, (message: MessageEvent) => {
+      onEvent(JSON.parse(message.data));
+    });
+  }
+  source.onerror = (error) => {
+    console.error("Error in Events Stream:", error);
+  };
+  return source;
+}
+
 export async function force_sync() {
   try {
     const response = await axios.get("http://localhost:8081/sync/force");
@@ -167,6 +339,7 @@ export async function generate(
     method: "POST",
     headers: {
       "Content-Type": "application/json",
+      Authorization: `Bearer ${api_token()}`,
     },
     body: JSON.stringify(payload),
     signal: controller.signal,
@@ -1,5 +1,10 @@
 import React, { useEffect, useState } from "react";
-import { force_sync, list_connectors, connector_delete } from "../client";
+import {
+  connector_sync,
+  list_connectors,
+  connector_delete,
+  connector_auth_setup,
+} from "../client";
 import GDriveLogo from "../../assets/connectors/gdrive.svg";
 import GMailLogo from "../../assets/connectors/gmail.svg";
 import OutlookLogo from "../../assets/connectors/outlook.svg";
@@ -97,14 +102,31 @@ const ActiveConnectorsList: React.FC = () => {
                   </label>
                 </th> */}
                   <td>
-                    <button className="rounded-full" onClick={force_sync}>
-                      <ArrowPathIcon
-                        className={`h-5 w-5 ${
-                          connector.syncing ? "animate-spin" : ""
-                        }`}
-                        title={connector.syncing ? "Syncing..." : "Force Sync"}
-                      />
-                    </button>
+                    {connector.auth_valid ? (
+                      <button
+                        className="rounded-full"
+                        onClick={() => connector_sync(connector.connector_id)}
+                      >
+                        <ArrowPathIcon
+                          className={`h-5 w-5 ${
+                            connector.syncing ? "animate-spin" : ""
+                          }`}
+                          title={
+                            connector.syncing ? "Syncing..." : "Force Sync"
+                          }
+                        />
+                      </button>
+                    ) : (
+                      <button
+                        className="btn btn-xs btn-warning"
+                        title={connector.auth_error}
+                        onClick={() =>
+                          connector_auth_setup(connector.connector_id)
+                        }
+                      >
+                        Reconnect
+                      </button>
+                    )}
                   </td>
                   <td>
                     {LogoComponent ? <LogoComponent className="h-5 w-5" /> : ""}
@@ -112,7 +134,15 @@ const ActiveConnectorsList: React.FC = () => {
                   <td>{connector.user.toString()}</td>
                   <td>{connector.num_documents}</td>
                   <td>{connector.num_errors}</td>
-                  <td>{renderLastSyncDate(connector.last_sync)}</td>
+                  <td
+                    title={
+                      connector.syncing
+                        ? "Syncing..."
+                        : `Next sync ${renderLastSyncDate(connector.next_sync)}`
+                    }
+                  >
+                    {renderLastSyncDate(connector.last_sync)}
+                  </td>
                   <td>
                     <TrashIcon
                       className="h-5 w-5"
@@ -9,6 +9,9 @@ export interface ResultSource {
   title: string;
   url: string;
   type: string;
+  section?: string;
+  page?: number;
+  from?: string;
 }
 
 export interface Conversation {
@@ -26,3 +29,55 @@ export interface ConversationItem {
   content: string;
   sources?: ResultSource[];
 }
+
+// Returned by the settings endpoints of a connector. The schema is a JSON
+// schema of settings, listing its fields in propertyOrder.
+export interface ConnectorSettings {
+  schema: any;
+  settings: any;
+}
+
+export interface SyncRun {
+  id: string;
+  connector_id: string;
+  retry: boolean;
+  started_at: string;
+  ended_at: string;
+  outcome: "running" | "success" | "failed" | "cancelled";
+  num_documents: number;
+  num_chunks: number;
+  num_errors: number;
+  error?: string;
+}
+
+export interface SyncError {
+  id: string;
+  connector_id: string;
+  run_id: string;
+  document_id?: string;
+  document_name?: string;
+  stage?: string;
+  error: string;
+  created_at: string;
+}
+
+export interface SyncEvent {
+  type:
+    | "sync_started"
+    | "document_processed"
+    | "chunk_added"
+    | "sync_error"
+    | "sync_finished"
+    | "auth_invalidated";
+  time: string;
+  connector_id: string;
+  connector_type: string;
+  run_id?: string;
+  run?: SyncRun;
+  document_id?: string;
+  document_name?: string;
+  num_documents?: number;
+  num_chunks?: number;
+  error?: SyncError;
+  auth_error?: string;
+}
@@ -14,8 +14,9 @@ from typing import Optional, List, Dict, Any
 model_url = 'https://huggingface.co/prithivida/flashrank/resolve/main/{}.zip'
 
 home_dir = Path.home()
-default_cache_dir = home_dir / ".verbis" / "models"
-default_model = "ms-marco-MiniLM-L-12-v2"
+# Set by the daemon when the data dir or the model are configured
+default_cache_dir = os.environ.get("VERBIS_MODELS_DIR", home_dir / ".verbis" / "models")
+default_model = os.environ.get("VERBIS_RERANKER_MODEL", "ms-marco-MiniLM-L-12-v2")
 model_file_map = {
         "ms-marco-MiniLM-L-12-v2": "reranker.onnx",
         }
@@ -53,7 +54,7 @@ class Ranker:
         """
         self.cache_dir: Path = Path(cache_dir)
         self.model_dir: Path = self.cache_dir / model_name
-        model_file = model_file_map[model_name]
+        model_file = model_file_map.get(model_name, "reranker.onnx")
 
         self.llm_model = None
         self.session = ort.InferenceSession(str(self.model_dir / model_file))
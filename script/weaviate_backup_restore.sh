@@ -10,12 +10,14 @@ MODE=$1
 BACKUP_ID=$2
 USERNAME=$(whoami)
 BACKUP_PATH="/Users/$USERNAME/.verbis/synced_data/backup"
+AUTH_HEADER="Authorization: Bearer $(cat "/Users/$USERNAME/.verbis/weaviate_api_key")"
 
 if [ "$MODE" == "backup" ]; then
     echo "Starting backup with ID: $BACKUP_ID"
 
     # Create backup
     curl -X POST "http://localhost:8088/v1/backups/filesystem" \
+    -H "$AUTH_HEADER" \
     -H "Content-Type: application/json" \
     -d '{
       "id": "'"$BACKUP_ID"'",
@@ -35,10 +37,10 @@ elif [ "$MODE" == "restore" ]; then
     echo "Starting restore with ID: $BACKUP_ID"
 
     # Delete existing classes
-    curl -X DELETE "http://localhost:8088/v1/schema/ConnectorState"
-    curl -X DELETE "http://localhost:8088/v1/schema/Document"
-    curl -X DELETE "http://localhost:8088/v1/schema/VerbisChunk"
-    curl -X DELETE "http://localhost:8088/v1/schema/Conversation"
+    curl -X DELETE -H "$AUTH_HEADER" "http://localhost:8088/v1/schema/ConnectorState"
+    curl -X DELETE -H "$AUTH_HEADER" "http://localhost:8088/v1/schema/Document"
+    curl -X DELETE -H "$AUTH_HEADER" "http://localhost:8088/v1/schema/VerbisChunk"
+    curl -X DELETE -H "$AUTH_HEADER" "http://localhost:8088/v1/schema/Conversation"
 
     # Check if the class deletions were successful
     if [ $? -ne 0 ]; then
@@ -48,6 +50,7 @@ elif [ "$MODE" == "restore" ]; then
 
     # Restore backup
     curl -X POST "http://localhost:8088/v1/backups/filesystem/$BACKUP_ID/restore" \
+    -H "$AUTH_HEADER" \
     -H "Content-Type: application/json" \
     -d '{
       "id": "'"$BACKUP_ID"'",
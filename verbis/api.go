@@ -1,24 +1,32 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"math"
 	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/posthog/posthog-go"
 
 	"github.com/verbis-ai/verbis/verbis/connectors"
+	"github.com/verbis-ai/verbis/verbis/store"
 	"github.com/verbis-ai/verbis/verbis/types"
+	"github.com/verbis-ai/verbis/verbis/util"
 )
 
 var (
-	PromptLogFile = ".verbis/logs/prompt.log" // Relative to home
+	PromptLogFile = "logs/prompt.log" // Relative to the data dir
 )
 
 type API struct {
@@ -27,6 +35,8 @@ type API struct {
 	Posthog           posthog.Client
 	PosthogDistinctID string
 	Version           string
+	Reindexer         *Reindexer
+	embedder          types.Embedder
 	store             types.Store
 }
 
@@ -35,12 +45,21 @@ func (a *API) SetupRouter() *mux.Router {
 	r.HandleFunc("/connectors", a.connectorsList).Methods("GET")
 	r.HandleFunc("/connectors/{type}/init", a.connectorInit).Methods("GET")
 	r.HandleFunc("/connectors/{type}/request", a.connectorRequest).Methods("GET")
-	// TODO: auth_setup and callback are theoretically per connector and not per
-	// connector type. The ID of the connector should be inferred and passed as
-	// a state variable in the oauth flow.
+	// The callback of connectors with a static redirect URI is per connector
+	// type, the connector is then inferred from the OAuth state
 	r.HandleFunc("/connectors/{connector_id}/auth_setup", a.connectorAuthSetup).Methods("GET")
 	r.HandleFunc("/connectors/{connector_id}/callback", a.handleConnectorCallback).Methods("GET")
 	r.HandleFunc("/connectors/{connector_id}", a.handleConnectorDelete).Methods("DELETE")
+	r.HandleFunc("/connectors/{connector_id}/settings", a.getConnectorSettings).Methods("GET")
+	r.HandleFunc("/connectors/{connector_id}/settings", a.updateConnectorSettings).Methods("PUT")
+	r.HandleFunc("/connectors/{connector_id}/schedule", a.updateConnectorSchedule).Methods("PUT")
+	r.HandleFunc("/connectors/{connector_id}/sync", a.connectorSync).Methods("POST")
+	r.HandleFunc("/connectors/{connector_id}/pause", a.connectorPause).Methods("POST")
+	r.HandleFunc("/connectors/{connector_id}/resume", a.connectorResume).Methods("POST")
+	r.HandleFunc("/connectors/{connector_id}/cancel", a.connectorCancel).Methods("POST")
+	r.HandleFunc("/connectors/{connector_id}/runs", a.listConnectorRuns).Methods("GET")
+	r.HandleFunc("/connectors/{connector_id}/errors", a.listConnectorErrors).Methods("GET")
+	r.HandleFunc("/connectors/{connector_id}/errors/retry", a.retryConnectorErrors).Methods("POST")
 	r.HandleFunc("/connectors/auth_complete", a.authComplete).Methods("GET")
 
 	r.HandleFunc("/conversations", a.listConversations).Methods("GET")
@@ -51,27 +70,149 @@ func (a *API) SetupRouter() *mux.Router {
 	r.HandleFunc("/config", a.getConfig).Methods("GET")
 	r.HandleFunc("/config", a.updateConfig).Methods("POST")
 
+	r.HandleFunc("/models", a.modelsList).Methods("GET")
+	r.HandleFunc("/models/pull", a.modelPull).Methods("POST")
+	r.HandleFunc("/models/create", a.modelCreate).Methods("POST")
+	r.HandleFunc("/models/active", a.modelSetActive).Methods("PUT")
+	r.HandleFunc("/models/{name:.+}", a.modelDelete).Methods("DELETE")
+
+	r.HandleFunc("/embeddings", a.embeddingsList).Methods("GET")
+	r.HandleFunc("/embeddings/reindex", a.embeddingsReindex).Methods("POST")
+	r.HandleFunc("/embeddings/rollback", a.embeddingsRollback).Methods("POST")
+	r.HandleFunc("/embeddings/{index_id}", a.embeddingsDelete).Methods("DELETE")
+
 	r.HandleFunc("/health", a.health).Methods("GET")
+	r.HandleFunc("/events", a.streamEvents).Methods("GET")
 	r.HandleFunc("/sync/force", a.forceSync).Methods("GET")
 	r.HandleFunc("/internal/reinit", a.reInit).Methods("POST")
+	r.HandleFunc("/debug/documents/{unique_id}", a.debugDocument).Methods("GET")
 
 	return r
 }
 
 type HealthResponse struct {
 	BootState BootState `json:"boot_state"`
-	Version   string    `json:"version"`
+	// BootError is the error of the boot step being retried, if any
+	BootError  string                     `json:"boot_error,omitempty"`
+	Version    string                     `json:"version"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// ComponentHealth is the state of a subprocess or model the daemon depends on
+type ComponentHealth struct {
+	Healthy  bool   `json:"healthy"`
+	Restarts int    `json:"restarts,omitempty"`
+	Error    string `json:"error,omitempty"`
 }
 
+const healthModelsTimeout = 2 * time.Second
+
 func (a *API) health(w http.ResponseWriter, r *http.Request) {
-	// TODO: check for health of subprocesses
 	// TODO: return state of syncs and model downloads, to be used during init
+	state, bootErr := a.Context.Status()
 	json.NewEncoder(w).Encode(HealthResponse{
-		BootState: a.Context.State,
-		Version:   a.Version,
+		BootState:  state,
+		BootError:  bootErr,
+		Version:    a.Version,
+		Components: a.componentsHealth(r.Context()),
 	})
 }
 
+// componentsHealth reports the health of the supervised processes, whether
+// the models are available in ollama, and whether the reranker has run
+func (a *API) componentsHealth(ctx context.Context) map[string]ComponentHealth {
+	components := map[string]ComponentHealth{}
+	if a.Context.Supervisor != nil {
+		for name, p := range a.Context.Supervisor.Health() {
+			components[name] = ComponentHealth{
+				Healthy:  p.Running && p.Healthy,
+				Restarts: p.Restarts,
+				Error:    p.Error,
+			}
+		}
+	}
+
+	checked := map[string]string{
+		"generation_model": GenerationModel(),
+	}
+	// The store is only set once the active index is known
+	if a.store != nil {
+		checked["embeddings_model"] = a.store.ActiveEmbeddingIndex().Model
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthModelsTimeout)
+	defer cancel()
+	models, err := listModels(ctx)
+	for component, name := range checked {
+		switch {
+		case err != nil:
+			components[component] = ComponentHealth{Error: err.Error()}
+		case !hasModel(models, name):
+			components[component] = ComponentHealth{Error: fmt.Sprintf("model %s not loaded", name)}
+		default:
+			components[component] = ComponentHealth{Healthy: true}
+		}
+	}
+
+	reranker := ComponentHealth{Healthy: a.Context.RerankerReady.Load()}
+	if !reranker.Healthy {
+		reranker.Error = "reranker not loaded"
+	}
+	components["reranker"] = reranker
+	return components
+}
+
+// eventsKeepAlive is the period of the comments sent on idle event streams,
+// so that clients and proxies do not time out
+const eventsKeepAlive = 15 * time.Second
+
+// streamEvents streams sync events as Server-Sent Events, optionally only
+// those of the connector given by the connector_id query parameter
+func (a *API) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	connectorID := r.URL.Query().Get("connector_id")
+
+	events, unsubscribe := a.Syncer.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		case event := <-events:
+			if connectorID != "" && event.ConnectorID != connectorID {
+				continue
+			}
+			b, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Failed to marshal event: %s", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
 func (a *API) getConfig(w http.ResponseWriter, r *http.Request) {
 	cfg, err := a.store.GetConfig(r.Context())
 	if err != nil {
@@ -113,7 +254,8 @@ func (a *API) updateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if cfg.EnableTelemetry && a.Posthog == nil {
+	telemetry := a.Context.Config.TelemetryEnabled(cfg.EnableTelemetry)
+	if telemetry && a.Posthog == nil {
 		postHogClient, err := posthog.NewWithConfig(
 			PosthogAPIKey,
 			posthog.Config{
@@ -131,7 +273,7 @@ func (a *API) updateConfig(w http.ResponseWriter, r *http.Request) {
 		a.Syncer.posthogClient = postHogClient
 	}
 
-	if !cfg.EnableTelemetry && a.Posthog != nil {
+	if !telemetry && a.Posthog != nil {
 		a.Posthog = nil
 		a.Syncer.posthogClient = nil
 	}
@@ -178,6 +320,73 @@ func (a *API) connectorRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type DebugChunk struct {
+	Ordinal int      `json:"ordinal"`
+	Hash    string   `json:"hash"`
+	Raw     string   `json:"raw"`
+	Cleaned string   `json:"cleaned"`
+	URLs    []string `json:"urls"`
+}
+
+type DebugDocumentResponse struct {
+	Document      *types.Document `json:"document"`
+	SanitizeRules []string        `json:"sanitize_rules"`
+	Chunks        []DebugChunk    `json:"chunks"`
+}
+
+// debugDocument shows the raw and sanitized content of every chunk of a
+// document, to help tune the sanitisation rules
+func (a *API) debugDocument(w http.ResponseWriter, r *http.Request) {
+	uniqueID := mux.Vars(r)["unique_id"]
+	doc, err := a.store.GetDocument(r.Context(), uniqueID)
+	if store.IsErrDocumentNotFound(err) {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get document: %s", err)
+		http.Error(w, "Failed to get document: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	doc.UniqueID = uniqueID
+
+	chunks, err := a.store.GetDocumentChunks(r.Context(), uniqueID)
+	if err != nil {
+		log.Printf("Failed to get document chunks: %s", err)
+		http.Error(w, "Failed to get document chunks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sanitizer, err := util.SanitizerFor(doc.ConnectorType)
+	if err != nil {
+		http.Error(w, "Failed to get sanitizer: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := DebugDocumentResponse{
+		Document:      doc,
+		SanitizeRules: sanitizer.RuleNames(),
+		Chunks:        []DebugChunk{},
+	}
+	for _, chunk := range chunks {
+		resp.Chunks = append(resp.Chunks, DebugChunk{
+			Ordinal: chunk.Ordinal,
+			Hash:    chunk.Hash,
+			Raw:     chunk.RawText,
+			Cleaned: chunk.Text,
+			URLs:    chunk.URLs,
+		})
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to marshal document: %s", err)
+		http.Error(w, "Failed to marshal document: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
 func (a *API) listConversations(w http.ResponseWriter, r *http.Request) {
 	conversations, err := a.store.ListConversations(r.Context())
 	if err != nil {
@@ -239,6 +448,253 @@ func (a *API) createConversation(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+type ModelsResponse struct {
+	Models          []ModelInfo `json:"models"`
+	GenerationModel string      `json:"generation_model"`
+	EmbeddingsModel string      `json:"embeddings_model"`
+}
+
+type ModelRequest struct {
+	Name      string `json:"name"`
+	Modelfile string `json:"modelfile,omitempty"` // Only to create a model
+}
+
+func (a *API) modelsList(w http.ResponseWriter, r *http.Request) {
+	models, err := listModels(r.Context())
+	if err != nil {
+		log.Printf("Failed to list models: %s", err)
+		http.Error(w, "Failed to list models: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ModelsResponse{
+		Models:          models,
+		GenerationModel: GenerationModel(),
+		EmbeddingsModel: a.store.ActiveEmbeddingIndex().Model,
+	})
+}
+
+func decodeModelRequest(w http.ResponseWriter, r *http.Request) (*ModelRequest, bool) {
+	var req ModelRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Failed to decode request", http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Name == "" {
+		http.Error(w, "No model name provided", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// modelPull downloads a model, streaming progress as JSON lines
+func (a *API) modelPull(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModelRequest(w, r)
+	if !ok {
+		return
+	}
+	streamModelProgress(w, func(progress func(ModelProgress)) error {
+		return pullModel(r.Context(), req.Name, progress)
+	})
+}
+
+// modelCreate builds a model from a Modelfile, streaming progress as JSON
+// lines
+func (a *API) modelCreate(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModelRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Modelfile == "" {
+		http.Error(w, "No modelfile provided", http.StatusBadRequest)
+		return
+	}
+	streamModelProgress(w, func(progress func(ModelProgress)) error {
+		return createModel(r.Context(), req.Name, req.Modelfile, progress)
+	})
+}
+
+// streamModelProgress writes each progress update of the operation as a line
+// of JSON. As the status code is sent first, a failure is reported as a last
+// line with an error.
+func streamModelProgress(w http.ResponseWriter, run func(progress func(ModelProgress)) error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	err := run(func(p ModelProgress) {
+		enc.Encode(p)
+		flusher.Flush()
+	})
+	if err != nil {
+		log.Printf("Failed to pull or create model: %s", err)
+		enc.Encode(ModelProgress{Status: "error", Error: err.Error()})
+		flusher.Flush()
+	}
+}
+
+// modelSetActive selects the model answering prompts, among the installed
+// ones
+func (a *API) modelSetActive(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModelRequest(w, r)
+	if !ok {
+		return
+	}
+
+	models, err := listModels(r.Context())
+	if err != nil {
+		log.Printf("Failed to list models: %s", err)
+		http.Error(w, "Failed to list models: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !hasModel(models, req.Name) {
+		http.Error(w, "Model "+req.Name+" is not installed", http.StatusNotFound)
+		return
+	}
+
+	cfg, err := a.store.GetConfig(r.Context())
+	if err == nil && cfg == nil {
+		err = errors.New("config not initialized")
+	}
+	if err != nil {
+		log.Printf("Failed to get config: %s", err)
+		http.Error(w, "Failed to get config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cfg.GenerationModel = req.Name
+	err = a.store.UpdateConfig(r.Context(), cfg)
+	if err != nil {
+		log.Printf("Failed to update config: %s", err)
+		http.Error(w, "Failed to update config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	SetGenerationModel(req.Name)
+	log.Printf("Generation model set to %s", req.Name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *API) modelDelete(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	inUse := sameModel(name, GenerationModel())
+	indexes, err := a.store.ListEmbeddingIndexes(r.Context())
+	if err != nil {
+		log.Printf("Failed to list embedding indexes: %s", err)
+		http.Error(w, "Failed to list embedding indexes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, index := range indexes {
+		if index.Status != types.EmbeddingIndexFailed && sameModel(name, index.Model) {
+			inUse = true
+		}
+	}
+	if inUse {
+		http.Error(w, "Model "+name+" is in use", http.StatusConflict)
+		return
+	}
+
+	err = deleteModel(r.Context(), name)
+	if errors.Is(err, ErrModelNotFound) {
+		http.Error(w, "Model "+name+" is not installed", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete model %s: %s", name, err)
+		http.Error(w, "Failed to delete model: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type EmbeddingsResponse struct {
+	Active  *types.EmbeddingIndex   `json:"active"`
+	Indexes []*types.EmbeddingIndex `json:"indexes"`
+}
+
+// embeddingsList returns the embedding indexes, with the progress of the one
+// being built if any
+func (a *API) embeddingsList(w http.ResponseWriter, r *http.Request) {
+	indexes, err := a.store.ListEmbeddingIndexes(r.Context())
+	if err != nil {
+		log.Printf("Failed to list embedding indexes: %s", err)
+		http.Error(w, "Failed to list embedding indexes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(EmbeddingsResponse{
+		Active:  a.store.ActiveEmbeddingIndex(),
+		Indexes: indexes,
+	})
+}
+
+type ReindexRequest struct {
+	Model string `json:"model"`
+}
+
+// embeddingsReindex starts building an index with a new embeddings model from
+// the stored chunks. Search switches to it once complete.
+func (a *API) embeddingsReindex(w http.ResponseWriter, r *http.Request) {
+	var req ReindexRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Model == "" {
+		http.Error(w, "No model provided", http.StatusBadRequest)
+		return
+	}
+
+	// The build outlives the request
+	index, err := a.Reindexer.Start(a.Context, req.Model)
+	if errors.Is(err, store.ErrEmbeddingIndexBuilding) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start reindexing: %s", err)
+		http.Error(w, "Failed to start reindexing: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(index)
+}
+
+// embeddingsRollback switches search back to the index active before the last
+// one was built
+func (a *API) embeddingsRollback(w http.ResponseWriter, r *http.Request) {
+	err := a.store.RollbackEmbeddingIndex(r.Context())
+	if errors.Is(err, store.ErrNoPreviousIndex) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to roll back embedding index: %s", err)
+		http.Error(w, "Failed to roll back embedding index: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// embeddingsDelete cancels the build of an index, or deletes the previous or
+// a failed one
+func (a *API) embeddingsDelete(w http.ResponseWriter, r *http.Request) {
+	err := a.Reindexer.Delete(r.Context(), mux.Vars(r)["index_id"])
+	switch {
+	case errors.Is(err, store.ErrEmbeddingIndexNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, store.ErrEmbeddingIndexActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		log.Printf("Failed to delete embedding index: %s", err)
+		http.Error(w, "Failed to delete embedding index: "+err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 func (a *API) connectorsList(w http.ResponseWriter, r *http.Request) {
 	fetch_all := r.URL.Query().Get("all") == "true"
 	states, err := a.Syncer.GetConnectorStates(r.Context(), fetch_all)
@@ -261,10 +717,46 @@ func (a *API) connectorsList(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+var authCompleteTemplate = template.Must(template.New("auth_complete").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Verbis</title></head>
+<body style="font-family: sans-serif; text-align: center; margin-top: 4em">
+{{if .Error}}
+<h2>Unable to connect {{if .App}}{{.App}}{{else}}the app{{end}}</h2>
+<p>{{.Error}}</p>
+<p>Please return to the Verbis desktop app and try again.</p>
+{{else}}
+<h2>{{.App}} is connected{{if .User}} as {{.User}}{{end}}</h2>
+<p>You may close this tab and return to the Verbis desktop app.</p>
+{{end}}
+</body>
+</html>
+`))
+
 func (a *API) authComplete(w http.ResponseWriter, r *http.Request) {
-	// TODO: render page telling the user to go back to the desktop app
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Auth complete"))
+	data := struct {
+		App   string
+		User  string
+		Error string
+	}{
+		Error: r.URL.Query().Get("error"),
+	}
+	if conn := a.Syncer.GetConnector(r.URL.Query().Get("connector_id")); conn != nil {
+		data.App = string(conn.Type())
+		data.User = conn.User()
+	}
+	if data.Error == "" && data.App == "" {
+		data.Error = "unknown connector"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if data.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	err := authCompleteTemplate.Execute(w, data)
+	if err != nil {
+		log.Printf("Failed to render auth complete page: %s", err)
+	}
 }
 
 func (a *API) connectorInit(w http.ResponseWriter, r *http.Request) {
@@ -278,7 +770,7 @@ func (a *API) connectorInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	constructor, ok := connectors.AllConnectors[connectorType]
+	constructor, ok := connectors.ConnectorConstructor(connectorType)
 	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Unknown connector name"))
@@ -329,14 +821,40 @@ func (a *API) connectorAuthSetup(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Unknown connector ID"))
 		return
 	}
-	err := conn.AuthSetup(r.Context())
+	authURL, err := conn.AuthSetup(r.Context())
 	if err != nil {
-		log.Printf("Failed to perform initial auth with google: %s", err)
+		log.Printf("Failed to perform initial auth: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to perform initial auth with google: " + err.Error()))
+		w.Write([]byte("Failed to perform initial auth: " + err.Error()))
 		return
 	}
 
+	if authURL != "" {
+		if a.Context.Config.Headless {
+			// Printed for the operator of a headless daemon, e.g. in the journal
+			fmt.Printf("Visit this URL to connect %s connector %s:\n%s\n", conn.Type(), conn.ID(), authURL)
+		} else {
+			err = openBrowser(authURL)
+			if err != nil {
+				log.Printf("Unable to open auth URL in browser: %s", err)
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(AuthSetupResponse{AuthURL: authURL})
+}
+
+type AuthSetupResponse struct {
+	// AuthURL is the URL for the user to visit to authenticate, empty if the
+	// connector is already authenticated
+	AuthURL string `json:"auth_url,omitempty"`
+}
+
+// openBrowser opens the URL in the default browser of the user
+func openBrowser(target string) error {
+	if runtime.GOOS == "darwin" {
+		return exec.Command("open", target).Start()
+	}
+	return exec.Command("xdg-open", target).Start()
 }
 
 func (a *API) handleConnectorDelete(w http.ResponseWriter, r *http.Request) {
@@ -356,151 +874,372 @@ func (a *API) handleConnectorDelete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type ConnectorSettingsResponse struct {
+	// Schema is a JSON schema of Settings, from which clients render a form
+	Schema   map[string]interface{} `json:"schema"`
+	Settings interface{}            `json:"settings"`
+}
+
+func (a *API) getConnectorSettings(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+
+	b, err := json.Marshal(ConnectorSettingsResponse{
+		Schema:   conn.SettingsSchema(),
+		Settings: conn.Settings(),
+	})
+	if err != nil {
+		log.Printf("Failed to marshal settings: %s", err)
+		http.Error(w, "Failed to marshal settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// updateConnectorSettings accepts a full or partial settings object, and
+// returns the resulting settings
+func (a *API) updateConnectorSettings(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	err = a.Syncer.UpdateSettings(r.Context(), conn, body)
+	if connectors.IsErrInvalidSettings(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, connectors.ErrSettingsWhileSyncing) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update settings: %s", err)
+		http.Error(w, "Failed to update settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.getConnectorSettings(w, r)
+}
+
 func (a *API) handleConnectorCallback(w http.ResponseWriter, r *http.Request) {
 	queryParts := r.URL.Query()
+
+	// The state is single use, so it is consumed even if the callback fails
+	session, err := connectors.ConsumeAuthSession(queryParts.Get("state"))
+	if err != nil {
+		log.Printf("Rejected auth callback: %s", err)
+		redirectAuthComplete(w, r, "", err)
+		return
+	}
+	conn := a.Syncer.GetConnector(session.ConnectorID)
+	if conn == nil {
+		redirectAuthComplete(w, r, "", fmt.Errorf("unknown connector ID"))
+		return
+	}
+
+	// For some connectors, the redirectURI must be static. In that case the
+	// callback URL has the connector type rather than its ID.
+	pathID := mux.Vars(r)["connector_id"]
+	if pathID != session.ConnectorID && pathID != string(conn.Type()) {
+		log.Printf("Rejected auth callback for %s with state of connector %s", pathID, session.ConnectorID)
+		redirectAuthComplete(w, r, "", fmt.Errorf("state does not match connector"))
+		return
+	}
+
+	errStr := queryParts.Get("error")
+	if errStr != "" {
+		if desc := queryParts.Get("error_description"); desc != "" {
+			errStr = desc
+		}
+		log.Printf("Error in auth callback of connector %s: %s", session.ConnectorID, errStr)
+		redirectAuthComplete(w, r, session.ConnectorID, errors.New(errStr))
+		return
+	}
 	// Google returns it as "code"
 	code := queryParts.Get("code")
 	if code == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("No code in request"))
+		redirectAuthComplete(w, r, session.ConnectorID, fmt.Errorf("no code in request"))
 		return
 	}
 
-	errStr := queryParts.Get("error")
-	if errStr != "" {
-		log.Printf("Error in Google callback: %s\n", errStr)
+	err = conn.AuthCallback(r.Context(), code, session.Verifier)
+	if err != nil {
+		log.Printf("Failed to complete auth callback: %s\n", err)
+		redirectAuthComplete(w, r, session.ConnectorID, fmt.Errorf("failed to complete auth callback: %v", err))
+		return
 	}
 
-	vars := mux.Vars(r)
-	connectorID, ok := vars["connector_id"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("No connector name provided"))
+	// TODO: delegate this logic to the connector implementation
+	err = a.store.SetConnectorAuth(a.Context, conn.ID(), true, "")
+	if err != nil {
+		log.Printf("Failed to update connector state: %s", err)
+		redirectAuthComplete(w, r, session.ConnectorID, fmt.Errorf("failed to update connector state: %v", err))
 		return
 	}
-	stateParam := queryParts.Get("state")
 
-	// For some connectors, the redirectURI must be static. In that case we
-	// expect the callback URL to be the connector type.
-	if connectors.IsConnectorType(connectorID) {
-		// If any state is provided it must match the connector ID
-		connectorID = stateParam
-	} else {
-		if stateParam != "" && stateParam != connectorID {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("State does not match connector ID"))
-			return
-		}
+	// Sync the new connector ahead of the others, it should silently quit if
+	// a sync is already running for this connector
+	a.Syncer.PrioritizeSync(session.ConnectorID)
+
+	redirectAuthComplete(w, r, session.ConnectorID, nil)
+}
+
+// redirectAuthComplete sends the browser to the page showing the outcome of
+// an OAuth flow, so that reloading it does not replay the callback
+func redirectAuthComplete(w http.ResponseWriter, r *http.Request, connectorID string, authErr error) {
+	query := url.Values{}
+	if connectorID != "" {
+		query.Set("connector_id", connectorID)
 	}
+	if authErr != nil {
+		query.Set("error", authErr.Error())
+	}
+	http.Redirect(w, r, "/connectors/auth_complete?"+query.Encode(), http.StatusSeeOther)
+}
 
-	conn := a.Syncer.GetConnector(connectorID)
+type ForceSyncResponse struct {
+	// ConnectorIDs are the connectors queued for a sync. Paused connectors
+	// and those without valid credentials are skipped when their turn comes.
+	ConnectorIDs []string `json:"connector_ids"`
+}
+
+func (a *API) forceSync(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(ForceSyncResponse{ConnectorIDs: a.Syncer.SyncNow()})
+	if err != nil {
+		log.Printf("Failed to marshal response: %s", err)
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+type ConnectorScheduleRequest struct {
+	// Schedule is a sync interval such as "30m", or a cron expression. An
+	// empty schedule restores the default interval.
+	Schedule string `json:"schedule"`
+}
+
+func (a *API) updateConnectorSchedule(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
 	if conn == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unknown connector ID"))
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
 		return
 	}
-	err := conn.AuthCallback(r.Context(), code)
+
+	var req ConnectorScheduleRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		log.Printf("Failed to complete auth callback: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to complete auth callback : " + err.Error()))
+		http.Error(w, "Failed to decode request", http.StatusBadRequest)
 		return
 	}
 
-	state, err := conn.Status(a.Context)
+	state, err := a.Syncer.SetSchedule(r.Context(), conn, req.Schedule)
 	if err != nil {
-		log.Printf("Failed to get connector state: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to get connector state: " + err.Error()))
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	state.AuthValid = true // TODO: delegate this logic to the connector implementation
-	err = conn.UpdateConnectorState(a.Context, state)
+	writeConnectorState(w, state)
+}
+
+func writeConnectorState(w http.ResponseWriter, state *types.ConnectorState) {
+	b, err := json.Marshal(state)
 	if err != nil {
-		log.Printf("Failed to update connector state: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to update connector state: " + err.Error()))
+		log.Printf("Failed to marshal state: %s", err)
+		http.Error(w, "Failed to marshal state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// connectorSync syncs a connector ahead of its schedule
+func (a *API) connectorSync(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+
+	state, err := conn.Status(r.Context())
+	if err != nil {
+		log.Printf("Failed to get connector state: %s", err)
+		http.Error(w, "Failed to get connector state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if state.Paused {
+		http.Error(w, "Connector is paused", http.StatusConflict)
 		return
 	}
+	a.Syncer.PrioritizeSync(conn.ID())
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	// Trigger a background sync, it should silently quit if a sync is already
-	// running for this connector
-	a.Syncer.ASyncNow(a.Context)
+func (a *API) connectorPause(w http.ResponseWriter, r *http.Request) {
+	a.setConnectorPaused(w, r, true)
+}
 
-	// TODO: Render a proper done page
-	w.Write([]byte("Application authentication is complete, you may close this tab and return to the Verbis desktop app"))
+func (a *API) connectorResume(w http.ResponseWriter, r *http.Request) {
+	a.setConnectorPaused(w, r, false)
 }
 
-func (a *API) forceSync(w http.ResponseWriter, r *http.Request) {
-	err := a.Syncer.SyncNow(a.Context)
+func (a *API) setConnectorPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+
+	state, err := a.Syncer.SetPaused(r.Context(), conn, paused)
 	if err != nil {
-		log.Printf("Failed to sync: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to sync: " + err.Error()))
+		log.Printf("Failed to pause or resume connector: %s", err)
+		http.Error(w, "Failed to update connector: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeConnectorState(w, state)
 }
 
-type PullRequestPayload struct {
-	Name   string `json:"name"`
-	Stream bool   `json:"stream"`
+// connectorCancel stops the running sync of a connector. The next scheduled
+// sync resumes where it stopped.
+func (a *API) connectorCancel(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+
+	if !a.Syncer.CancelSync(conn.ID()) {
+		http.Error(w, "Connector is not syncing", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
-type PullApiResponse struct {
-	Status string `json:"status"`
+// queryLimit reads the limit query parameter of a listing
+func queryLimit(r *http.Request, defaultLimit, maxLimit int) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return limit, nil
 }
 
-// pullModel makes a POST request to the specified URL with the given payload
-// and returns nil only if the response status is "success".
-func pullModel(name string, stream bool) error {
-	url := fmt.Sprintf("http://%s/api/pull", OllamaHost)
+// listConnectorRuns returns the latest sync runs of a connector, most recent
+// first
+func (a *API) listConnectorRuns(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+	limit, err := queryLimit(r, 20, MaxSyncRunsKept)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Create the payload
-	payload := PullRequestPayload{
-		Name:   name,
-		Stream: stream,
+	runs, err := a.store.ListSyncRuns(r.Context(), conn.ID(), limit)
+	if err != nil {
+		log.Printf("Failed to list sync runs: %s", err)
+		http.Error(w, "Failed to list sync runs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(runs)
+	if err != nil {
+		log.Printf("Failed to marshal sync runs: %s", err)
+		http.Error(w, "Failed to marshal sync runs: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Write(b)
+}
 
-	// Marshal the payload into JSON
-	jsonData, err := json.Marshal(payload)
+// listConnectorErrors returns the latest items of a connector that failed
+// to sync, most recent first
+func (a *API) listConnectorErrors(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
+	}
+	limit, err := queryLimit(r, 100, MaxSyncErrorsKept)
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Create a new HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	syncErrors, err := a.store.ListSyncErrors(r.Context(), conn.ID(), limit)
+	if err != nil {
+		log.Printf("Failed to list sync errors: %s", err)
+		http.Error(w, "Failed to list sync errors: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(syncErrors)
 	if err != nil {
-		return err
+		log.Printf("Failed to marshal sync errors: %s", err)
+		http.Error(w, "Failed to marshal sync errors: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Write(b)
+}
 
-	// Set the Content-Type header
-	req.Header.Set("Content-Type", "application/json")
+type RetryErrorsResponse struct {
+	// NumItems is the number of documents to be synced again
+	NumItems int `json:"num_items"`
+}
 
-	// Make the HTTP request using the default client
-	client := &http.Client{}
-	response, err := client.Do(req)
-	if err != nil {
-		return err
+// retryConnectorErrors syncs again the items of a connector that failed, as
+// soon as possible
+func (a *API) retryConnectorErrors(w http.ResponseWriter, r *http.Request) {
+	conn := a.Syncer.GetConnector(mux.Vars(r)["connector_id"])
+	if conn == nil {
+		http.Error(w, "Unknown connector ID", http.StatusNotFound)
+		return
 	}
-	defer response.Body.Close()
 
-	// Read the response body
-	responseData, err := io.ReadAll(response.Body)
+	state, err := conn.Status(r.Context())
 	if err != nil {
-		return err
+		log.Printf("Failed to get connector state: %s", err)
+		http.Error(w, "Failed to get connector state: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
-
-	// Unmarshal JSON data into ApiResponse struct
-	var apiResponse PullApiResponse
-	if err := json.Unmarshal(responseData, &apiResponse); err != nil {
-		return err
+	if state.Paused {
+		http.Error(w, "Connector is paused", http.StatusConflict)
+		return
 	}
 
-	// Check if the status is "success"
-	if apiResponse.Status != "success" {
-		return fmt.Errorf("API response status is not 'success'")
+	numItems, err := a.Syncer.RetryFailedItems(r.Context(), conn)
+	if errors.Is(err, ErrRetryNotSupported) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to retry sync errors: %s", err)
+		http.Error(w, "Failed to retry sync errors: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	return nil
+	b, err := json.Marshal(RetryErrorsResponse{NumItems: numItems})
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(b)
 }
 
 // Struct to define the request payload
@@ -532,7 +1271,9 @@ type PromptRequest struct {
 }
 
 type StreamResponseHeader struct {
-	Sources []map[string]string `json:"sources"` // Only returned on the first response
+	Sources []types.Source `json:"sources"` // Only returned on the first response
+	// Set when no answer follows the sources, as generation is not ready
+	GenerationError string `json:"generation_error,omitempty"`
 }
 
 func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
@@ -546,6 +1287,12 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Search only needs the embeddings model, generation may still be down
+	if !a.Context.Ready(BootStateSyncing) {
+		http.Error(w, "Embeddings model is not ready yet", http.StatusServiceUnavailable)
+		return
+	}
+
 	var promptReq PromptRequest
 	defer r.Body.Close()
 	err := json.NewDecoder(r.Body).Decode(&promptReq)
@@ -564,7 +1311,10 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Call Ollama embeddings model to get embeddings for the prompt
-	resp, err := EmbedFromModel(promptReq.Prompt)
+	// Queries are embedded with the model of the index searched, which may
+	// be switched at any time
+	index := a.store.ActiveEmbeddingIndex()
+	embeddings, err := a.embedder.EmbedQuery(r.Context(), index, promptReq.Prompt)
 	if err != nil {
 		log.Printf("Failed to get embeddings: %s", err)
 		http.Error(w, "Failed to get embeddings "+err.Error(), http.StatusInternalServerError)
@@ -572,12 +1322,12 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 	}
 	embedTime := time.Now()
 
-	embeddings := resp.Embedding
 	log.Printf("Performing vector search")
 
 	// Perform vector similarity search and get list of most relevant results
 	searchResults, err := a.store.HybridSearch(
 		r.Context(),
+		index,
 		promptReq.Prompt,
 		embeddings,
 	)
@@ -611,12 +1361,15 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 		hashes[chunk.Hash] = true
 	}
 
-	// Rerank the results
-	rerankedChunks, err := Rerank(r.Context(), searchResults, promptReq.Prompt)
-	if err != nil {
-		log.Printf("Failed to rerank search results: %s", err)
-		http.Error(w, "Failed to rerank search results", http.StatusInternalServerError)
-		return
+	// Rerank the results, or keep the best ones until the reranker is loaded
+	rerankedChunks := searchResults[:min(len(searchResults), MaxNumRerankedChunks)]
+	if a.Context.RerankerReady.Load() {
+		rerankedChunks, err = Rerank(r.Context(), searchResults, promptReq.Prompt)
+		if err != nil {
+			log.Printf("Failed to rerank search results: %s", err)
+			http.Error(w, "Failed to rerank search results", http.StatusInternalServerError)
+			return
+		}
 	}
 	rerankTime := time.Now()
 
@@ -633,7 +1386,22 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 		hashes[chunk.Hash] = true
 	}
 
-	llmPrompt := MakePrompt(rerankedChunks, promptReq.Prompt)
+	sourcesObj := sourcesFromChunks(rerankedChunks)
+
+	// Until generation is ready, the sources are returned without an answer
+	if !a.Context.Ready(BootStateGen) {
+		err = json.NewEncoder(w).Encode(StreamResponseHeader{
+			Sources:         sourcesObj,
+			GenerationError: "Generation model is not ready yet",
+		})
+		if err != nil {
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	neighbours := GetNeighbours(r.Context(), a.store, rerankedChunks)
+	llmPrompt := MakePrompt(rerankedChunks, neighbours, promptReq.Prompt)
 	log.Printf("LLM Prompt: %s", llmPrompt)
 	err = WritePromptLog(llmPrompt)
 	if err != nil {
@@ -643,7 +1411,7 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 	}
 
 	streamChan := make(chan StreamResponse)
-	err = chatWithModelStream(r.Context(), llmPrompt, generationModelName, conversation.History, streamChan)
+	err = chatWithModelStream(r.Context(), llmPrompt, GenerationModel(), conversation.History, streamChan)
 	if err != nil {
 		log.Printf("Failed to generate response: %s", err)
 		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
@@ -657,24 +1425,9 @@ func (a *API) handlePrompt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sourcesObj := sourcesFromChunks(rerankedChunks)
-	sourcesObjJSON, marshalSourcesErr := json.Marshal(sourcesObj)
-	if marshalSourcesErr != nil {
-		log.Printf("Failed to marshal sources: %s", marshalSourcesErr)
-		http.Error(w, "Failed to marshal sources", http.StatusInternalServerError)
-		return
-	}
-	var sources []map[string]string
-	unmarshalSourcesErr := json.Unmarshal(sourcesObjJSON, &sources)
-	if unmarshalSourcesErr != nil {
-		log.Printf("Failed to unmarshal sources: %s", unmarshalSourcesErr)
-		http.Error(w, "Failed to unmarshal sources", http.StatusInternalServerError)
-		return
-	}
-
 	// First write the header response
 	err = json.NewEncoder(w).Encode(StreamResponseHeader{
-		Sources: sources,
+		Sources: sourcesObj,
 	})
 	if err != nil {
 		http.Error(w, "Failed to write response", http.StatusInternalServerError)
@@ -35,31 +35,43 @@ func main() {
 		SlackClientSecret: SlackClientSecret,
 		GoogleJSONCreds:   GoogleJSONCreds,
 	}
+	cfg, err := LoadDaemonConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %s\n", err)
+	}
+
 	// Start everything needed to let the user onboard connectors
-	bootCtx, err := BootOnboard(creds, getVersionString())
+	bootCtx, err := BootOnboard(cfg, creds, getVersionString())
 	if err != nil {
 		log.Fatalf("Failed to boot until onboarding: %s\n", err)
 	}
 	log.Printf("Boot: Ready to onboard connectors")
 	defer bootCtx.Logfile.Close()
 
-	// Start everything needed for syncing
-	// Pulls embeddings model
-	err = BootSyncing(bootCtx)
-	if err != nil {
-		log.Fatalf("Failed to boot until syncing: %s\n", err)
-	}
-	log.Printf("Boot: Ready to sync")
+	// Later stages retry their steps until they succeed, and only fail when
+	// the app terminates
+	go func() {
+		// Start everything needed for syncing
+		// Pulls embeddings model
+		err := BootSyncing(bootCtx)
+		if err != nil {
+			log.Printf("Failed to boot until syncing: %s\n", err)
+			return
+		}
+		log.Printf("Boot: Ready to sync")
 
-	// Start everything needed for generation
-	// Pulls generation and reranking models
-	err = BootGen(bootCtx)
-	if err != nil {
-		log.Fatalf("Failed to boot until generation: %s\n", err)
-	}
-	log.Printf("Boot: Ready to generate")
+		// Start everything needed for generation
+		// Pulls generation and reranking models
+		err = BootGen(bootCtx)
+		if err != nil {
+			log.Printf("Failed to boot until generation: %s\n", err)
+			return
+		}
+		log.Printf("Boot: Ready to generate")
+	}()
 
 	<-bootCtx.Done() // Block until the app terminates
+	bootCtx.Supervisor.Wait()
 }
 
 func getVersionString() string {
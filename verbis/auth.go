@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/verbis-ai/verbis/verbis/keychain"
+	"github.com/verbis-ai/verbis/verbis/util"
+)
+
+const (
+	// Relative to the data dir, readable only by the user
+	APITokenFile         = "api_token"
+	APIReadOnlyTokenFile = "api_token_readonly"
+	WeaviateAPIKeyFile   = "weaviate_api_key"
+
+	apiTokenKey         = "api-token"
+	apiReadOnlyTokenKey = "api-readonly-token"
+)
+
+// Routes reachable without a token: the OAuth provider redirects the browser
+// to the callback, and health is polled before the app has read the token
+var publicRoutes = map[string]bool{
+	"/health":                             true,
+	"/connectors/{connector_id}/callback": true,
+	"/connectors/auth_complete":           true,
+}
+
+// Routes that do not change state, the only ones allowed to read-only
+// tokens, keyed by method and path template
+var readOnlyRoutes = map[string]bool{
+	"GET /connectors":                         true,
+	"GET /connectors/{connector_id}/settings": true,
+	"GET /connectors/{connector_id}/runs":     true,
+	"GET /connectors/{connector_id}/errors":   true,
+	"GET /conversations":                      true,
+	"GET /conversations/{conversation_id}":    true,
+	"GET /config":                             true,
+	"GET /models":                             true,
+	"GET /embeddings":                         true,
+	"GET /events":                             true,
+	"GET /debug/documents/{unique_id}":        true,
+}
+
+// Routes accepting the token as the access_token query parameter, as
+// EventSource cannot set headers
+var queryTokenRoutes = map[string]bool{
+	"/events": true,
+}
+
+// APIAuth authenticates API requests with bearer tokens. The full token is
+// used by the desktop app, the read-only token lets integrations read data
+// without modifying it.
+type APIAuth struct {
+	token         string
+	readOnlyToken string
+}
+
+// setupAPIAuth loads the API tokens from the secret store, generating them on
+// first boot, and writes them to files for the desktop app and integrations
+func setupAPIAuth() (*APIAuth, error) {
+	token, err := loadOrCreateToken(apiTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	readOnlyToken, err := loadOrCreateToken(apiReadOnlyTokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for file, t := range map[string]string{
+		APITokenFile:         token,
+		APIReadOnlyTokenFile: readOnlyToken,
+	} {
+		path, err := util.DataPath(file)
+		if err != nil {
+			return nil, err
+		}
+		err = writeTokenFile(path, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &APIAuth{token: token, readOnlyToken: readOnlyToken}, nil
+}
+
+func loadOrCreateToken(key string) (string, error) {
+	store := keychain.Store()
+	token, err := store.Get(key)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, keychain.ErrNotFound) {
+		return "", fmt.Errorf("unable to get %s from secret store: %v", key, err)
+	}
+
+	token, err = generateToken()
+	if err != nil {
+		return "", fmt.Errorf("unable to generate %s: %v", key, err)
+	}
+	err = store.Set(key, token)
+	if err != nil {
+		return "", fmt.Errorf("unable to save %s to secret store: %v", key, err)
+	}
+	log.Printf("Generated new %s", key)
+	return token, nil
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func writeTokenFile(path, token string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create token directory: %v", err)
+	}
+	err = os.WriteFile(path, []byte(token), 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write token file: %v", err)
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, 0600)
+}
+
+// Middleware rejects requests without a valid token, and requests of
+// read-only tokens to routes that change state
+func (a *APIAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		if publicRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" && queryTokenRoutes[route] {
+			token = r.URL.Query().Get("access_token")
+		}
+
+		switch {
+		case tokenEqual(token, a.token):
+			next.ServeHTTP(w, r)
+		case tokenEqual(token, a.readOnlyToken):
+			if !readOnlyRoutes[r.Method+" "+route] {
+				http.Error(w, "Read-only token not allowed for this request", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Missing or invalid API token", http.StatusUnauthorized)
+		}
+	})
+}
+
+func tokenEqual(token, expected string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
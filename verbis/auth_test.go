@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIAuthMiddleware(t *testing.T) {
+	auth := &APIAuth{token: "full", readOnlyToken: "readonly"}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	r := mux.NewRouter()
+	r.Use(auth.Middleware)
+	r.HandleFunc("/health", ok).Methods("GET")
+	r.HandleFunc("/connectors", ok).Methods("GET")
+	r.HandleFunc("/connectors/{type}/init", ok).Methods("GET")
+	r.HandleFunc("/connectors/{connector_id}/sync", ok).Methods("POST")
+	r.HandleFunc("/events", ok).Methods("GET")
+	r.HandleFunc("/sync/force", ok).Methods("GET")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{"public", "GET", "/health", "", http.StatusOK},
+		{"missing token", "GET", "/connectors", "", http.StatusUnauthorized},
+		{"invalid token", "GET", "/connectors", "wrong", http.StatusUnauthorized},
+		{"full token", "POST", "/connectors/abc/sync", "full", http.StatusOK},
+		{"read-only list", "GET", "/connectors", "readonly", http.StatusOK},
+		{"read-only init", "GET", "/connectors/gmail/init", "readonly", http.StatusForbidden},
+		{"read-only force sync", "GET", "/sync/force", "readonly", http.StatusForbidden},
+		{"read-only post", "POST", "/connectors/abc/sync", "readonly", http.StatusForbidden},
+		{"query token", "GET", "/events?access_token=readonly", "", http.StatusOK},
+		{"query token elsewhere", "GET", "/connectors?access_token=full", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
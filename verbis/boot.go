@@ -2,39 +2,53 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
 	"github.com/posthog/posthog-go"
 
+	"github.com/verbis-ai/verbis/verbis/connectors"
+	"github.com/verbis-ai/verbis/verbis/keychain"
 	"github.com/verbis-ai/verbis/verbis/store"
 	"github.com/verbis-ai/verbis/verbis/types"
 	"github.com/verbis-ai/verbis/verbis/util"
 )
 
+// Relative to the data dir
 const (
-	masterLogPath      = ".verbis/logs/full.log"
-	WeaviatePersistDir = ".verbis/synced_data"
-	OllamaModelsDir    = ".verbis/ollama/models"
-	OllamaRunnersDir   = ".verbis/ollama/runners"
-	OllamaTmpDir       = ".verbis/ollama/tmp"
-
-	miscModelsPath    = ".verbis/models"
-	rerankerModelName = "ms-marco-MiniLM-L-12-v2"
+	masterLogPath      = "logs/full.log"
+	WeaviatePersistDir = "synced_data"
+	OllamaModelsDir    = "ollama/models"
+	OllamaRunnersDir   = "ollama/runners"
+	OllamaTmpDir       = "ollama/tmp"
+
+	miscModelsPath = "models"
+	// Present once no class needs the vectorizer module of weaviate
+	noVectorizerFile = "weaviate_no_vectorizer"
 )
 
+var rerankerModelName = "ms-marco-MiniLM-L-12-v2"
+
 type BootState string
 
 const (
@@ -44,15 +58,28 @@ const (
 	BootStateGen     = "generating"
 )
 
+// Delays between attempts of a failed boot step
+const (
+	minBootRetryDelay = 5 * time.Second
+	maxBootRetryDelay = 5 * time.Minute
+)
+
 type BootContext struct {
 	context.Context
 	Timers
 	Credentials       types.BuildCredentials
-	State             BootState
+	Config            *DaemonConfig
 	PosthogDistinctID string
 	Syncer            *Syncer
+	Supervisor        *Supervisor
+	Reindexer         *Reindexer
+	RerankerReady     atomic.Bool // Set once a test rerank succeeds
 	Logfile           *os.File
 	Version           string
+
+	stateLock sync.RWMutex
+	state     BootState
+	bootError string // Error of the boot step being retried, if any
 }
 
 type Timers struct {
@@ -69,76 +96,150 @@ func NewBootContext(ctx context.Context, version string) *BootContext {
 		Timers: Timers{
 			StartTime: startTime,
 		},
-		State:             BootStateStarted,
+		state:             BootStateStarted,
 		PosthogDistinctID: uuid.New().String(),
 		Version:           version,
 	}
 }
 
-func BootOnboard(creds types.BuildCredentials, version string) (*BootContext, error) {
-	// Set up logging
-	path, err := GetMasterLogDir()
-	if err != nil {
-		log.Fatalf("Failed to get master log directory: %s", err)
+// Status returns the boot state, and the error of the step being retried
+func (b *BootContext) Status() (BootState, string) {
+	b.stateLock.RLock()
+	defer b.stateLock.RUnlock()
+	return b.state, b.bootError
+}
+
+// Ready tells whether the boot has reached the given state
+func (b *BootContext) Ready(state BootState) bool {
+	order := []BootState{BootStateStarted, BootStateOnboard, BootStateSyncing, BootStateGen}
+	current, _ := b.Status()
+	return slices.Index(order, current) >= slices.Index(order, state)
+}
+
+func (b *BootContext) setState(state BootState) {
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
+	b.state = state
+}
+
+func (b *BootContext) setBootError(msg string) {
+	b.stateLock.Lock()
+	defer b.stateLock.Unlock()
+	b.bootError = msg
+}
+
+// retry runs a boot step until it succeeds or the context is cancelled, with
+// exponential backoff. The error of the last attempt is reported by /health.
+func (b *BootContext) retry(step string, f func() error) error {
+	delay := minBootRetryDelay
+	for {
+		err := f()
+		if err == nil {
+			b.setBootError("")
+			return nil
+		}
+		log.Printf("Boot: failed to %s, retrying in %s: %s", step, delay, err)
+		b.setBootError(fmt.Sprintf("failed to %s: %s", step, err))
+		select {
+		case <-time.After(delay):
+		case <-b.Done():
+			return fmt.Errorf("failed to %s: %w", step, b.Err())
+		}
+		delay = min(2*delay, maxBootRetryDelay)
 	}
+}
 
-	err = os.MkdirAll(filepath.Dir(path), 0755)
-	if err != nil && !os.IsExist(err) {
-		log.Fatalf("Failed to create log directory: %s", err)
+func BootOnboard(cfg *DaemonConfig, creds types.BuildCredentials, version string) (*BootContext, error) {
+	cfg.Apply()
+
+	// Set up logging. Headless, logs go to stderr, e.g. to the journal
+	logFile := os.Stderr
+	if !cfg.Headless {
+		path, err := GetMasterLogDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get master log directory: %v", err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create log directory: %v", err)
+		}
+
+		logFile, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+
+		err = syscall.Dup2(int(logFile.Fd()), int(os.Stderr.Fd()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to redirect stderr to file: %v", err)
+		}
+		os.Stderr = logFile
+		os.Stdout = logFile
+		log.SetOutput(logFile)
 	}
 
-	logFile, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	log.Printf("Starting Verbis boot sequence")
+
+	ollamaPath, weaviatePath, err := cfg.binaryPaths()
 	if err != nil {
-		log.Fatalf("Failed to open log file: %s", err)
+		return nil, fmt.Errorf("failed to get binary paths: %v", err)
 	}
-
-	err = syscall.Dup2(int(logFile.Fd()), int(os.Stderr.Fd()))
+	path, err := util.GetDistPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dist path: %v", err)
+	}
+	weaviatePersistDir, err := util.DataPath(WeaviatePersistDir)
 	if err != nil {
-		log.Fatalf("Failed to redirect stderr to file: %v", err)
+		return nil, fmt.Errorf("failed to get data directory: %v", err)
 	}
-	os.Stderr = logFile
-	os.Stdout = logFile
-	log.SetOutput(logFile)
 
 	ctx, cancel := context.WithCancel(context.Background())
-	log.Printf("Starting Verbis boot sequence")
-
-	// Kill any previous stale processes
-	processesToKill := []string{"ollama", "weaviate"}
-	for _, process := range processesToKill {
-		if err := killProcessByName(process); err != nil {
-			log.Printf("Error killing process %s: %s\n", process, err)
-		}
-	}
 
 	bootCtx := NewBootContext(ctx, version)
 	bootCtx.Logfile = logFile
 	bootCtx.Credentials = creds
+	bootCtx.Config = cfg
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	path, err = util.GetDistPath()
+	ollamaModelsPath, _ := util.DataPath(OllamaModelsDir)
+	ollamaRunnersPath, _ := util.DataPath(OllamaRunnersDir)
+	ollamaTmpDirPath, _ := util.DataPath(OllamaTmpDir)
+	weaviateHost, weaviatePort, _ := net.SplitHostPort(store.WeaviateHost)
+
+	// The gRPC and cluster ports of weaviate listen on every interface and
+	// cannot be bound to loopback, so every request needs a key, generated
+	// on each boot. Scripts read it from the data dir.
+	store.WeaviateAPIKey, err = generateToken()
 	if err != nil {
-		log.Fatalf("Failed to get dist path: %s\n", err)
+		cancel()
+		return nil, fmt.Errorf("failed to generate Weaviate API key: %v", err)
+	}
+	// Vectors are all computed by the embedder. The vectorizer module of
+	// weaviate is only loaded until no class of an older version needs it,
+	// see recordVectorizerUse.
+	weaviateModules := "backup-filesystem"
+	if needsVectorizerModule() {
+		weaviateModules += ",text2vec-ollama"
 	}
-	ollamaPath := filepath.Join(path, util.OllamaFile)
-	weaviatePath := filepath.Join(path, util.WeaviateFile)
 
-	home, err := os.UserHomeDir()
+	weaviateKeyPath, err := util.DataPath(WeaviateAPIKeyFile)
+	if err == nil {
+		err = writeTokenFile(weaviateKeyPath, store.WeaviateAPIKey)
+	}
 	if err != nil {
-		log.Fatalf("unable to get user home directory: %s", err)
+		cancel()
+		return nil, fmt.Errorf("failed to write Weaviate API key: %v", err)
 	}
-	weaviatePersistDir := filepath.Join(home, WeaviatePersistDir)
-	ollamaModelsPath := filepath.Join(home, OllamaModelsDir)
-	ollamaRunnersPath := filepath.Join(home, OllamaRunnersDir)
-	ollamaTmpDirPath := filepath.Join(home, OllamaTmpDir)
 
 	commands := []CmdSpec{
 		{
-			ollamaPath,
-			[]string{"serve"},
-			[]string{
+			Name: "ollama",
+			Path: ollamaPath,
+			Args: []string{"serve"},
+			Env: []string{
 				"OLLAMA_HOST=" + OllamaHost,
 				"OLLAMA_KEEP_ALIVE=" + KeepAliveTime,
 				"OLLAMA_MAX_LOADED_MODELS=2",
@@ -148,60 +249,118 @@ func BootOnboard(creds types.BuildCredentials, version string) (*BootContext, er
 				"OLLAMA_RUNNERS_DIR=" + ollamaRunnersPath,
 				"OLLAMA_TMPDIR=" + ollamaTmpDirPath,
 			},
+			HealthCheck: checkOllama,
 		},
 		{
-			weaviatePath,
-			[]string{"--host", "0.0.0.0", "--port", "8088", "--scheme", "http"},
-			[]string{
+			Name: "weaviate",
+			Path: weaviatePath,
+			Args: []string{"--host", weaviateHost, "--port", weaviatePort, "--scheme", "http"},
+			Env: []string{
 				"LIMIT_RESOURCES=true",
 				"DISABLE_TELEMETRY=true",
 				"PERSISTENCE_DATA_PATH=" + weaviatePersistDir,
-				"AUTHENTICATION_ANONYMOUS_ACCESS_ENABLED=true",
-				"ENABLE_MODULES=backup-filesystem,text2vec-ollama",
+				"AUTHENTICATION_ANONYMOUS_ACCESS_ENABLED=false",
+				"AUTHENTICATION_APIKEY_ENABLED=true",
+				"AUTHENTICATION_APIKEY_ALLOWED_KEYS=" + store.WeaviateAPIKey,
+				"AUTHENTICATION_APIKEY_USERS=verbis",
+				"CLUSTER_ADVERTISE_ADDR=127.0.0.1",
+				"CLUSTER_BASIC_AUTH_USERNAME=verbis",
+				"CLUSTER_BASIC_AUTH_PASSWORD=" + store.WeaviateAPIKey,
+				"ENABLE_MODULES=" + weaviateModules,
 				"BACKUP_FILESYSTEM_PATH=" + weaviatePersistDir + "/backup",
-				"DEFAULT_VECTORIZER_MODULE=text2vec-ollama",
 			},
+			HealthCheck: checkWeaviate,
 		},
 	}
 
-	startSubprocesses(ctx, commands, logFile, logFile)
+	logDir, _ := util.DataPath(processLogDir)
+	pidDir, _ := util.DataPath(processPIDDir)
+	bootCtx.Supervisor = NewSupervisor(logDir, pidDir)
+	err = bootCtx.Supervisor.Start(ctx, commands)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start subprocesses: %v", err)
+	}
+
+	// The servers start with the health check alone, so that the errors of
+	// the boot steps retried below can be read
+	corsHeaders := handlers.CORS(
+		handlers.AllowedOrigins([]string{"http://localhost:3000"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+	)
+	bootAPI := &API{Context: bootCtx, Version: version}
+	healthRouter := mux.NewRouter()
+	healthRouter.HandleFunc("/health", bootAPI.health).Methods("GET")
+	handler := &switchHandler{}
+	handler.set(corsHeaders(healthRouter))
+
+	certPath := filepath.Join(path, "certs/localhost.pem")
+	keyPath := filepath.Join(path, "certs/localhost-key.pem")
+	startServers(ctx, bootCtx, cfg, handler, certPath, keyPath, sigChan, cancel)
 
 	err = waitForWeaviate(ctx)
 	if err != nil {
-		log.Fatalf("Failed to wait for Weaviate: %s\n", err)
+		cancel()
+		return nil, fmt.Errorf("failed to wait for Weaviate: %v", err)
 	}
 
-	weaviateStore := store.NewWeaviateStore(
-		fmt.Sprintf("http://%s", OllamaHost),
-		embeddingsModelName,
-	)
+	// Chunks and queries are embedded the same way
+	embedder := NewOllamaEmbedder()
+	weaviateStore := store.NewWeaviateStore(embedder, embeddingsModelName)
 	weaviateStore.CreateDocumentClass(ctx, clean)
 	weaviateStore.CreateConnectorStateClass(ctx, clean)
-	weaviateStore.CreateChunkClass(ctx, clean)
+	weaviateStore.CreateConnectorSettingsClass(ctx, clean)
+	weaviateStore.CreateSyncRunClass(ctx, clean)
+	weaviateStore.CreateSyncErrorClass(ctx, clean)
 	weaviateStore.CreateConversationClass(ctx, clean)
 	weaviateStore.CreateConfigClass(ctx, clean)
-
-	cfg, err := weaviateStore.GetConfig(ctx)
+	// Chunks cannot be searched or written until the active index is known
+	err = bootCtx.retry("create chunk classes", func() error {
+		return weaviateStore.CreateChunkClass(ctx, clean)
+	})
 	if err != nil {
-		log.Fatalf("Failed to get config: %s\n", err)
+		cancel()
+		return nil, err
 	}
+	recordVectorizerUse(ctx, weaviateStore)
 
-	if cfg == nil {
+	var userCfg *types.Config
+	err = bootCtx.retry("get config", func() error {
+		userCfg, err = weaviateStore.GetConfig(ctx)
+		if err != nil || userCfg != nil {
+			return err
+		}
 		// Set initial config if one doesn't exist
 		err = weaviateStore.UpdateConfig(ctx, &types.Config{
 			EnableTelemetry: true,
 		})
 		if err != nil {
-			log.Fatalf("Failed to create initial config: %s\n", err)
-		}
-		cfg, err = weaviateStore.GetConfig(ctx)
-		if err != nil {
-			log.Fatalf("Failed to get config after update: %s\n", err)
+			return fmt.Errorf("failed to create initial config: %v", err)
 		}
+		userCfg, err = weaviateStore.GetConfig(ctx)
+		return err
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if userCfg.GenerationModel != "" {
+		SetGenerationModel(userCfg.GenerationModel)
+	}
+
+	// A misconfigured secret store cannot be fixed by retrying
+	err = setupSecretStore(ctx, weaviateStore)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up secret store: %v", err)
 	}
 
 	var postHogClient posthog.Client
-	if cfg.EnableTelemetry {
+	if PosthogAPIKey == "n/a" {
+		log.Printf("Posthog API key not set, telemetry disabled")
+	} else if bootCtx.Config.TelemetryEnabled(userCfg.EnableTelemetry) {
 		postHogClient, err = posthog.NewWithConfig(
 			PosthogAPIKey,
 			posthog.Config{
@@ -211,41 +370,106 @@ func BootOnboard(creds types.BuildCredentials, version string) (*BootContext, er
 			},
 		)
 		if err != nil {
-			log.Fatalf("Failed to create PostHog client: %s\n", err)
+			log.Printf("Failed to create PostHog client, telemetry disabled: %s", err)
+			postHogClient = nil
 		}
 	}
 
-	certPath := filepath.Join(path, "certs/localhost.pem")
-	keyPath := filepath.Join(path, "certs/localhost-key.pem")
-
 	syncer := NewSyncer(postHogClient, bootCtx.PosthogDistinctID, bootCtx.Credentials, bootCtx.Version, weaviateStore)
-	if PosthogAPIKey == "n/a" {
-		log.Fatalf("Posthog API key not set\n")
-	}
 	bootCtx.Syncer = syncer
+	bootCtx.Reindexer = NewReindexer(weaviateStore)
 	api := API{
 		Syncer:            syncer,
 		Posthog:           postHogClient,
 		PosthogDistinctID: bootCtx.PosthogDistinctID,
 		Context:           bootCtx,
 		Version:           version,
+		Reindexer:         bootCtx.Reindexer,
+		embedder:          embedder,
 		store:             weaviateStore,
 	}
 	router := api.SetupRouter()
 
-	corsHeaders := handlers.CORS(
-		handlers.AllowedOrigins([]string{"http://localhost:3000"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
-	)
-	handler := corsHeaders(router)
+	apiAuth, err := setupAPIAuth()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up API authentication: %v", err)
+	}
+	router.Use(apiAuth.Middleware)
+	handler.set(corsHeaders(router))
 
+	bootCtx.OnboardTime = time.Now()
+	bootCtx.setState(BootStateOnboard)
+	return bootCtx, nil
+}
+
+// needsVectorizerModule tells whether weaviate may hold a class vectorized
+// by an older version, unless the last boot found none
+func needsVectorizerModule() bool {
+	path, err := util.DataPath(noVectorizerFile)
+	if err != nil {
+		return true
+	}
+	_, err = os.Stat(path)
+	return err != nil
+}
+
+// recordVectorizerUse records whether the vectorizer module of weaviate is
+// still needed on the next boot. Classes vectorized by an older version are
+// only dropped once their chunks are embedded again and the index deleted.
+func recordVectorizerUse(ctx context.Context, st types.Store) {
+	path, err := util.DataPath(noVectorizerFile)
+	if err != nil {
+		log.Printf("Failed to get path of %s: %s", noVectorizerFile, err)
+		return
+	}
+	used, err := st.UsesWeaviateVectorizer(ctx)
+	if err != nil {
+		log.Printf("Failed to check for classes vectorized by weaviate: %s", err)
+		return
+	}
+	if used {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %s", path, err)
+		}
+		return
+	}
+	err = os.WriteFile(path, nil, 0600)
+	if err != nil {
+		log.Printf("Failed to write %s: %s", path, err)
+	}
+}
+
+// switchHandler serves the current handler, replaced once the API is set up
+type switchHandler struct {
+	lock    sync.RWMutex
+	handler http.Handler
+}
+
+func (h *switchHandler) set(handler http.Handler) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.handler = handler
+}
+
+func (h *switchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.RLock()
+	handler := h.handler
+	h.lock.RUnlock()
+	handler.ServeHTTP(w, r)
+}
+
+// startServers serves the API over HTTP and HTTPS until the context is
+// cancelled or a termination signal is received
+func startServers(ctx context.Context, bootCtx *BootContext, cfg *DaemonConfig, handler http.Handler, certPath, keyPath string, sigChan chan os.Signal, cancel context.CancelFunc) {
+	httpAddr, httpsAddr := cfg.HTTPAddr, cfg.HTTPSAddr
 	server := http.Server{
-		Addr:    ":8081",
+		Addr:    httpAddr,
 		Handler: handler,
 	}
 	httpsServer := http.Server{
-		Addr:    ":8082",
+		Addr:    httpsAddr,
 		Handler: handler,
 	}
 
@@ -264,36 +488,80 @@ func BootOnboard(creds types.BuildCredentials, version string) (*BootContext, er
 	}()
 
 	go func() {
-		log.Print("Starting HTTP server on port 8081")
-		log.Fatal(server.ListenAndServe())
+		log.Printf("Starting HTTP server on %s", httpAddr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			// Nothing can reach the daemon without its API
+			log.Fatalf("Failed to start HTTP server: %s", err)
+		}
 	}()
 
 	go func() {
-		log.Print("Starting HTTPS server on port 8082")
-		log.Fatal(httpsServer.ListenAndServeTLS(certPath, keyPath))
+		log.Printf("Starting HTTPS server on %s", httpsAddr)
+		// Only needed for the Slack OAuth callback
+		err := httpsServer.ListenAndServeTLS(certPath, keyPath)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Failed to start HTTPS server: %s", err)
+		}
 	}()
+}
 
-	bootCtx.State = BootStateOnboard
-	bootCtx.OnboardTime = time.Now()
-	return bootCtx, nil
+// setupSecretStore selects the store of connector secrets from the
+// environment, and moves the secrets of all connectors into it when a
+// migration is requested
+func setupSecretStore(ctx context.Context, st types.Store) error {
+	cfg, err := keychain.SecretStoreConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	secretStore, err := keychain.NewSecretStore(cfg.Backend, cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("Using %s secret store", secretStore.Name())
+
+	if cfg.MigrateFrom != "" && cfg.MigrateFrom != secretStore.Name() {
+		from, err := keychain.NewSecretStore(cfg.MigrateFrom, cfg)
+		if err != nil {
+			return fmt.Errorf("unable to open secret store to migrate from: %v", err)
+		}
+		states, err := st.AllConnectorStates(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get connector states: %v", err)
+		}
+		keys := []string{}
+		for _, state := range states {
+			keys = append(keys, keychain.TokenKey(state.ConnectorID, types.ConnectorType(state.ConnectorType)))
+		}
+		moved, err := keychain.Migrate(from, secretStore, keys)
+		if err != nil {
+			return fmt.Errorf("failed to migrate secrets from %s: %v", from.Name(), err)
+		}
+		log.Printf("Migrated %d secrets from %s to %s secret store", moved, from.Name(), secretStore.Name())
+	}
+
+	keychain.SetStore(secretStore)
+	return nil
 }
 
-func waitForOllama(ctx context.Context) error {
-	ollama_url := fmt.Sprintf("http://%s", OllamaHost)
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+func waitForWeaviate(ctx context.Context) error {
+	return waitFor(ctx, "Weaviate", checkWeaviate)
+}
 
-	// Poll the ollama URL every 5 seconds until the context is cancelled
+// waitFor polls the health check every 5 seconds until it passes or the
+// context is cancelled
+func waitFor(ctx context.Context, name string, check func(context.Context) error) error {
 	for {
-		resp, err := httpClient.Get(ollama_url)
-		log.Print(resp)
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := check(checkCtx)
+		cancel()
 		if err == nil {
-			log.Printf("Ollama is up and running")
-			resp.Body.Close()
+			log.Printf("%s is up and running", name)
 			return nil
 		}
+		log.Printf("Waiting for %s: %s", name, err)
 		select {
 		case <-time.After(5 * time.Second):
-			log.Printf("Waited 5 sec")
 			continue
 		case <-ctx.Done():
 			return fmt.Errorf("context cancelled during wait: %w", ctx.Err())
@@ -301,13 +569,50 @@ func waitForOllama(ctx context.Context) error {
 	}
 }
 
+func checkOllama(ctx context.Context) error {
+	return checkURL(ctx, fmt.Sprintf("http://%s", OllamaHost))
+}
+
+func checkWeaviate(ctx context.Context) error {
+	return checkURL(ctx, fmt.Sprintf("http://%s/v1/.well-known/ready", store.WeaviateHost))
+}
+
+// checkURL returns an error unless a GET of the URL succeeds
+func checkURL(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
 type SystemStats struct {
-	Chipset string
-	MacOS   string
-	Memsize string
+	OS        string
+	OSVersion string
+	Chipset   string
+	Memsize   string // In bytes
 }
 
 func getSystemStats() (*SystemStats, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return getDarwinSystemStats()
+	case "linux":
+		return getLinuxSystemStats()
+	default:
+		return &SystemStats{OS: runtime.GOOS}, nil
+	}
+}
+
+func getDarwinSystemStats() (*SystemStats, error) {
 	chipsetCmd := exec.Command("sysctl", "-n", "machdep.cpu.brand_string")
 	chipsetOut, err := chipsetCmd.Output()
 	if err != nil {
@@ -332,154 +637,201 @@ func getSystemStats() (*SystemStats, error) {
 	memGB := strings.TrimSpace(string(memOut))
 
 	return &SystemStats{
-		Chipset: chipset,
-		MacOS:   macos,
-		Memsize: memGB,
+		OS:        runtime.GOOS,
+		OSVersion: macos,
+		Chipset:   chipset,
+		Memsize:   memGB,
 	}, nil
 }
 
-type CmdSpec struct {
-	Name string
-	Args []string
-	Env  []string
-}
-
-func startSubprocesses(ctx context.Context, commands []CmdSpec, stdout *os.File, stderr *os.File) {
-	for _, cmdConfig := range commands {
-		go func(c CmdSpec) {
-			for {
-				cmd := exec.Command(c.Name, c.Args...)
-				cmd.Env = append(os.Environ(), c.Env...)
-				cmd.Stdout = stdout
-				cmd.Stderr = stderr
-
-				if err := cmd.Start(); err != nil {
-					log.Printf("Error starting command %s: %s\n", c.Name, err)
-					return
-				}
-
-				done := make(chan error)
-				go func() { done <- cmd.Wait() }()
-
-				select {
-				case <-ctx.Done():
-					if err := cmd.Process.Kill(); err != nil {
-						log.Printf("Failed to kill process %s: %s\n", c.Name, err)
-					}
-					return
-				case err := <-done:
-					if err != nil {
-						log.Printf("Command %s finished with error: %s. Restarting...\n", c.Name, err)
-					} else {
-						log.Printf("Command %s finished successfully. Exiting restart loop.\n", c.Name)
-						return
-					}
-				}
-			}
-		}(cmdConfig)
-	}
-}
-
-func initModels(models []string) error {
-	for _, modelName := range models {
-		if IsCustomModel(modelName) {
-			err := createModel(modelName)
-			if err != nil {
-				return fmt.Errorf("failed to create model %s: %v", modelName, err)
-			}
-		} else {
-			err := pullModel(modelName, false)
-			if err != nil {
-				return fmt.Errorf("failed to pull model %s: %v", modelName, err)
-			}
+func getLinuxSystemStats() (*SystemStats, error) {
+	stats := &SystemStats{OS: runtime.GOOS}
+
+	cpuinfo, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chipset info: %v", err)
+	}
+	stats.Chipset = procField(string(cpuinfo), "model name", ":")
+	if stats.Chipset == "" {
+		// ARM processors have no model name
+		stats.Chipset = runtime.GOARCH
+	}
+
+	osRelease, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OS version: %v", err)
+	}
+	stats.OSVersion = strings.Trim(procField(string(osRelease), "PRETTY_NAME", "="), `"`)
+
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory info: %v", err)
+	}
+	memKB, err := strconv.ParseInt(strings.TrimSuffix(procField(string(meminfo), "MemTotal", ":"), " kB"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse memory info: %v", err)
+	}
+	stats.Memsize = strconv.FormatInt(memKB*1024, 10)
+	return stats, nil
+}
+
+// procField returns the value of the first line of content with the given
+// key, such as "MemTotal:    16318412 kB" in /proc/meminfo
+func procField(content, key, sep string) string {
+	for _, line := range strings.Split(content, "\n") {
+		k, v, ok := strings.Cut(line, sep)
+		if ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
 		}
 	}
-	return nil
+	return ""
 }
 
+// BootSyncing pulls the embeddings model and starts syncing. Failed steps
+// are retried, and the API keeps serving connectors meanwhile.
 func BootSyncing(ctx *BootContext) error {
-	err := waitForOllama(ctx)
+	// Retried rather than waited for, so that the boot error tells why
+	// syncing has not started
+	err := ctx.retry("reach Ollama", func() error {
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return checkOllama(checkCtx)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Once an index is built with another model, it is used whatever the
+	// configured one
+	embeddingsModel := ctx.Syncer.store.ActiveEmbeddingIndex().Model
+	if !sameModel(embeddingsModel, embeddingsModelName) {
+		log.Printf("Using embeddings model %s of the active index instead of %s, reindex to change it", embeddingsModel, embeddingsModelName)
+	}
+	err = ctx.retry("pull embeddings model", func() error {
+		return initModels(ctx, []string{embeddingsModel})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Reindexer.Resume(ctx)
 	if err != nil {
-		log.Fatalf("Failed to wait for ollama: %s\n", err)
+		log.Printf("Failed to resume reindexing: %s", err)
+	}
+	// Chunks vectorized by weaviate in older versions are embedded again the
+	// way queries are, while search keeps using them
+	active := ctx.Syncer.store.ActiveEmbeddingIndex()
+	if !active.Prefixed {
+		_, err = ctx.Reindexer.Start(ctx, active.Model)
+		if err != nil && !errors.Is(err, store.ErrEmbeddingIndexBuilding) {
+			log.Printf("Failed to start embedding chunks of %s again: %s", active.ClassName, err)
+		}
 	}
 
-	err = initModels([]string{embeddingsModelName})
+	pluginsDir, err := util.DataPath(connectors.PluginsDir)
 	if err != nil {
-		log.Fatalf("Failed to initialize models: %s\n", err)
+		log.Printf("Failed to get plugins directory: %s\n", err)
+	} else {
+		logDir, _ := util.DataPath(processLogDir)
+		err = connectors.RegisterPlugins(pluginsDir, logDir)
+		if err != nil {
+			log.Printf("Failed to register plugins: %s\n", err)
+		}
 	}
 
-	err = ctx.Syncer.Init(ctx)
+	err = ctx.retry("initialize syncer", func() error {
+		return ctx.Syncer.Init(ctx)
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize syncer: %s\n", err)
+		return err
 	}
 	go ctx.Syncer.Run(ctx)
 
-	ctx.State = BootStateSyncing
 	ctx.SyncingTime = time.Now()
+	ctx.setState(BootStateSyncing)
 	return nil
 }
 
+// BootGen pulls the generation and reranker models. Search and connectors
+// are served until it completes, and prompts are rejected.
 func BootGen(ctx *BootContext) error {
-	err := copyRerankerModel()
+	err := ctx.retry("copy reranker model", copyRerankerModel)
 	if err != nil {
-		log.Fatalf("Failed to copy reranker model: %s\n", err)
+		return err
 	}
 
-	err = initModels([]string{generationModelName})
+	err = ctx.retry("pull generation model", func() error {
+		return initModels(ctx, []string{GenerationModel()})
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize models: %s\n", err)
+		return err
 	}
 
-	retries := 0
-	maxRetries := 5
-	for {
-		resp, err := chatWithModel("What is the capital of France? Respond in one word only", generationModelName, []types.HistoryItem{})
-
+	err = ctx.retry("test generation model", func() error {
+		resp, err := chatWithModel("What is the capital of France? Respond in one word only", GenerationModel(), []types.HistoryItem{})
 		if err != nil {
-			if retries < maxRetries && strings.Contains(err.Error(), "try pulling it first") {
-				time.Sleep(time.Second)
-				retries += 1
-				continue
-			}
-			log.Fatalf("Failed to generate response: %s\n", err)
+			return err
 		}
 		if !resp.Done {
-			log.Fatalf("Response not done: %v\n", resp)
+			return fmt.Errorf("response not done: %v", resp)
 		}
 		if !strings.Contains(resp.Message.Content, "Paris") {
-			log.Fatalf("Response does not contain Paris: %v\n", resp.Message.Content)
+			// The model runs, however odd its answer
+			log.Printf("Test response does not contain Paris: %v\n", resp.Message.Content)
 		}
-		break
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	ctx.GenTime = time.Now()
+	ctx.setState(BootStateGen)
 
-	// Perform a test rerank to download the model
-	rerankOutput, err := RunRerankModel(ctx, []byte{})
+	// Perform a test rerank to download the model. Until it succeeds, search
+	// results are not reranked.
+	err = ctx.retry("load reranker model", func() error {
+		rerankOutput, err := RunRerankModel(ctx, []byte{})
+		if err != nil {
+			return err
+		}
+		log.Print(string(rerankOutput))
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("Failed to run rerank model: %s\n", err)
+		return err
 	}
-	log.Print(string(rerankOutput))
 	log.Print("Rerank model loaded successfully")
+	ctx.RerankerReady.Store(true)
 
-	ctx.GenTime = time.Now()
-	ctx.State = BootStateGen
-	if ctx.Syncer.posthogClient == nil {
-		return nil
+	if ctx.Syncer.posthogClient != nil {
+		sendStartedEvent(ctx)
 	}
-	// Identify user to posthog
+	return nil
+}
+
+// sendStartedEvent identifies the user to posthog and reports boot times.
+// Telemetry failures are only logged.
+func sendStartedEvent(ctx *BootContext) {
+	properties := posthog.NewProperties().Set("version", ctx.Version)
 	systemStats, err := getSystemStats()
 	if err != nil {
-		log.Fatalf("Failed to get system stats: %s\n", err)
+		log.Printf("Failed to get system stats: %s\n", err)
+	} else {
+		properties.
+			Set("chipset", systemStats.Chipset).
+			Set("os", systemStats.OS).
+			Set("os_version", systemStats.OSVersion).
+			Set("memsize", systemStats.Memsize)
+		if systemStats.OS == "darwin" {
+			properties.Set("macos", systemStats.OSVersion)
+		}
 	}
 	err = ctx.Syncer.posthogClient.Enqueue(posthog.Identify{
 		DistinctId: ctx.PosthogDistinctID,
-		Properties: posthog.NewProperties().
-			Set("chipset", systemStats.Chipset).
-			Set("macos", systemStats.MacOS).
-			Set("memsize", systemStats.Memsize).
-			Set("version", ctx.Version),
+		Properties: properties,
 	})
 	if err != nil {
-		log.Fatalf("Failed to enqueue identify event: %s\n", err)
+		log.Printf("Failed to enqueue identify event: %s\n", err)
 	}
 
 	err = ctx.Syncer.posthogClient.Enqueue(posthog.Capture{
@@ -494,31 +846,7 @@ func BootGen(ctx *BootContext) error {
 			Set("version", ctx.Version),
 	})
 	if err != nil {
-		log.Fatalf("Failed to enqueue event: %s\n", err)
-	}
-
-	return nil
-}
-
-func waitForWeaviate(ctx context.Context) error {
-	weaviate_url := "http://localhost:8088/v1/.well-known/ready"
-	httpClient := &http.Client{Timeout: 10 * time.Second}
-
-	for {
-		resp, err := httpClient.Get(weaviate_url)
-		log.Print(resp)
-		if err == nil {
-			log.Printf("Weaviate is up and running")
-			resp.Body.Close()
-			return nil
-		}
-		select {
-		case <-time.After(5 * time.Second):
-			log.Printf("Waited 5 sec")
-			continue
-		case <-ctx.Done():
-			return fmt.Errorf("context cancelled during wait: %w", ctx.Err())
-		}
+		log.Printf("Failed to enqueue event: %s\n", err)
 	}
 }
 
@@ -526,7 +854,10 @@ func Halt(bootCtx *BootContext, sigChan chan os.Signal, cancel context.CancelFun
 	signal.Stop(sigChan)
 	cancel()
 	close(sigChan)
-	if bootCtx.Syncer.posthogClient != nil {
+	// Subprocesses log to their own files, and are given time to shut down
+	bootCtx.Supervisor.Wait()
+	// Halted before the syncer is set up if the boot did not get that far
+	if bootCtx.Syncer != nil && bootCtx.Syncer.posthogClient != nil {
 		defer bootCtx.Syncer.posthogClient.Close()
 	}
 	if err := bootCtx.Logfile.Close(); err != nil {
@@ -534,25 +865,8 @@ func Halt(bootCtx *BootContext, sigChan chan os.Signal, cancel context.CancelFun
 	}
 }
 
-func killProcessByName(name string) error {
-	cmd := exec.Command("pkill", "-f", name)
-	err := cmd.Run()
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
-			// pkill returns 1 if no processes were matched, which is not an error in this context
-			return nil
-		}
-		return fmt.Errorf("failed to kill process %s: %v", name, err)
-	}
-	return nil
-}
-
 func GetMasterLogDir() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("unable to get user home directory: %w", err)
-	}
-	return filepath.Join(home, masterLogPath), nil
+	return util.DataPath(masterLogPath)
 }
 
 func copyRerankerModel() error {
@@ -562,11 +876,10 @@ func copyRerankerModel() error {
 	}
 	rerankerDirPath := filepath.Join(distPath, rerankerModelName)
 
-	home, err := os.UserHomeDir()
+	targetModelDir, err := util.DataPath(miscModelsPath, rerankerModelName)
 	if err != nil {
-		return fmt.Errorf("unable to get user home directory: %w", err)
+		return err
 	}
-	targetModelDir := filepath.Join(home, miscModelsPath, rerankerModelName)
 
 	err = os.MkdirAll(targetModelDir, 0755)
 	if err != nil && !os.IsExist(err) {
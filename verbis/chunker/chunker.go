@@ -0,0 +1,163 @@
+package chunker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxEmbeddingTokens is the largest chunk that the embeddings model is
+	// configured to accept. Chunks are never allowed to grow beyond it,
+	// regardless of connector settings.
+	MaxEmbeddingTokens = 2048
+
+	// Assumed average number of characters per token for the embeddings
+	// model tokenizer, used to estimate token counts without loading it
+	charsPerToken = 4
+)
+
+// Settings control how a connector splits documents into chunks
+type Settings struct {
+	// MaxChunkSize is the maximum size of a chunk in estimated tokens
+	MaxChunkSize int `json:"max_chunk_size" title:"Maximum chunk size" description:"Maximum size of a chunk, in tokens" minimum:"16" maximum:"2048"`
+	// ChunkOverlap is the fraction of MaxChunkSize that is repeated between
+	// consecutive chunks of the same document
+	ChunkOverlap float64 `json:"chunk_overlap" title:"Chunk overlap" description:"Fraction of a chunk repeated at the start of the next one" minimum:"0" maximum:"0.5"`
+}
+
+var DefaultSettings = Settings{
+	MaxChunkSize: 256,
+	ChunkOverlap: 0.2,
+}
+
+func (s Settings) Validate() error {
+	if s.MaxChunkSize < 16 || s.MaxChunkSize > MaxEmbeddingTokens {
+		return fmt.Errorf("max_chunk_size must be between 16 and %d", MaxEmbeddingTokens)
+	}
+	if s.ChunkOverlap < 0 || s.ChunkOverlap > 0.5 {
+		return fmt.Errorf("chunk_overlap must be between 0 and 0.5")
+	}
+	return nil
+}
+
+// MaxTokens returns the size of chunks in estimated tokens, MaxChunkSize
+// clamped to what the embeddings model accepts
+func (s Settings) MaxTokens() int {
+	if s.MaxChunkSize <= 0 {
+		return DefaultSettings.MaxChunkSize
+	}
+	if s.MaxChunkSize > MaxEmbeddingTokens {
+		return MaxEmbeddingTokens
+	}
+	return s.MaxChunkSize
+}
+
+func (s Settings) overlapTokens() int {
+	if s.ChunkOverlap <= 0 {
+		return 0
+	}
+	overlap := s.ChunkOverlap
+	if overlap > 0.5 {
+		// Anything more than half the chunk would make the windows advance
+		// too slowly to be useful
+		overlap = 0.5
+	}
+	return int(float64(s.MaxTokens()) * overlap)
+}
+
+// Chunk is a piece of a document produced by a Chunker
+type Chunk struct {
+	Text string
+	// HeadingPath is the list of section headings that enclose the chunk,
+	// outermost first. It is empty for unstructured content.
+	HeadingPath []string
+	// Start and End are the character offsets of the chunk in the source
+	// text. Text may contain more than that range, such as the heading path
+	// or a repeated table header.
+	Start int
+	End   int
+	// PageStart and PageEnd are the 1-based pages the chunk spans, only set
+	// when the source text is paginated with form feeds (as pdftotext does)
+	PageStart int
+	PageEnd   int
+}
+
+// Chunker splits the content of a single document into chunks small enough
+// to be embedded
+type Chunker interface {
+	Chunk(text string) []Chunk
+}
+
+// ForMimeType returns the chunker best suited to the structure of content of
+// the given MIME type
+func ForMimeType(mimeType string, settings Settings) Chunker {
+	switch mimeType {
+	case "text/markdown":
+		return &MarkdownChunker{Settings: settings}
+	case "text/csv":
+		return &SpreadsheetChunker{Settings: settings}
+	case "text/tab-separated-values":
+		return &SpreadsheetChunker{Settings: settings, Comma: '\t'}
+	default:
+		return &RecursiveChunker{Settings: settings}
+	}
+}
+
+// EstimateTokens approximates the number of tokens the embeddings model will
+// see for the given text. Every word counts for at least one token, and long
+// words count for one token per charsPerToken characters.
+func EstimateTokens(text string) int {
+	tokens := 0
+	for _, word := range strings.Fields(text) {
+		n := (utf8.RuneCountInString(word) + charsPerToken - 1) / charsPerToken
+		if n < 1 {
+			n = 1
+		}
+		tokens += n
+	}
+	return tokens
+}
+
+// withPositions converts the byte offsets set by the chunkers into character
+// offsets, and fills in the pages of paginated text
+func withPositions(text string, chunks []Chunk) []Chunk {
+	offsets := make([]int, 0, 2*len(chunks))
+	for _, c := range chunks {
+		offsets = append(offsets, c.Start, c.End)
+	}
+	sort.Ints(offsets)
+
+	// Walk the text once, recording the character offset and the page of
+	// every byte offset in use
+	runeOffsets := map[int]int{}
+	pages := map[int]int{}
+	paginated := strings.ContainsRune(text, '\f')
+	runeCount := 0
+	page := 1
+	pos := 0
+	for _, offset := range offsets {
+		for pos < offset && pos < len(text) {
+			r, size := utf8.DecodeRuneInString(text[pos:])
+			if r == '\f' {
+				page++
+			}
+			pos += size
+			runeCount++
+		}
+		runeOffsets[offset] = runeCount
+		pages[offset] = page
+	}
+
+	for i := range chunks {
+		start, end := chunks[i].Start, chunks[i].End
+		chunks[i].Start = runeOffsets[start]
+		chunks[i].End = runeOffsets[end]
+		if paginated {
+			chunks[i].PageStart = pages[start]
+			chunks[i].PageEnd = pages[end]
+		}
+	}
+	return chunks
+}
@@ -0,0 +1,273 @@
+package chunker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"   \n\t", 0},
+		{"a b c", 3},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"abcdefghi jk", 4},
+		{"ééééé", 2},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSettingsLimits(t *testing.T) {
+	tests := []struct {
+		settings    Settings
+		wantMax     int
+		wantOverlap int
+	}{
+		{Settings{}, DefaultSettings.MaxChunkSize, 0},
+		{Settings{MaxChunkSize: 100, ChunkOverlap: 0.2}, 100, 20},
+		{Settings{MaxChunkSize: 100, ChunkOverlap: 0.9}, 100, 50},
+		{Settings{MaxChunkSize: 10000, ChunkOverlap: 0.1}, MaxEmbeddingTokens, 204},
+	}
+	for _, tt := range tests {
+		if got := tt.settings.MaxTokens(); got != tt.wantMax {
+			t.Errorf("%+v MaxTokens = %d, want %d", tt.settings, got, tt.wantMax)
+		}
+		if got := tt.settings.overlapTokens(); got != tt.wantOverlap {
+			t.Errorf("%+v overlapTokens = %d, want %d", tt.settings, got, tt.wantOverlap)
+		}
+	}
+}
+
+// words returns n distinct words of one token each
+func words(n int) []string {
+	res := make([]string, n)
+	for i := range res {
+		res[i] = fmt.Sprintf("w%d", i)
+	}
+	return res
+}
+
+func TestRecursiveChunker(t *testing.T) {
+	long := strings.Join(words(200), " ")
+	tests := []struct {
+		name     string
+		text     string
+		settings Settings
+		want     []string // nil to only check the limits
+	}{
+		{
+			name:     "fits in one chunk",
+			text:     "  short text\n",
+			settings: Settings{MaxChunkSize: 16},
+			want:     []string{"short text"},
+		},
+		{
+			name:     "paragraphs first",
+			text:     strings.Join(words(10), " ") + "\n\n" + strings.Join(words(10), " "),
+			settings: Settings{MaxChunkSize: 16},
+			want:     []string{strings.Join(words(10), " "), strings.Join(words(10), " ")},
+		},
+		{
+			name:     "sentences before words",
+			text:     "a b c d e f g h. i j k l m n o p.",
+			settings: Settings{MaxChunkSize: 10},
+			want:     []string{"a b c d e f g h.", "i j k l m n o p."},
+		},
+		{
+			name:     "words without overlap",
+			text:     long,
+			settings: Settings{MaxChunkSize: 16},
+		},
+		{
+			name:     "words with overlap",
+			text:     long,
+			settings: Settings{MaxChunkSize: 20, ChunkOverlap: 0.25},
+		},
+		{
+			name:     "word larger than a chunk",
+			text:     strings.Repeat("x", 200),
+			settings: Settings{MaxChunkSize: 16},
+			want:     []string{strings.Repeat("x", 64), strings.Repeat("x", 64), strings.Repeat("x", 64), strings.Repeat("x", 8)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := (&RecursiveChunker{Settings: tt.settings}).Chunk(tt.text)
+			texts := []string{}
+			for _, c := range chunks {
+				texts = append(texts, c.Text)
+				if n := EstimateTokens(c.Text); n > tt.settings.MaxTokens() {
+					t.Errorf("chunk %q has %d tokens, more than %d", c.Text, n, tt.settings.MaxTokens())
+				}
+				if tt.text[c.Start:c.End] != c.Text {
+					t.Errorf("chunk %q at %d-%d, source has %q", c.Text, c.Start, c.End, tt.text[c.Start:c.End])
+				}
+			}
+			if tt.want != nil && !reflect.DeepEqual(texts, tt.want) {
+				t.Errorf("chunks = %q, want %q", texts, tt.want)
+			}
+			checkOverlap(t, tt.text, chunks, tt.settings.overlapTokens())
+		})
+	}
+}
+
+// checkOverlap checks that the chunks cover every word of the text in order,
+// each chunk repeating at most overlap tokens of the previous one
+func checkOverlap(t *testing.T, text string, chunks []Chunk, overlap int) {
+	t.Helper()
+	for i := 1; i < len(chunks); i++ {
+		prev, cur := chunks[i-1], chunks[i]
+		if cur.Start >= prev.End {
+			if strings.TrimSpace(text[prev.End:cur.Start]) != "" {
+				t.Errorf("text %q between chunks %d and %d is missing", text[prev.End:cur.Start], i-1, i)
+			}
+			continue
+		}
+		if overlap == 0 {
+			t.Errorf("chunks %d and %d overlap without overlap settings", i-1, i)
+			continue
+		}
+		if cur.Start < prev.Start {
+			t.Errorf("chunk %d starts before chunk %d", i, i-1)
+			continue
+		}
+		if n := EstimateTokens(text[cur.Start:prev.End]); n > overlap {
+			t.Errorf("chunks %d and %d share %d tokens, more than %d", i-1, i, n, overlap)
+		}
+	}
+	if overlap > 0 && len(chunks) > 1 && chunks[1].Start >= chunks[0].End {
+		t.Errorf("chunks do not overlap")
+	}
+}
+
+func TestMarkdownChunker(t *testing.T) {
+	text := strings.Join([]string{
+		"Preamble",
+		"",
+		"# Pricing",
+		"",
+		"Plans for everyone.",
+		"",
+		"## Enterprise",
+		"",
+		"Contact sales.",
+		"",
+		"```",
+		"# not a heading",
+		"```",
+		"",
+		"# FAQ ##",
+		"",
+		"Ask us.",
+		"",
+	}, "\n")
+
+	chunks := (&MarkdownChunker{Settings: Settings{MaxChunkSize: 64}}).Chunk(text)
+	want := []struct {
+		path []string
+		text string
+	}{
+		{[]string{}, "Preamble"},
+		{[]string{"Pricing"}, "Pricing\n\nPlans for everyone."},
+		{[]string{"Pricing", "Enterprise"}, "Pricing > Enterprise\n\nContact sales.\n\n```\n# not a heading\n```"},
+		{[]string{"FAQ"}, "FAQ\n\nAsk us."},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v", len(chunks), len(want), chunks)
+	}
+	for i, w := range want {
+		if !reflect.DeepEqual(chunks[i].HeadingPath, w.path) || chunks[i].Text != w.text {
+			t.Errorf("chunk %d = %q %q, want %q %q", i, chunks[i].HeadingPath, chunks[i].Text, w.path, w.text)
+		}
+	}
+}
+
+func TestMarkdownChunkerLongSection(t *testing.T) {
+	text := "# Title\n\n" + strings.Join(words(100), " ") + "\n"
+	settings := Settings{MaxChunkSize: 16}
+	chunks := (&MarkdownChunker{Settings: settings}).Chunk(text)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the section split", len(chunks))
+	}
+	for _, c := range chunks {
+		if !strings.HasPrefix(c.Text, "Title\n\n") {
+			t.Errorf("chunk %q does not start with its heading path", c.Text)
+		}
+		if n := EstimateTokens(c.Text); n > settings.MaxTokens() {
+			t.Errorf("chunk %q has %d tokens, more than %d", c.Text, n, settings.MaxTokens())
+		}
+	}
+}
+
+func TestSpreadsheetChunker(t *testing.T) {
+	rows := []string{"name,city"}
+	for i := 0; i < 20; i++ {
+		rows = append(rows, fmt.Sprintf("person%d , city%d", i, i))
+		if i == 10 {
+			rows = append(rows, ",")
+		}
+	}
+	text := strings.Join(rows, "\n") + "\n"
+	settings := Settings{MaxChunkSize: 16, ChunkOverlap: 0.5}
+	chunks := (&SpreadsheetChunker{Settings: settings}).Chunk(text)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the rows grouped into several", len(chunks))
+	}
+
+	seen := []string{}
+	for _, c := range chunks {
+		if n := EstimateTokens(c.Text); n > settings.MaxTokens() {
+			t.Errorf("chunk %q has %d tokens, more than %d", c.Text, n, settings.MaxTokens())
+		}
+		lines := strings.Split(strings.TrimSuffix(c.Text, "\n"), "\n")
+		if lines[0] != "name,city" {
+			t.Errorf("chunk %q does not start with the header", c.Text)
+		}
+		seen = append(seen, lines[1:]...)
+	}
+	// Every row once, whole, trimmed and without overlap or empty rows
+	want := []string{}
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("person%d,city%d", i, i))
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("rows = %q, want %q", seen, want)
+	}
+}
+
+func TestSpreadsheetChunkerTabs(t *testing.T) {
+	text := "a\tb\n1\t2\n"
+	chunks := (&SpreadsheetChunker{Settings: DefaultSettings, Comma: '\t'}).Chunk(text)
+	if len(chunks) != 1 || chunks[0].Text != "a\tb\n1\t2\n" {
+		t.Errorf("chunks = %+v, want the header and the row", chunks)
+	}
+}
+
+func TestSpreadsheetChunkerFallback(t *testing.T) {
+	text := "only a header row"
+	chunks := (&SpreadsheetChunker{Settings: DefaultSettings}).Chunk(text)
+	if len(chunks) != 1 || chunks[0].Text != text {
+		t.Errorf("chunks = %+v, want the text as is", chunks)
+	}
+}
+
+func TestPages(t *testing.T) {
+	text := "first page\fsecond page\fthird page"
+	chunks := (&RecursiveChunker{Settings: Settings{MaxChunkSize: 16}}).Chunk(text)
+	if len(chunks) != 1 {
+		t.Fatalf("got %d chunks, want 1", len(chunks))
+	}
+	if chunks[0].PageStart != 1 || chunks[0].PageEnd != 3 {
+		t.Errorf("pages = %d-%d, want 1-3", chunks[0].PageStart, chunks[0].PageEnd)
+	}
+}
@@ -0,0 +1,103 @@
+package chunker
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MarkdownChunker splits a Markdown document into its sections and chunks
+// each section separately, so that no chunk spans two sections. The path of
+// headings enclosing a section is prepended to every chunk of that section,
+// which keeps chunks such as a bare pricing table attributable to their
+// topic.
+type MarkdownChunker struct {
+	Settings
+}
+
+var (
+	headingRegex = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	fenceRegex   = regexp.MustCompile("^\\s*(```|~~~)")
+)
+
+type markdownSection struct {
+	path []string
+	body span
+}
+
+type markdownHeading struct {
+	level int
+	title string
+}
+
+func (m *MarkdownChunker) Chunk(text string) []Chunk {
+	res := []Chunk{}
+	for _, section := range splitMarkdownSections(text) {
+		prefix := ""
+		if len(section.path) > 0 {
+			prefix = strings.Join(section.path, " > ") + "\n\n"
+		}
+
+		// Deeply nested headings should not starve the section content
+		budget := m.MaxTokens() - EstimateTokens(prefix)
+		if budget < m.MaxTokens()/2 {
+			budget = m.MaxTokens() / 2
+		}
+
+		for _, piece := range splitRecursive(section.body.text, section.body.start, budget, m.overlapTokens()) {
+			res = append(res, Chunk{
+				Text:        prefix + piece.text,
+				HeadingPath: section.path,
+				Start:       piece.start,
+				End:         piece.end(),
+			})
+		}
+	}
+	return withPositions(text, res)
+}
+
+func splitMarkdownSections(text string) []markdownSection {
+	sections := []markdownSection{}
+	stack := []markdownHeading{}
+	var body strings.Builder
+	bodyStart := 0
+	offset := 0
+	inFence := false
+
+	flush := func() {
+		if strings.TrimSpace(body.String()) != "" {
+			path := make([]string, 0, len(stack))
+			for _, h := range stack {
+				path = append(path, h.title)
+			}
+			sections = append(sections, markdownSection{
+				path: path,
+				body: span{text: body.String(), start: bodyStart},
+			})
+		}
+		body.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		offset += len(line)
+		if fenceRegex.MatchString(line) {
+			inFence = !inFence
+		}
+
+		match := headingRegex.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if inFence || match == nil {
+			body.WriteString(line)
+			continue
+		}
+
+		flush()
+		bodyStart = offset
+		level := len(match[1])
+		for len(stack) > 0 && stack[len(stack)-1].level >= level {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, markdownHeading{level: level, title: match[2]})
+	}
+	flush()
+
+	return sections
+}
@@ -0,0 +1,188 @@
+package chunker
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// RecursiveChunker splits unstructured text on the coarsest boundary that
+// yields pieces small enough to fit in a chunk: paragraphs first, then
+// lines, sentences and finally words. The pieces are then merged back
+// together into chunks of up to MaxChunkSize tokens.
+type RecursiveChunker struct {
+	Settings
+}
+
+func (r *RecursiveChunker) Chunk(text string) []Chunk {
+	res := []Chunk{}
+	for _, piece := range splitRecursive(text, 0, r.MaxTokens(), r.overlapTokens()) {
+		res = append(res, Chunk{
+			Text:  piece.text,
+			Start: piece.start,
+			End:   piece.end(),
+		})
+	}
+	return withPositions(text, res)
+}
+
+// span is a piece of the source text along with its byte offset in it
+type span struct {
+	text  string
+	start int
+}
+
+func (s span) end() int {
+	return s.start + len(s.text)
+}
+
+// Every splitter returns pieces that keep their trailing separator, so that
+// concatenating them reproduces the original text
+var splitters = []func(string) []string{
+	func(s string) []string { return strings.SplitAfter(s, "\n\n") },
+	func(s string) []string { return strings.SplitAfter(s, "\n") },
+	splitSentences,
+	splitWords,
+}
+
+var wordRegex = regexp.MustCompile(`\S+\s*`)
+
+func splitWords(text string) []string {
+	return wordRegex.FindAllString(text, -1)
+}
+
+// splitSentences cuts the text after sentence-ending punctuation, including
+// any closing quotes or brackets and the whitespace that follows it
+func splitSentences(text string) []string {
+	runes := []rune(text)
+	pieces := []string{}
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '.' && runes[i] != '!' && runes[i] != '?' {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && strings.ContainsRune(`"')]”’`, runes[j]) {
+			j++
+		}
+		if j == len(runes) || !unicode.IsSpace(runes[j]) {
+			// Not the end of a sentence, e.g. a decimal number or a URL
+			continue
+		}
+		for j < len(runes) && unicode.IsSpace(runes[j]) {
+			j++
+		}
+		pieces = append(pieces, string(runes[start:j]))
+		start = j
+		i = j - 1
+	}
+	if start < len(runes) {
+		pieces = append(pieces, string(runes[start:]))
+	}
+	return pieces
+}
+
+// splitRecursive returns the text split into chunks of at most maxTokens
+// estimated tokens, where consecutive chunks share up to overlapTokens. The
+// text starts at byte offset base of the source.
+func splitRecursive(text string, base int, maxTokens, overlapTokens int) []span {
+	pieces := splitPieces(span{text: text, start: base}, 0, maxTokens)
+	return mergePieces(pieces, maxTokens, overlapTokens)
+}
+
+func splitPieces(piece span, level int, maxTokens int) []span {
+	if EstimateTokens(piece.text) <= maxTokens {
+		return []span{piece}
+	}
+
+	var parts []string
+	if level >= len(splitters) {
+		// A single word that is larger than a chunk, most likely an encoded
+		// payload. Cut it into fixed size pieces.
+		parts = splitRunes(piece.text, maxTokens*charsPerToken)
+	} else {
+		parts = splitters[level](piece.text)
+	}
+
+	pieces := []span{}
+	offset := piece.start
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		sub := span{text: part, start: offset}
+		offset += len(part)
+		if level < len(splitters) && EstimateTokens(part) > maxTokens {
+			pieces = append(pieces, splitPieces(sub, level+1, maxTokens)...)
+			continue
+		}
+		pieces = append(pieces, sub)
+	}
+	return pieces
+}
+
+func splitRunes(text string, size int) []string {
+	runes := []rune(text)
+	pieces := []string{}
+	for i := 0; i < len(runes); i += size {
+		end := i + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		pieces = append(pieces, string(runes[i:end]))
+	}
+	return pieces
+}
+
+// mergePieces greedily packs consecutive pieces into chunks. When a chunk is
+// full, the trailing pieces that fit in the overlap budget are repeated at
+// the start of the next chunk.
+func mergePieces(pieces []span, maxTokens, overlapTokens int) []span {
+	chunks := []span{}
+	window := []span{}
+	windowTokens := 0
+
+	for _, piece := range pieces {
+		pieceTokens := EstimateTokens(piece.text)
+		if windowTokens+pieceTokens > maxTokens && len(window) > 0 {
+			chunks = appendJoined(chunks, window)
+
+			carry := []span{}
+			carryTokens := 0
+			for i := len(window) - 1; i >= 0; i-- {
+				t := EstimateTokens(window[i].text)
+				if carryTokens+t > overlapTokens || carryTokens+t+pieceTokens > maxTokens {
+					break
+				}
+				carry = append([]span{window[i]}, carry...)
+				carryTokens += t
+			}
+			window = carry
+			windowTokens = carryTokens
+		}
+		window = append(window, piece)
+		windowTokens += pieceTokens
+	}
+
+	return appendJoined(chunks, window)
+}
+
+// appendJoined joins the consecutive pieces of a window into a single chunk,
+// trimming surrounding whitespace while keeping its offset accurate
+func appendJoined(chunks []span, window []span) []span {
+	if len(window) == 0 {
+		return chunks
+	}
+	var builder strings.Builder
+	for _, piece := range window {
+		builder.WriteString(piece.text)
+	}
+	joined := builder.String()
+	trimmed := strings.TrimLeftFunc(joined, unicode.IsSpace)
+	start := window[0].start + len(joined) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	if trimmed == "" {
+		return chunks
+	}
+	return append(chunks, span{text: trimmed, start: start})
+}
@@ -0,0 +1,132 @@
+package chunker
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// SpreadsheetChunker groups the rows of a CSV export into chunks, repeating
+// the header row at the top of every chunk so that each one can be
+// understood on its own. Rows are never split across chunks unless a single
+// row is larger than a chunk, and no overlap is applied since rows are
+// independent of each other.
+type SpreadsheetChunker struct {
+	Settings
+	// Comma is the field delimiter, defaults to ','
+	Comma rune
+}
+
+func (s *SpreadsheetChunker) Chunk(text string) []Chunk {
+	comma := s.Comma
+	if comma == 0 {
+		comma = ','
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	type row struct {
+		record []string
+		span   span
+	}
+	rows := []row{}
+	for {
+		start := int(reader.InputOffset())
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Not tabular after all, fall back to plain text splitting
+			return (&RecursiveChunker{Settings: s.Settings}).Chunk(text)
+		}
+		end := int(reader.InputOffset())
+		rows = append(rows, row{record: record, span: span{text: text[start:end], start: start}})
+	}
+	if len(rows) < 2 {
+		return (&RecursiveChunker{Settings: s.Settings}).Chunk(text)
+	}
+
+	header := formatRow(rows[0].record, comma)
+	budget := s.MaxTokens() - EstimateTokens(header)
+	if budget < s.MaxTokens()/2 {
+		budget = s.MaxTokens() / 2
+	}
+
+	res := []Chunk{}
+	var group strings.Builder
+	groupTokens := 0
+	groupStart, groupEnd := 0, 0
+	flush := func() {
+		if group.Len() > 0 {
+			res = append(res, Chunk{
+				Text:  header + group.String(),
+				Start: groupStart,
+				End:   groupEnd,
+			})
+		}
+		group.Reset()
+		groupTokens = 0
+	}
+
+	for _, r := range rows[1:] {
+		if isEmptyRow(r.record) {
+			continue
+		}
+		line := formatRow(r.record, comma)
+		lineTokens := EstimateTokens(line)
+
+		if lineTokens > budget {
+			flush()
+			for _, piece := range splitRecursive(line, r.span.start, budget, 0) {
+				// Offsets are approximate here since the row was reformatted
+				res = append(res, Chunk{
+					Text:  header + piece.text + "\n",
+					Start: min(piece.start, r.span.end()),
+					End:   min(piece.end(), r.span.end()),
+				})
+			}
+			continue
+		}
+
+		if groupTokens+lineTokens > budget {
+			flush()
+		}
+		if group.Len() == 0 {
+			groupStart = r.span.start
+		}
+		group.WriteString(line)
+		groupTokens += lineTokens
+		groupEnd = r.span.end()
+	}
+	flush()
+
+	return withPositions(text, res)
+}
+
+func formatRow(record []string, comma rune) string {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Comma = comma
+	trimmed := make([]string, len(record))
+	for i, field := range record {
+		trimmed[i] = strings.TrimSpace(field)
+	}
+	// Writing to a bytes.Buffer cannot fail
+	_ = writer.Write(trimmed)
+	writer.Flush()
+	return buf.String()
+}
+
+func isEmptyRow(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/verbis-ai/verbis/verbis/connectors"
+	"github.com/verbis-ai/verbis/verbis/store"
+	"github.com/verbis-ai/verbis/verbis/util"
+)
+
+const (
+	envDaemonConfig = "VERBIS_CONFIG"
+	// Relative to the data dir, unless set with VERBIS_CONFIG
+	daemonConfigFile = "config.json"
+)
+
+// DaemonConfig configures how the Verbis daemon runs, as opposed to the user
+// settings of types.Config. It is read from a JSON file, and environment
+// variables override each of its fields.
+type DaemonConfig struct {
+	// Headless runs without the desktop app: OAuth URLs are printed and
+	// returned by the API instead of opened in a browser, and logs are
+	// written to stderr instead of the log file
+	Headless bool `json:"headless"`
+
+	// DataDir holds all data, ~/.verbis by default
+	DataDir string `json:"data_dir"`
+	// DistDir holds the bundled binaries and models, found next to the
+	// executable by default. OllamaPath and WeaviatePath default to binaries
+	// in it.
+	DistDir      string `json:"dist_dir"`
+	OllamaPath   string `json:"ollama_path"`
+	WeaviatePath string `json:"weaviate_path"`
+
+	HTTPAddr     string `json:"http_addr"`
+	HTTPSAddr    string `json:"https_addr"`
+	OllamaAddr   string `json:"ollama_addr"`
+	WeaviateAddr string `json:"weaviate_addr"`
+
+	GenerationModel string `json:"generation_model"`
+	EmbeddingsModel string `json:"embeddings_model"`
+	RerankerModel   string `json:"reranker_model"`
+
+	// NeighbourChunks is the number of chunks on each side of a search result
+	// added to the prompt, 0 to disable
+	NeighbourChunks int `json:"neighbour_chunks"`
+
+	// SanitizeRules overrides the sanitisation rules applied to the content of
+	// connector types, e.g. {"slack": ["bom", "urls"]}
+	SanitizeRules map[string][]string `json:"sanitize_rules,omitempty"`
+
+	// Telemetry disables telemetry when false, whatever the user setting
+	Telemetry *bool `json:"telemetry,omitempty"`
+}
+
+func defaultDaemonConfig() *DaemonConfig {
+	return &DaemonConfig{
+		HTTPAddr:        "127.0.0.1:8081",
+		HTTPSAddr:       "127.0.0.1:8082",
+		OllamaAddr:      OllamaHost,
+		WeaviateAddr:    store.WeaviateHost,
+		GenerationModel: generationModelName,
+		EmbeddingsModel: embeddingsModelName,
+		RerankerModel:   rerankerModelName,
+		NeighbourChunks: NumNeighbourChunks,
+	}
+}
+
+// LoadDaemonConfig reads the config file given by VERBIS_CONFIG, or the one
+// in the data dir if it exists, then applies the environment overrides
+func LoadDaemonConfig() (*DaemonConfig, error) {
+	cfg := defaultDaemonConfig()
+
+	path := os.Getenv(envDaemonConfig)
+	if path == "" {
+		// The data dir may itself be set in the environment
+		util.SetDataDir(os.Getenv("VERBIS_DATA_DIR"))
+		p, err := util.DataPath(daemonConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(p); err == nil {
+			path = p
+		}
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file: %v", err)
+		}
+		err = json.Unmarshal(b, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse config file %s: %v", path, err)
+		}
+	}
+
+	err := cfg.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *DaemonConfig) applyEnv() error {
+	for env, field := range map[string]*string{
+		"VERBIS_DATA_DIR":         &c.DataDir,
+		"VERBIS_DIST_DIR":         &c.DistDir,
+		"VERBIS_OLLAMA_PATH":      &c.OllamaPath,
+		"VERBIS_WEAVIATE_PATH":    &c.WeaviatePath,
+		"VERBIS_HTTP_ADDR":        &c.HTTPAddr,
+		"VERBIS_HTTPS_ADDR":       &c.HTTPSAddr,
+		"VERBIS_OLLAMA_ADDR":      &c.OllamaAddr,
+		"VERBIS_WEAVIATE_ADDR":    &c.WeaviateAddr,
+		"VERBIS_GENERATION_MODEL": &c.GenerationModel,
+		"VERBIS_EMBEDDINGS_MODEL": &c.EmbeddingsModel,
+		"VERBIS_RERANKER_MODEL":   &c.RerankerModel,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	headless, err := envBool("VERBIS_HEADLESS")
+	if err != nil {
+		return err
+	}
+	if headless != nil {
+		c.Headless = *headless
+	}
+	if v := os.Getenv("VERBIS_NEIGHBOUR_CHUNKS"); v != "" {
+		c.NeighbourChunks, err = strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid VERBIS_NEIGHBOUR_CHUNKS: %v", err)
+		}
+	}
+	telemetry, err := envBool("VERBIS_TELEMETRY")
+	if err != nil {
+		return err
+	}
+	if telemetry != nil {
+		c.Telemetry = telemetry
+	}
+	return nil
+}
+
+// envBool returns the boolean value of an environment variable, or nil if it
+// is not set
+func envBool(env string) (*bool, error) {
+	v := os.Getenv(env)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", env, err)
+	}
+	return &b, nil
+}
+
+func (c *DaemonConfig) validate() error {
+	for name, addr := range map[string]string{
+		"http_addr":     c.HTTPAddr,
+		"https_addr":    c.HTTPSAddr,
+		"ollama_addr":   c.OllamaAddr,
+		"weaviate_addr": c.WeaviateAddr,
+	} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid %s %q: %v", name, addr, err)
+		}
+	}
+	if c.GenerationModel == "" || c.EmbeddingsModel == "" || c.RerankerModel == "" {
+		return errors.New("model names cannot be empty")
+	}
+	if c.NeighbourChunks < 0 {
+		return errors.New("neighbour_chunks cannot be negative")
+	}
+	for connectorType, names := range c.SanitizeRules {
+		_, err := util.NewSanitizer(names)
+		if err != nil {
+			return fmt.Errorf("invalid sanitize_rules for %s: %v", connectorType, err)
+		}
+	}
+	return nil
+}
+
+// Apply sets the paths, addresses and models used by the daemon
+func (c *DaemonConfig) Apply() {
+	util.SetDataDir(c.DataDir)
+	util.SetDistDir(c.DistDir)
+	OllamaHost = c.OllamaAddr
+	store.WeaviateHost = c.WeaviateAddr
+	generationModelName = c.GenerationModel
+	embeddingsModelName = c.EmbeddingsModel
+	rerankerModelName = c.RerankerModel
+	NumNeighbourChunks = c.NeighbourChunks
+	// Checked by validate
+	util.SetConnectorSanitizeRules(c.SanitizeRules)
+
+	// OAuth providers redirect the browser of the user, which reaches the
+	// API on loopback whatever the interface it listens on
+	_, httpPort, _ := net.SplitHostPort(c.HTTPAddr)
+	_, httpsPort, _ := net.SplitHostPort(c.HTTPSAddr)
+	connectors.CallbackBaseURL = "http://127.0.0.1:" + httpPort
+	connectors.SecureCallbackBaseURL = "https://localhost:" + httpsPort
+}
+
+// TelemetryEnabled tells whether telemetry may be sent, given the user setting
+func (c *DaemonConfig) TelemetryEnabled(userSetting bool) bool {
+	if c.Telemetry != nil && !*c.Telemetry {
+		return false
+	}
+	return userSetting
+}
+
+// binaryPaths returns the paths of the ollama and weaviate binaries
+func (c *DaemonConfig) binaryPaths() (string, string, error) {
+	ollamaPath, weaviatePath := c.OllamaPath, c.WeaviatePath
+	if ollamaPath != "" && weaviatePath != "" {
+		return ollamaPath, weaviatePath, nil
+	}
+	distPath, err := util.GetDistPath()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get dist path: %v", err)
+	}
+	if ollamaPath == "" {
+		ollamaPath = filepath.Join(distPath, util.OllamaFile)
+	}
+	if weaviatePath == "" {
+		weaviatePath = filepath.Join(distPath, util.WeaviateFile)
+	}
+	return ollamaPath, weaviatePath, nil
+}
@@ -0,0 +1,164 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/verbis-ai/verbis/verbis/keychain"
+)
+
+// persistingTokenSource refreshes the token of a connector when it expires,
+// and saves the refreshed token to the keychain, as providers may rotate the
+// refresh token. Once the token can no longer be refreshed, the connector is
+// marked as requiring the user to authenticate again.
+type persistingTokenSource struct {
+	connector *BaseConnector
+	source    oauth2.TokenSource
+
+	lock sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tok, err := s.source.Token()
+	if err != nil {
+		if reason, ok := authErrorReason(err); ok {
+			s.connector.invalidateAuth(reason)
+		}
+		return nil, err
+	}
+
+	if tok.AccessToken != s.last.AccessToken || tok.RefreshToken != s.last.RefreshToken {
+		err = keychain.SaveTokenToKeychain(tok, s.connector.ID(), s.connector.Type())
+		if err != nil {
+			// The refresh token is still valid, it is only lost on restart
+			log.Printf("Unable to save refreshed token of connector %s: %v", s.connector.ID(), err)
+		}
+		s.last = tok
+	}
+	return tok, nil
+}
+
+// tokenSource returns the source of the OAuth tokens of the connector,
+// starting from the token stored in the keychain
+func (c *BaseConnector) tokenSource(ctx context.Context, config *oauth2.Config) (oauth2.TokenSource, error) {
+	tok, err := keychain.TokenFromKeychain(c.ID(), c.Type())
+	if err != nil {
+		return nil, err
+	}
+	return c.persistingTokenSource(tok, config.TokenSource(ctx, tok)), nil
+}
+
+// persistingTokenSource saves the tokens of source that differ from tok, the
+// token stored in the keychain
+func (c *BaseConnector) persistingTokenSource(tok *oauth2.Token, source oauth2.TokenSource) oauth2.TokenSource {
+	return &persistingTokenSource{
+		connector: c,
+		source:    source,
+		last:      tok,
+	}
+}
+
+// httpClient returns an HTTP client authenticated with the OAuth token of the
+// connector
+func (c *BaseConnector) httpClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
+	ts, err := c.tokenSource(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+// errNoRefreshToken is returned by token sources that cannot refresh an
+// expired token
+var errNoRefreshToken = errors.New("oauth2: token expired and refresh token is not set")
+
+// invalidGrant is the OAuth error code of refresh tokens that were revoked
+// or expired
+const invalidGrant = "invalid_grant"
+
+// authErrorReason tells whether a token refresh failed because the grant is
+// no longer valid, e.g. revoked by the user or expired, rather than because
+// of a transient error such as rate limiting. It returns the reason to show
+// the user.
+func authErrorReason(err error) (string, bool) {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode != invalidGrant || !isRejectedGrantStatus(retrieveErr.Response) {
+			return "", false
+		}
+		if retrieveErr.ErrorDescription != "" {
+			return retrieveErr.ErrorDescription, true
+		}
+		return "the access token was revoked or expired", true
+	}
+
+	// Refresh errors of MSAL, used by Outlook
+	var callErr msalerrors.CallErr
+	if errors.As(err, &callErr) && callErr.Resp != nil && isRejectedGrantStatus(callErr.Resp) {
+		var body struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		data, _ := io.ReadAll(callErr.Resp.Body)
+		callErr.Resp.Body = io.NopCloser(bytes.NewReader(data))
+		if json.Unmarshal(data, &body) == nil && body.Error == invalidGrant {
+			if body.ErrorDescription != "" {
+				return body.ErrorDescription, true
+			}
+			return "the access token was revoked or expired", true
+		}
+		return "", false
+	}
+
+	if errors.Is(err, errNoRefreshToken) || strings.Contains(err.Error(), "refresh token is not set") {
+		return "the access token expired and cannot be refreshed", true
+	}
+	return "", false
+}
+
+// isRejectedGrantStatus tells whether the status of a token response may
+// come with a rejected grant, which OAuth servers answer with 400 or 401
+func isRejectedGrantStatus(resp *http.Response) bool {
+	return resp == nil || resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized
+}
+
+// invalidateAuth marks the connector as requiring the user to authenticate
+// again, for the given reason, until then it is not synced. Its token is
+// deleted, so that the next auth setup goes through the OAuth flow.
+func (c *BaseConnector) invalidateAuth(reason string) {
+	log.Printf("Authentication of connector %s is no longer valid: %s", c.ID(), reason)
+	// Not tied to the sync that ran into the error, which may be cancelled
+	ctx := context.Background()
+	state, err := c.Status(ctx)
+	if err != nil {
+		log.Printf("Unable to get state of connector %s: %v", c.ID(), err)
+		return
+	}
+	if !state.AuthValid && state.AuthError != "" {
+		return
+	}
+	err = c.store.SetConnectorAuth(ctx, c.ID(), false, reason)
+	if err != nil {
+		log.Printf("Unable to update state of connector %s: %v", c.ID(), err)
+		return
+	}
+
+	err = keychain.DeleteTokenFromKeychain(c.ID(), c.Type())
+	if err != nil {
+		log.Printf("Unable to delete token of connector %s: %v", c.ID(), err)
+	}
+}
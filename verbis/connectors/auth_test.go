@@ -0,0 +1,56 @@
+package connectors
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+	"golang.org/x/oauth2"
+)
+
+func TestAuthErrorReason(t *testing.T) {
+	retrieveErr := func(status int, code, description string) error {
+		return fmt.Errorf("unable to refresh: %w", &oauth2.RetrieveError{
+			Response:         &http.Response{StatusCode: status},
+			ErrorCode:        code,
+			ErrorDescription: description,
+		})
+	}
+	msalErr := func(status int, body string) error {
+		return msalerrors.CallErr{
+			Resp: &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(body))},
+			Err:  fmt.Errorf("reply status code was %d", status),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantReason string
+		wantOK     bool
+	}{
+		{"revoked", retrieveErr(http.StatusBadRequest, "invalid_grant", "Token has been expired or revoked."), "Token has been expired or revoked.", true},
+		{"revoked without description", retrieveErr(http.StatusUnauthorized, "invalid_grant", ""), "the access token was revoked or expired", true},
+		{"rate limited", retrieveErr(http.StatusTooManyRequests, "", ""), "", false},
+		{"timeout", retrieveErr(http.StatusRequestTimeout, "", ""), "", false},
+		{"invalid grant with another status", retrieveErr(http.StatusForbidden, "invalid_grant", ""), "", false},
+		{"invalid request", retrieveErr(http.StatusBadRequest, "invalid_request", ""), "", false},
+		{"server error", retrieveErr(http.StatusInternalServerError, "", ""), "", false},
+		{"msal revoked", msalErr(http.StatusBadRequest, `{"error": "invalid_grant", "error_description": "AADSTS70008: expired"}`), "AADSTS70008: expired", true},
+		{"msal throttled", msalErr(http.StatusTooManyRequests, `{"error": "temporarily_unavailable"}`), "", false},
+		{"msal invalid client", msalErr(http.StatusBadRequest, `{"error": "invalid_client"}`), "", false},
+		{"no refresh token", fmt.Errorf("unable to get token: %w", errNoRefreshToken), "the access token expired and cannot be refreshed", true},
+		{"network", fmt.Errorf("dial tcp: connection refused"), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, ok := authErrorReason(tt.err)
+			if reason != tt.wantReason || ok != tt.wantOK {
+				t.Errorf("authErrorReason = %q, %v, want %q, %v", reason, ok, tt.wantReason, tt.wantOK)
+			}
+		})
+	}
+}
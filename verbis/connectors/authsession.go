@@ -0,0 +1,114 @@
+package connectors
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthSessionTTL is how long the user has to complete an OAuth flow
+const AuthSessionTTL = 15 * time.Minute
+
+// Base URLs of the OAuth redirect URIs, served by the API. Providers with a
+// static redirect URI must have it registered with the same port.
+var (
+	CallbackBaseURL       = "http://127.0.0.1:8081"
+	SecureCallbackBaseURL = "https://localhost:8082"
+)
+
+func callbackURL(id string) string {
+	return fmt.Sprintf("%s/connectors/%s/callback", CallbackBaseURL, id)
+}
+
+func secureCallbackURL(id string) string {
+	return fmt.Sprintf("%s/connectors/%s/callback", SecureCallbackBaseURL, id)
+}
+
+var (
+	ErrAuthSessionUnknown = errors.New("unknown or already used auth state")
+	ErrAuthSessionExpired = errors.New("auth session expired")
+)
+
+// AuthSession is a pending OAuth flow of a connector. Its random state is
+// passed to the provider and must come back in the callback, at most once and
+// before the session expires.
+type AuthSession struct {
+	State       string
+	ConnectorID string
+	// Verifier is the PKCE code verifier, empty for providers without PKCE
+	Verifier  string
+	ExpiresAt time.Time
+}
+
+// AuthCodeURL returns the URL of the consent page of the provider for this
+// session
+func (s *AuthSession) AuthCodeURL(config *oauth2.Config, opts ...oauth2.AuthCodeOption) string {
+	opts = append(opts, oauth2.AccessTypeOffline)
+	if s.Verifier != "" {
+		opts = append(opts, oauth2.S256ChallengeOption(s.Verifier))
+	}
+	return config.AuthCodeURL(s.State, opts...)
+}
+
+var (
+	authSessionsLock sync.Mutex
+	authSessions     = map[string]*AuthSession{}
+)
+
+// NewAuthSession starts an OAuth flow for the given connector, with a PKCE
+// code verifier if the provider supports it
+func NewAuthSession(connectorID string, pkce bool) (*AuthSession, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate auth state: %v", err)
+	}
+	session := &AuthSession{
+		State:       base64.RawURLEncoding.EncodeToString(b),
+		ConnectorID: connectorID,
+		ExpiresAt:   time.Now().Add(AuthSessionTTL),
+	}
+	if pkce {
+		session.Verifier = oauth2.GenerateVerifier()
+	}
+
+	authSessionsLock.Lock()
+	defer authSessionsLock.Unlock()
+	for state, s := range authSessions {
+		if time.Now().After(s.ExpiresAt) {
+			delete(authSessions, state)
+		}
+	}
+	authSessions[session.State] = session
+	return session, nil
+}
+
+// ConsumeAuthSession returns the session of the given state and removes it,
+// so that a callback cannot be replayed
+func ConsumeAuthSession(state string) (*AuthSession, error) {
+	authSessionsLock.Lock()
+	defer authSessionsLock.Unlock()
+	session, ok := authSessions[state]
+	if !ok {
+		return nil, ErrAuthSessionUnknown
+	}
+	delete(authSessions, state)
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrAuthSessionExpired
+	}
+	return session, nil
+}
+
+// exchangeOptions returns the options of the token exchange for a session
+// with the given PKCE code verifier
+func exchangeOptions(verifier string) []oauth2.AuthCodeOption {
+	if verifier == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.VerifierOption(verifier)}
+}
@@ -2,38 +2,56 @@ package connectors
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/verbis-ai/verbis/verbis/chunker"
 	"github.com/verbis-ai/verbis/verbis/keychain"
 	"github.com/verbis-ai/verbis/verbis/store"
 	"github.com/verbis-ai/verbis/verbis/types"
 	"github.com/verbis-ai/verbis/verbis/util"
 )
 
-var AllConnectors = map[string]types.ConnectorConstructor{
-	string(types.ConnectorTypeGoogleDrive): NewGoogleDriveConnector,
-	string(types.ConnectorTypeGmail):       NewGmailConnector,
-	string(types.ConnectorTypeOutlook):     NewOutlookConnector,
-	string(types.ConnectorTypeSlack):       NewSlackConnector,
-}
-
-const (
-	// The following parameters are only used in connectors that use
-	// content chunking of larger documents.
-
-	// MaxChunkSize in number of words in a chunk
-	MaxChunkSize = 200
-	// ChunkOverlap is the number of words that overlap between chunks
-	ChunkOverlap = 0.2
+// allConnectors holds the constructors of the connector types. Plugin types
+// are registered during boot, while the API is already served.
+var (
+	allConnectors = map[string]types.ConnectorConstructor{
+		string(types.ConnectorTypeGoogleDrive): NewGoogleDriveConnector,
+		string(types.ConnectorTypeGmail):       NewGmailConnector,
+		string(types.ConnectorTypeOutlook):     NewOutlookConnector,
+		string(types.ConnectorTypeSlack):       NewSlackConnector,
+	}
+	allConnectorsLock sync.RWMutex
 )
 
+// ConnectorConstructor returns the constructor of a connector type
+func ConnectorConstructor(connectorType string) (types.ConnectorConstructor, bool) {
+	allConnectorsLock.RLock()
+	defer allConnectorsLock.RUnlock()
+	constructor, ok := allConnectors[connectorType]
+	return constructor, ok
+}
+
 func IsConnectorType(s string) bool {
-	_, ok := AllConnectors[s]
+	_, ok := ConnectorConstructor(s)
 	return ok
 }
 
+// registerConnector adds a connector type, unless it already exists
+func registerConnector(connectorType string, constructor types.ConnectorConstructor) bool {
+	allConnectorsLock.Lock()
+	defer allConnectorsLock.Unlock()
+	if _, ok := allConnectors[connectorType]; ok {
+		return false
+	}
+	allConnectors[connectorType] = constructor
+	return true
+}
+
 // BaseConnector contains methods and fields common to all connector
 // implementations. Most connectors are expected to embed BaseConnector.
 type BaseConnector struct {
@@ -43,6 +61,12 @@ type BaseConnector struct {
 	context       context.Context
 	cancel        context.CancelFunc
 	store         types.Store
+
+	// userSettings points to the settings of the embedding connector, and
+	// is set by its constructor. Syncs read it without settingsLock, as
+	// settings are not updated while the connector syncs.
+	userSettings Settings
+	settingsLock sync.RWMutex
 }
 
 func (s *BaseConnector) ID() string {
@@ -56,6 +80,72 @@ func (s *BaseConnector) Type() types.ConnectorType {
 	return s.connectorType
 }
 
+// Settings returns a copy of the current settings
+func (s *BaseConnector) Settings() interface{} {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+	settings, err := copySettings(s.userSettings)
+	if err != nil {
+		log.Printf("Unable to copy settings of connector %s: %v", s.ID(), err)
+		return nil
+	}
+	return settings
+}
+
+func (s *BaseConnector) SettingsSchema() map[string]interface{} {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+	return util.JSONSchema(s.userSettings)
+}
+
+// UpdateSettings validates and stores settings encoded as JSON. Fields that
+// are omitted keep their current value. Changes that may alter what is
+// indexed reset the sync position, see resetsSync. Settings must not be
+// updated while the connector syncs, see Syncer.UpdateSettings.
+func (s *BaseConnector) UpdateSettings(ctx context.Context, data []byte) error {
+	s.settingsLock.RLock()
+	previous, err := copySettings(s.userSettings)
+	s.settingsLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	settings, err := decodeSettings(previous, data)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("unable to encode settings: %v", err)
+	}
+	err = s.store.UpdateConnectorSettings(ctx, s.ID(), encoded)
+	if err != nil {
+		return fmt.Errorf("unable to store settings: %v", err)
+	}
+	s.settingsLock.Lock()
+	applySettings(s.userSettings, settings)
+	s.settingsLock.Unlock()
+
+	resetLastSync, resetCursors := resetsSync(previous, settings)
+	if !resetLastSync && !resetCursors {
+		return nil
+	}
+	state, err := s.store.GetConnectorState(ctx, s.ID())
+	if err != nil {
+		return fmt.Errorf("failed to get connector state: %v", err)
+	}
+	if resetLastSync {
+		state.LastSync = time.Time{}
+	}
+	if resetCursors {
+		state.SyncCursors = nil
+	}
+	return s.store.UpdateConnectorState(ctx, state)
+}
+
+func (s *BaseConnector) chunkSettings() chunker.Settings {
+	return s.userSettings.ChunkSettings()
+}
+
 func (s *BaseConnector) Status(ctx context.Context) (*types.ConnectorState, error) {
 	state, err := s.store.GetConnectorState(ctx, s.ID())
 	if err != nil {
@@ -86,6 +176,22 @@ func (c *BaseConnector) Init(ctx context.Context, connectorID string) error {
 	// Set up a new context for the connector
 	c.context, c.cancel = context.WithCancel(ctx)
 
+	stored, err := c.store.GetConnectorSettings(ctx, c.ID())
+	if err != nil {
+		return fmt.Errorf("failed to get connector settings: %v", err)
+	}
+	if stored != nil {
+		settings, err := decodeSettings(c.userSettings, stored)
+		if err != nil {
+			// Keep the defaults rather than failing to load the connector
+			log.Printf("Ignoring invalid settings of connector %s: %v", c.ID(), err)
+		} else {
+			c.settingsLock.Lock()
+			applySettings(c.userSettings, settings)
+			c.settingsLock.Unlock()
+		}
+	}
+
 	state, err := c.store.GetConnectorState(ctx, c.ID())
 	if err != nil && !store.IsStateNotFound(err) {
 		return fmt.Errorf("failed to get connector state: %v", err)
@@ -99,13 +205,22 @@ func (c *BaseConnector) Init(ctx context.Context, connectorID string) error {
 	state.Syncing = false
 	// state.User is unknown until auth is complete
 	state.ConnectorType = string(c.Type())
+	// Whether the token can still be refreshed is only known once it is
+	// used. Tokens that cannot are deleted, see invalidateAuth.
 	token, err := keychain.TokenFromKeychain(c.ID(), c.Type())
-	state.AuthValid = (err == nil && token != nil) // TODO: check for expiry of refresh token
+	state.AuthValid = (err == nil && token != nil)
+	if state.AuthValid {
+		state.AuthError = ""
+	}
 
 	err = c.store.UpdateConnectorState(ctx, state)
 	if err != nil {
 		return fmt.Errorf("failed to set connector state: %v", err)
 	}
+	err = c.store.SetConnectorAuth(ctx, c.ID(), state.AuthValid, state.AuthError)
+	if err != nil {
+		return fmt.Errorf("failed to set connector auth: %v", err)
+	}
 	return nil
 }
 
@@ -113,18 +228,34 @@ func (s *BaseConnector) UpdateConnectorState(ctx context.Context, state *types.C
 	return s.store.UpdateConnectorState(ctx, state)
 }
 
-func emitChunks(fileName string, content string, document types.Document, chunkChan chan types.ChunkSyncResult) {
-	numChunks := 0
-	content = util.CleanChunk(content)
-	chunkTexts := util.ChunkText(content, MaxChunkSize, ChunkOverlap)
-	for _, text := range chunkTexts {
-		numChunks++
-		log.Printf("Processing chunk %d of document %s", numChunks, fileName)
+// emitChunks splits the content of a document with the chunker matching its
+// MIME type and sends the chunks to chunkChan. The content is expected to be
+// raw, so that the chunker can make use of its structure. Chunks are cleaned
+// by the syncer after splitting.
+func (c *BaseConnector) emitChunks(content string, mimeType string, document types.Document, chunkChan chan types.ChunkSyncResult) {
+	chunks := chunker.ForMimeType(mimeType, c.chunkSettings()).Chunk(content)
+	for i, chunk := range chunks {
+		log.Printf("Processing chunk %d of %d of document %s", i+1, len(chunks), document.Name)
 		chunkChan <- types.ChunkSyncResult{
 			Chunk: types.Chunk{
-				Text:     text,
-				Document: document,
+				Text:        chunk.Text,
+				Document:    document,
+				Ordinal:     i,
+				PageStart:   chunk.PageStart,
+				PageEnd:     chunk.PageEnd,
+				HeadingPath: chunk.HeadingPath,
+				StartOffset: chunk.Start,
+				EndOffset:   chunk.End,
 			},
 		}
 	}
 }
+
+// itemError reports a document that failed to sync at the given stage
+func itemError(document types.Document, stage string, err error) types.ChunkSyncResult {
+	return types.ChunkSyncResult{
+		Chunk: types.Chunk{Document: document},
+		Err:   err,
+		Stage: stage,
+	}
+}
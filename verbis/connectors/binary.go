@@ -45,5 +45,5 @@ func ParseBinaryFile(ctx context.Context, request *ParseRequest) (string, error)
 		return "", fmt.Errorf("error executing script: %v", err)
 	}
 
-	return util.CleanChunk(string(output)), nil
+	return string(output), nil
 }
@@ -0,0 +1,71 @@
+package connectors
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// listingCheckpointKey is the sync cursor holding the position of an
+// interrupted full listing
+const listingCheckpointKey = "listing"
+
+// listingCheckpoint is the position of a full listing, which connectors run
+// on their first sync, or when they have no incremental sync position. It is
+// persisted after every page, so that a listing interrupted by an error or by
+// the app quitting resumes from the next page rather than from scratch.
+type listingCheckpoint struct {
+	// Since is the lastSync the listing started with. The checkpoint is
+	// ignored if the connector has synced successfully since.
+	Since time.Time `json:"since"`
+	// Start is the incremental sync position recorded before the listing
+	// started, to be committed once it completes
+	Start string `json:"start,omitempty"`
+	// Source identifies what is being listed, such as a label or folder, for
+	// connectors that list several sources in turn
+	Source string `json:"source,omitempty"`
+	// Page is the token of the next page of Source
+	Page string `json:"page,omitempty"`
+}
+
+// loadListingCheckpoint returns the checkpoint of the listing interrupted
+// during the last sync, or nil if there is none
+func loadListingCheckpoint(state *types.ConnectorState, lastSync time.Time) *listingCheckpoint {
+	value := state.SyncCursors[listingCheckpointKey]
+	if value == "" {
+		return nil
+	}
+	cp := &listingCheckpoint{}
+	err := json.Unmarshal([]byte(value), cp)
+	if err != nil {
+		log.Printf("Ignoring invalid listing checkpoint of connector %s: %v", state.ConnectorID, err)
+		return nil
+	}
+	if !cp.Since.Equal(lastSync) {
+		return nil
+	}
+	log.Printf("Resuming listing of connector %s from checkpoint", state.ConnectorID)
+	return cp
+}
+
+// saveListingCheckpoint is called once the documents listed before cp have
+// been sent to chunkChan. The checkpoint is persisted after they are indexed.
+func saveListingCheckpoint(cp listingCheckpoint, chunkChan chan types.ChunkSyncResult) {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		log.Printf("Unable to marshal listing checkpoint: %v", err)
+		return
+	}
+	chunkChan <- types.ChunkSyncResult{
+		Cursor: &types.SyncCursor{Key: listingCheckpointKey, Value: string(b)},
+	}
+}
+
+// clearListingCheckpoint is called once the listing completes
+func clearListingCheckpoint(chunkChan chan types.ChunkSyncResult) {
+	chunkChan <- types.ChunkSyncResult{
+		Cursor: &types.SyncCursor{Key: listingCheckpointKey},
+	}
+}
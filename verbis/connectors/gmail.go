@@ -3,57 +3,86 @@ package connectors
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
+	"github.com/verbis-ai/verbis/verbis/chunker"
 	"github.com/verbis-ai/verbis/verbis/keychain"
 	"github.com/verbis-ai/verbis/verbis/types"
+	"github.com/verbis-ai/verbis/verbis/util"
 )
 
 func NewGmailConnector(creds types.BuildCredentials, st types.Store) types.Connector {
-	return &GmailConnector{
+	c := &GmailConnector{
 		BaseConnector: BaseConnector{
 			connectorType: types.ConnectorTypeGmail,
 			store:         st,
 		},
 		GoogleJSONCreds: creds.GoogleJSONCreds,
+		settings:        DefaultGmailSettings,
 	}
+	c.userSettings = &c.settings
+	return c
 }
 
 type GmailConnector struct {
 	BaseConnector
 	GoogleJSONCreds string
+	settings        GmailSettings
 }
 
-func (g *GmailConnector) getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
-	// Token from Keychain
-	tok, err := keychain.TokenFromKeychain(g.ID(), g.Type())
-	if err != nil {
-		return nil, err
+// GmailSettings hold the user configuration of a Gmail connector
+type GmailSettings struct {
+	// Labels lists the labels whose emails are indexed, by name or ID
+	Labels []string `json:"labels" title:"Labels" description:"Labels whose emails are indexed, by name or ID"`
+	// IncludeSent also indexes the emails sent by the user
+	IncludeSent bool             `json:"include_sent" title:"Include sent emails" description:"Also index the emails you sent"`
+	Chunking    chunker.Settings `json:"chunking" title:"Chunking"`
+}
+
+var DefaultGmailSettings = GmailSettings{
+	Labels:   []string{"INBOX"},
+	Chunking: chunker.DefaultSettings,
+}
+
+func (s *GmailSettings) Validate() error {
+	if len(s.Labels) == 0 && !s.IncludeSent {
+		return fmt.Errorf("at least one label must be selected")
 	}
+	return s.Chunking.Validate()
+}
 
-	return config.Client(ctx, tok), nil
+func (s *GmailSettings) ChunkSettings() chunker.Settings {
+	return s.Chunking
 }
 
-func (g *GmailConnector) requestOauthWeb(config *oauth2.Config) error {
-	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:8081/connectors/%s/callback", g.ID())
-	log.Printf("Requesting token from web with redirectURL: %v", config.RedirectURL)
-	authURL := config.AuthCodeURL(g.ID(), oauth2.AccessTypeOffline)
-	fmt.Printf("Your browser has been opened to visit:\n%v\n", authURL)
+func (g *GmailConnector) getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
+	return g.httpClient(ctx, config)
+}
 
-	// Open URL in the default browser
-	return exec.Command("open", authURL).Start()
+func (g *GmailConnector) requestOauthWeb(config *oauth2.Config) (string, error) {
+	config.RedirectURL = callbackURL(g.ID())
+	log.Printf("Requesting token from web with redirectURL: %v", config.RedirectURL)
+	session, err := NewAuthSession(g.ID(), true)
+	if err != nil {
+		return "", err
+	}
+	return session.AuthCodeURL(config), nil
 }
 
 var gmailScopes []string = []string{
@@ -61,24 +90,23 @@ var gmailScopes []string = []string{
 	"https://www.googleapis.com/auth/userinfo.email",
 }
 
-func (g *GmailConnector) AuthSetup(ctx context.Context) error {
+func (g *GmailConnector) AuthSetup(ctx context.Context) (string, error) {
 	config, err := gmailConfigFromJSON(g.GoogleJSONCreds)
 	if err != nil {
-		return fmt.Errorf("unable to get google config: %s", err)
+		return "", fmt.Errorf("unable to get google config: %s", err)
 	}
 	_, err = keychain.TokenFromKeychain(g.ID(), g.Type())
 	if err == nil {
 		// TODO: check for expiry of refresh token
 		log.Print("Token found in keychain.")
-		return nil
+		return "", nil
 	}
 	log.Print("No token found in keychain. Getting token from web.")
-	err = g.requestOauthWeb(config)
+	authURL, err := g.requestOauthWeb(config)
 	if err != nil {
-		log.Printf("Unable to request token from web: %v", err)
+		return "", fmt.Errorf("unable to request token from web: %v", err)
 	}
-
-	return nil
+	return authURL, nil
 }
 
 func gmailConfigFromJSON(credsBlob string) (*oauth2.Config, error) {
@@ -86,15 +114,15 @@ func gmailConfigFromJSON(credsBlob string) (*oauth2.Config, error) {
 }
 
 // TODO: handle token expiries
-func (g *GmailConnector) AuthCallback(ctx context.Context, authCode string) error {
+func (g *GmailConnector) AuthCallback(ctx context.Context, authCode string, verifier string) error {
 	config, err := gmailConfigFromJSON(g.GoogleJSONCreds)
 	if err != nil {
 		return fmt.Errorf("unable to get google config: %s", err)
 	}
 
-	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:8081/connectors/%s/callback", g.ID())
+	config.RedirectURL = callbackURL(g.ID())
 	log.Printf("Config: %v", config)
-	tok, err := config.Exchange(ctx, authCode)
+	tok, err := config.Exchange(ctx, authCode, exchangeOptions(verifier)...)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve token from web: %v", err)
 	}
@@ -121,63 +149,363 @@ func (g *GmailConnector) AuthCallback(ctx context.Context, authCode string) erro
 	return g.UpdateConnectorState(ctx, state)
 }
 
-func (g *GmailConnector) Sync(lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+func (g *GmailConnector) service(ctx context.Context) (*gmail.Service, error) {
+	config, err := gmailConfigFromJSON(g.GoogleJSONCreds)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get google config: %s", err)
+	}
+
+	client, err := g.getClient(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get client: %v", err)
+	}
+
+	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Gmail client: %v", err)
+	}
+	return srv, nil
+}
+
+func (g *GmailConnector) Sync(ctx context.Context, lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
 	defer close(chunkChan)
 
 	log.Printf("Starting gmail sync")
-	config, err := gmailConfigFromJSON(g.GoogleJSONCreds)
+	srv, err := g.service(ctx)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to get google config: %s", err)
+		errChan <- err
 		return
 	}
 
-	client, err := g.getClient(g.context, config)
+	state, err := g.Status(ctx)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to get client: %v", err)
+		errChan <- fmt.Errorf("unable to get connector state: %v", err)
 		return
 	}
 
-	srv, err := gmail.NewService(g.context, option.WithHTTPClient(client))
+	labelIDs, err := g.selectedLabels(ctx, srv)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to retrieve Gmail client: %v", err)
+		errChan <- fmt.Errorf("unable to get labels: %v", err)
 		return
 	}
 
-	err = g.listEmails(g.context, srv, lastSync, chunkChan)
+	// A checkpoint means that the last sync was interrupted while listing,
+	// in which case the history cursor, if any, has expired
+	cp := loadListingCheckpoint(state, lastSync)
+	historyID := state.SyncCursors[gmailHistoryCursorKey]
+	if cp == nil && historyID != "" {
+		err = g.syncHistory(ctx, srv, historyID, labelIDs, chunkChan)
+		if !isErrHistoryExpired(err) {
+			if err != nil {
+				errChan <- fmt.Errorf("unable to sync history: %v", err)
+			}
+			return
+		}
+		log.Printf("Gmail history %s has expired, listing emails since last sync", historyID)
+	}
+
+	if cp == nil {
+		// Record the current history ID before listing, so that no change
+		// made during the listing is missed
+		profile, err := srv.Users.GetProfile(gmailUser).Context(ctx).Do()
+		if err != nil {
+			errChan <- fmt.Errorf("unable to get profile: %v", err)
+			return
+		}
+		cp = &listingCheckpoint{
+			Since: lastSync,
+			Start: strconv.FormatUint(profile.HistoryId, 10),
+		}
+	}
+
+	err = g.listEmails(ctx, srv, cp, labelIDs, chunkChan)
 	if err != nil {
 		errChan <- fmt.Errorf("unable to list emails: %v", err)
 		return
 	}
+
+	clearListingCheckpoint(chunkChan)
+	chunkChan <- types.ChunkSyncResult{
+		Cursor: &types.SyncCursor{
+			Key:   gmailHistoryCursorKey,
+			Value: cp.Start,
+		},
+	}
 }
 
-func (g *GmailConnector) processEmail(ctx context.Context, srv *gmail.Service, email *gmail.Message, chunkChan chan types.ChunkSyncResult) {
-	var content string
-	for _, part := range email.Payload.Parts {
-		if part.MimeType == "text/plain" {
-			data, err := decodeBase64(part.Body.Data)
-			if err != nil {
-				chunkChan <- types.ChunkSyncResult{
-					Err: fmt.Errorf("unable to decode email body: %s", err),
+const (
+	gmailUser = "me"
+	// gmailHistoryCursorKey is the sync cursor holding the ID of the last
+	// synced mailbox history record
+	gmailHistoryCursorKey = "history"
+	// Maximum number of emails fetched in parallel
+	gmailParallelism = 10
+)
+
+// selectedLabels resolves the labels selected in the connector settings,
+// which may be given by name or ID, to label IDs
+func (g *GmailConnector) selectedLabels(ctx context.Context, srv *gmail.Service) (map[string]bool, error) {
+	labels, err := srv.Users.Labels.List(gmailUser).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := append([]string{}, g.settings.Labels...)
+	if g.settings.IncludeSent {
+		selected = append(selected, "SENT")
+	}
+
+	res := map[string]bool{}
+	for _, name := range selected {
+		found := false
+		for _, label := range labels.Labels {
+			if label.Id == name || strings.EqualFold(label.Name, name) {
+				res[label.Id] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("Ignoring unknown gmail label %s", name)
+		}
+	}
+	return res, nil
+}
+
+// isSelected reports whether an email has one of the selected labels, and is
+// not spam or trash
+func isSelected(email *gmail.Message, labelIDs map[string]bool) bool {
+	selected := false
+	for _, labelID := range email.LabelIds {
+		if labelID == "SPAM" || labelID == "TRASH" {
+			return false
+		}
+		if labelIDs[labelID] {
+			selected = true
+		}
+	}
+	return selected
+}
+
+// isErrHistoryExpired tells whether the history of the mailbox no longer
+// goes back to the requested ID, which Gmail reports as not found
+func isErrHistoryExpired(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusNotFound
+}
+
+// syncHistory processes the changes to the mailbox since the given history
+// ID. Emails that were added or gained a selected label are indexed, and
+// tombstones are reported for those deleted or that left the selection.
+func (g *GmailConnector) syncHistory(ctx context.Context, srv *gmail.Service, historyID string, labelIDs map[string]bool, chunkChan chan types.ChunkSyncResult) error {
+	startID, err := strconv.ParseUint(historyID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid history ID %s: %v", historyID, err)
+	}
+
+	// Labels that change whether an email is selected. Other label changes,
+	// such as an email being read, do not require re-indexing.
+	relevant := func(ids []string) bool {
+		for _, id := range ids {
+			if labelIDs[id] || id == "SPAM" || id == "TRASH" {
+				return true
+			}
+		}
+		return false
+	}
+
+	changed := []string{}
+	deleted := map[string]bool{}
+	var lastHistoryID uint64
+	err = srv.Users.History.List(gmailUser).
+		StartHistoryId(startID).
+		HistoryTypes("messageAdded", "messageDeleted", "labelAdded", "labelRemoved").
+		MaxResults(500).
+		Pages(ctx, func(page *gmail.ListHistoryResponse) error {
+			lastHistoryID = page.HistoryId
+			for _, h := range page.History {
+				for _, m := range h.MessagesAdded {
+					changed = append(changed, m.Message.Id)
+				}
+				for _, m := range h.LabelsAdded {
+					if relevant(m.LabelIds) {
+						changed = append(changed, m.Message.Id)
+					}
+				}
+				for _, m := range h.LabelsRemoved {
+					if relevant(m.LabelIds) {
+						changed = append(changed, m.Message.Id)
+					}
+				}
+				for _, m := range h.MessagesDeleted {
+					deleted[m.Message.Id] = true
 				}
-				continue
 			}
-			content += data
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for id := range deleted {
+		chunkChan <- types.ChunkSyncResult{Tombstone: id}
+	}
+	pending := []string{}
+	seen := map[string]bool{}
+	for _, id := range changed {
+		if !deleted[id] && !seen[id] {
+			pending = append(pending, id)
+			seen[id] = true
 		}
-		// Process attachments
-		if part.Filename != "" && part.MimeType == "application/pdf" {
-			data, err := downloadAttachment(ctx, srv, g.user, email.Id, part.Body.AttachmentId)
+	}
+	log.Printf("Gmail history since %s: %d changed, %d deleted emails", historyID, len(pending), len(deleted))
+	g.processEmails(ctx, srv, pending, labelIDs, chunkChan)
+
+	if lastHistoryID != 0 {
+		chunkChan <- types.ChunkSyncResult{
+			Cursor: &types.SyncCursor{
+				Key:   gmailHistoryCursorKey,
+				Value: strconv.FormatUint(lastHistoryID, 10),
+			},
+		}
+	}
+	return nil
+}
+
+// processEmails fetches and indexes emails in parallel. Emails that no
+// longer exist or are not selected are reported as tombstones.
+func (g *GmailConnector) processEmails(ctx context.Context, srv *gmail.Service, messageIDs []string, labelIDs map[string]bool, chunkChan chan types.ChunkSyncResult) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, gmailParallelism)
+	for _, id := range messageIDs {
+		log.Printf("Processing message %s", id)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(messageID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			email, err := srv.Users.Messages.Get(gmailUser, messageID).Format("full").Context(ctx).Do()
+			if isErrNotFound(err) {
+				// Deleted since the change was recorded
+				chunkChan <- types.ChunkSyncResult{Tombstone: messageID}
+				return
+			}
 			if err != nil {
-				chunkChan <- types.ChunkSyncResult{
-					Err: fmt.Errorf("unable to download attachment for file %s: %s", part.Filename, err),
-				}
+				chunkChan <- itemError(
+					types.Document{UniqueID: messageID},
+					types.SyncStageFetch,
+					fmt.Errorf("unable to retrieve message %s: %v", messageID, err),
+				)
+				return
+			}
+			if !isSelected(email, labelIDs) {
+				chunkChan <- types.ChunkSyncResult{Tombstone: messageID}
+				return
+			}
+			g.processEmail(ctx, srv, email, chunkChan)
+		}(id)
+	}
+	wg.Wait()
+}
+
+// ListInventory lists the IDs of all emails with one of the selected labels
+func (g *GmailConnector) ListInventory(ctx context.Context) ([]string, error) {
+	srv, err := g.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labelIDs, err := g.selectedLabels(ctx, srv)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get labels: %v", err)
+	}
+
+	ids := []string{}
+	err = listMessageIDs(ctx, srv, labelIDs, "", nil, func(page []string, _ listingCheckpoint) {
+		ids = append(ids, page...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list emails: %v", err)
+	}
+	return ids, nil
+}
+
+// RetryItems fetches and indexes again the emails with the given IDs
+func (g *GmailConnector) RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	defer close(chunkChan)
+	srv, err := g.service(ctx)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	labelIDs, err := g.selectedLabels(ctx, srv)
+	if err != nil {
+		errChan <- fmt.Errorf("unable to get labels: %v", err)
+		return
+	}
+	g.processEmails(ctx, srv, uniqueIDs, labelIDs, chunkChan)
+}
+
+// listMessageIDs lists the IDs of the emails with any of the given labels,
+// calling f for each page of IDs not seen before, along with the position of
+// the next page. Labels are listed one after the other, and the listing
+// starts from the position of resume, if set.
+func listMessageIDs(ctx context.Context, srv *gmail.Service, labelIDs map[string]bool, query string, resume *listingCheckpoint, f func([]string, listingCheckpoint)) error {
+	sorted := make([]string, 0, len(labelIDs))
+	for labelID := range labelIDs {
+		sorted = append(sorted, labelID)
+	}
+	sort.Strings(sorted)
+
+	seen := map[string]bool{}
+	for i, labelID := range sorted {
+		pageToken := ""
+		if resume != nil && resume.Source != "" {
+			if labelID < resume.Source {
 				continue
 			}
-			content += data
+			if labelID == resume.Source {
+				pageToken = resume.Page
+			}
+		}
+
+		onPage := func(page *gmail.ListMessagesResponse) error {
+			ids := []string{}
+			for _, m := range page.Messages {
+				if !seen[m.Id] {
+					seen[m.Id] = true
+					ids = append(ids, m.Id)
+				}
+			}
+			next := listingCheckpoint{Source: labelID, Page: page.NextPageToken}
+			if next.Page == "" && i+1 < len(sorted) {
+				next.Source = sorted[i+1]
+			}
+			f(ids, next)
+			return nil
+		}
+
+		req := srv.Users.Messages.List(gmailUser).
+			LabelIds(labelID).
+			MaxResults(500).
+			Fields("nextPageToken", "messages(id)")
+		if query != "" {
+			req = req.Q(query)
+		}
+		err := req.PageToken(pageToken).Pages(ctx, onPage)
+		if err != nil && pageToken != "" && isErrInvalidPageToken(err) {
+			log.Printf("Checkpointed page token of label %s was rejected, listing it from the start", labelID)
+			err = req.PageToken("").Pages(ctx, onPage)
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
+func (g *GmailConnector) processEmail(ctx context.Context, srv *gmail.Service, email *gmail.Message, chunkChan chan types.ChunkSyncResult) {
 	receivedAt := time.Unix(email.InternalDate/1000, 0)
-	emailURL := fmt.Sprintf("https://mail.google.com/mail/u/0/#inbox/%s", email.Id)
+	emailURL := fmt.Sprintf("https://mail.google.com/mail/u/0/#all/%s", email.Id)
 	subject := getEmailSubject(email.Payload.Headers)
 
 	document := types.Document{
@@ -188,6 +516,33 @@ func (g *GmailConnector) processEmail(ctx context.Context, srv *gmail.Service, e
 		ConnectorType: string(g.Type()),
 		CreatedAt:     receivedAt,
 		UpdatedAt:     receivedAt,
+		Metadata: map[string]string{
+			types.DocumentMetadataFrom:     getEmailHeader(email.Payload.Headers, "From"),
+			types.DocumentMetadataTo:       getEmailHeader(email.Payload.Headers, "To"),
+			types.DocumentMetadataCc:       getEmailHeader(email.Payload.Headers, "Cc"),
+			types.DocumentMetadataThreadID: email.ThreadId,
+		},
+	}
+
+	var content string
+	for _, part := range email.Payload.Parts {
+		if part.MimeType == "text/plain" {
+			data, err := decodeBase64(part.Body.Data)
+			if err != nil {
+				chunkChan <- itemError(document, types.SyncStageParse, fmt.Errorf("unable to decode email body: %s", err))
+				continue
+			}
+			content += data
+		}
+		// Process attachments
+		if part.Filename != "" && part.MimeType == "application/pdf" {
+			data, err := downloadAttachment(ctx, srv, g.user, email.Id, part.Body.AttachmentId)
+			if err != nil {
+				chunkChan <- itemError(document, types.SyncStageFetch, fmt.Errorf("unable to download attachment for file %s: %s", part.Filename, err))
+				continue
+			}
+			content += data
+		}
 	}
 
 	err := g.store.DeleteDocumentChunks(ctx, document.UniqueID, g.ID())
@@ -195,42 +550,38 @@ func (g *GmailConnector) processEmail(ctx context.Context, srv *gmail.Service, e
 		log.Printf("Unable to delete chunks for document %s: %v", document.UniqueID, err)
 	}
 
-	emitChunks(subject, content, document, chunkChan)
+	g.emitChunks(content, "text/plain", document, chunkChan)
 }
 
-func (g *GmailConnector) listEmails(ctx context.Context, srv *gmail.Service, lastSync time.Time, chunkChan chan types.ChunkSyncResult) error {
-	user := "me"
-	query := "in:inbox -category:spam"
-	if !lastSync.IsZero() {
-		query += fmt.Sprintf(" after:%d", lastSync.Unix())
-	}
-
-	req := srv.Users.Messages.List(user).Q(query).MaxResults(10)
-	err := req.Pages(ctx, func(page *gmail.ListMessagesResponse) error {
-		var wg sync.WaitGroup
-		for _, m := range page.Messages {
-			log.Printf("Processing message %s", m.Id)
-			wg.Add(1)
-			go func(messageID string) {
-				defer wg.Done()
-				email, err := srv.Users.Messages.Get(user, messageID).Format("full").Do()
-				if err != nil {
-					log.Printf("Unable to retrieve message %s: %v", messageID, err)
-					return
-				}
-				g.processEmail(ctx, srv, email, chunkChan)
-			}(m.Id)
-		}
-		wg.Wait()
-		return nil
-	})
+// listEmails indexes all emails with one of the selected labels, or only
+// those received since cp.Since if it is set, resuming from cp
+func (g *GmailConnector) listEmails(ctx context.Context, srv *gmail.Service, cp *listingCheckpoint, labelIDs map[string]bool, chunkChan chan types.ChunkSyncResult) error {
+	query := ""
+	if !cp.Since.IsZero() {
+		query = fmt.Sprintf("after:%d", cp.Since.Unix())
+	}
 
+	err := listMessageIDs(ctx, srv, labelIDs, query, cp, func(ids []string, next listingCheckpoint) {
+		g.processEmails(ctx, srv, ids, labelIDs, chunkChan)
+		next.Since = cp.Since
+		next.Start = cp.Start
+		saveListingCheckpoint(next, chunkChan)
+	})
 	if err != nil {
 		return fmt.Errorf("unable to retrieve emails: %v", err)
 	}
 	return nil
 }
 
+func getEmailHeader(headers []*gmail.MessagePartHeader, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
 func getEmailSubject(headers []*gmail.MessagePartHeader) string {
 	for _, h := range headers {
 		if h.Name == "Subject" {
@@ -258,12 +609,10 @@ func downloadAttachment(ctx context.Context, srv *gmail.Service, userID, message
 		return "", err
 	}
 
-	homeDir, err := os.UserHomeDir()
+	tempDir, err := util.DataPath("tmp")
 	if err != nil {
-		return "", fmt.Errorf("failed to get user home directory: %v", err)
+		return "", err
 	}
-
-	tempDir := filepath.Join(homeDir, ".verbis", "tmp")
 	err = os.MkdirAll(tempDir, os.ModePerm)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temporary directory: %v", err)
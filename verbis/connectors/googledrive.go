@@ -11,9 +11,9 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -22,8 +22,10 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
+	"github.com/verbis-ai/verbis/verbis/chunker"
 	"github.com/verbis-ai/verbis/verbis/keychain"
 	"github.com/verbis-ai/verbis/verbis/types"
+	"github.com/verbis-ai/verbis/verbis/util"
 )
 
 const (
@@ -36,37 +38,65 @@ const (
 )
 
 func NewGoogleDriveConnector(creds types.BuildCredentials, st types.Store) types.Connector {
-	return &GoogleDriveConnector{
+	c := &GoogleDriveConnector{
 		BaseConnector: BaseConnector{
 			connectorType: types.ConnectorTypeGoogleDrive,
 			store:         st,
 		},
 		GoogleJSONCreds: creds.GoogleJSONCreds,
+		settings:        DefaultGoogleDriveSettings,
 	}
+	c.userSettings = &c.settings
+	return c
 }
 
 type GoogleDriveConnector struct {
 	BaseConnector
 	GoogleJSONCreds string
+	settings        GoogleDriveSettings
+
+	// folderParents caches the parents of folders during a sync
+	folderParents sync.Map
+}
+
+// GoogleDriveSettings hold the user configuration of a Google Drive connector
+type GoogleDriveSettings struct {
+	// IncludeSharedDrives indexes the shared drives of the user, in addition
+	// to their own drive
+	IncludeSharedDrives bool `json:"include_shared_drives" title:"Include shared drives" description:"Also index the shared drives you are a member of"`
+	// IncludeFolders restricts indexing to the files within these folders, at
+	// any depth. All files are indexed if empty.
+	IncludeFolders []string `json:"include_folders" title:"Included folders" description:"IDs of the folders to index. All folders are indexed if empty"`
+	// ExcludeFolders skips the files within these folders, at any depth
+	ExcludeFolders []string         `json:"exclude_folders" title:"Excluded folders" description:"IDs of the folders to skip"`
+	Chunking       chunker.Settings `json:"chunking" title:"Chunking"`
+}
+
+var DefaultGoogleDriveSettings = GoogleDriveSettings{
+	IncludeSharedDrives: true,
+	Chunking:            chunker.DefaultSettings,
+}
+
+func (s *GoogleDriveSettings) Validate() error {
+	return s.Chunking.Validate()
+}
+
+func (s *GoogleDriveSettings) ChunkSettings() chunker.Settings {
+	return s.Chunking
 }
 
 func (g *GoogleDriveConnector) getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
-	// Token from Keychain
-	tok, err := keychain.TokenFromKeychain(g.ID(), g.Type())
-	if err != nil {
-		return nil, err
-	}
-	return config.Client(ctx, tok), nil
+	return g.httpClient(ctx, config)
 }
 
-func (g *GoogleDriveConnector) requestOauthWeb(config *oauth2.Config) error {
-	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:8081/connectors/%s/callback", g.ID())
+func (g *GoogleDriveConnector) requestOauthWeb(config *oauth2.Config) (string, error) {
+	config.RedirectURL = callbackURL(g.ID())
 	log.Printf("Requesting token from web with redirectURL: %v", config.RedirectURL)
-	authURL := config.AuthCodeURL(g.ID(), oauth2.AccessTypeOffline)
-	fmt.Printf("Your browser has been opened to visit:\n%v\n", authURL)
-
-	// Open URL in the default browser
-	return exec.Command("open", authURL).Start()
+	session, err := NewAuthSession(g.ID(), true)
+	if err != nil {
+		return "", err
+	}
+	return session.AuthCodeURL(config), nil
 }
 
 var driveScopes []string = []string{
@@ -79,37 +109,35 @@ func driveConfigFromJSON(googleJSONCreds string) (*oauth2.Config, error) {
 	return google.ConfigFromJSON([]byte(googleJSONCreds), driveScopes...)
 }
 
-func (g *GoogleDriveConnector) AuthSetup(ctx context.Context) error {
+func (g *GoogleDriveConnector) AuthSetup(ctx context.Context) (string, error) {
 	config, err := driveConfigFromJSON(g.GoogleJSONCreds)
 	if err != nil {
-		return fmt.Errorf("unable to get google config: %s", err)
+		return "", fmt.Errorf("unable to get google config: %s", err)
 	}
-	fmt.Println("Google Drive AuthSetup")
-	fmt.Println(config)
 	_, err = keychain.TokenFromKeychain(g.ID(), g.Type())
 	if err == nil {
 		// TODO: check for expiry of refresh token
 		log.Print("Token found in keychain.")
-		return nil
+		return "", nil
 	}
 	log.Print("No token found in keychain. Getting token from web.")
-	err = g.requestOauthWeb(config)
+	authURL, err := g.requestOauthWeb(config)
 	if err != nil {
-		log.Printf("Unable to request token from web: %v", err)
+		return "", fmt.Errorf("unable to request token from web: %v", err)
 	}
-	return nil
+	return authURL, nil
 }
 
 // TODO: handle token expiries
-func (g *GoogleDriveConnector) AuthCallback(ctx context.Context, authCode string) error {
+func (g *GoogleDriveConnector) AuthCallback(ctx context.Context, authCode string, verifier string) error {
 	config, err := driveConfigFromJSON(g.GoogleJSONCreds)
 	if err != nil {
 		return fmt.Errorf("unable to get google config: %s", err)
 	}
 
-	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:8081/connectors/%s/callback", g.ID())
+	config.RedirectURL = callbackURL(g.ID())
 	log.Printf("Config: %v", config)
-	tok, err := config.Exchange(ctx, authCode)
+	tok, err := config.Exchange(ctx, authCode, exchangeOptions(verifier)...)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve token from web: %v", err)
 	}
@@ -159,61 +187,332 @@ func getUserEmail(client *http.Client) (string, error) {
 	return userInfo.Email, nil
 }
 
-func (g *GoogleDriveConnector) Sync(lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+func (g *GoogleDriveConnector) service(ctx context.Context) (*drive.Service, error) {
+	config, err := driveConfigFromJSON(g.GoogleJSONCreds)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get google config: %s", err)
+	}
+
+	client, err := g.getClient(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get client: %v", err)
+	}
+
+	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Drive client: %v", err)
+	}
+	return srv, nil
+}
+
+func (g *GoogleDriveConnector) Sync(ctx context.Context, lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
 	defer close(chunkChan)
-	if err := g.context.Err(); err != nil {
+	if err := ctx.Err(); err != nil {
 		errChan <- fmt.Errorf("context error: %s", err)
 		return
 	}
 
-	config, err := driveConfigFromJSON(g.GoogleJSONCreds)
+	srv, err := g.service(ctx)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to get google config: %s", err)
+		errChan <- err
 		return
 	}
 
-	client, err := g.getClient(g.context, config)
+	state, err := g.Status(ctx)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to get client: %v", err)
+		errChan <- fmt.Errorf("unable to get connector state: %v", err)
 		return
 	}
 
-	srv, err := drive.NewService(g.context, option.WithHTTPClient(client))
-	if err != nil {
-		errChan <- fmt.Errorf("unable to retrieve Drive client: %v", err)
+	// The folder hierarchy may have changed since the last sync
+	g.folderParents = sync.Map{}
+
+	changesToken := state.SyncCursors[driveChangesCursorKey]
+	if changesToken != "" {
+		err = g.listChanges(ctx, srv, changesToken, state.SyncCursors[driveWithheldCursorKey], chunkChan)
+		if err != nil {
+			errChan <- fmt.Errorf("unable to list changes: %v", err)
+		}
 		return
 	}
 
-	err = g.listFiles(g.context, srv, lastSync, chunkChan)
+	cp := loadListingCheckpoint(state, lastSync)
+	if cp == nil {
+		// First sync: get a changes token before listing files, so that no
+		// change made during the listing is missed. lastSync is only set
+		// here for connectors synced by older versions, which did not record
+		// a changes token.
+		start, err := srv.Changes.GetStartPageToken().SupportsAllDrives(true).Context(ctx).Do()
+		if err != nil {
+			errChan <- fmt.Errorf("unable to get changes start page token: %v", err)
+			return
+		}
+		cp = &listingCheckpoint{Since: lastSync, Start: start.StartPageToken}
+	}
+
+	err = g.listFiles(ctx, srv, cp, chunkChan)
 	if err != nil {
 		errChan <- fmt.Errorf("unable to list files: %v", err)
 		return
 	}
+
+	clearListingCheckpoint(chunkChan)
+	chunkChan <- types.ChunkSyncResult{
+		Cursor: &types.SyncCursor{Key: driveChangesCursorKey, Value: cp.Start},
+	}
+}
+
+// RetryItems fetches and indexes again the files with the given IDs
+func (g *GoogleDriveConnector) RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	defer close(chunkChan)
+	srv, err := g.service(ctx)
+	if err != nil {
+		errChan <- err
+		return
+	}
+
+	files := []*drive.File{}
+	for _, fileID := range uniqueIDs {
+		file, err := srv.Files.Get(fileID).
+			SupportsAllDrives(true).
+			Fields(driveFileFields).
+			Context(ctx).
+			Do()
+		if isErrNotFound(err) || (err == nil && file.Trashed) {
+			chunkChan <- types.ChunkSyncResult{Tombstone: fileID}
+			continue
+		}
+		if err != nil {
+			chunkChan <- itemError(
+				types.Document{UniqueID: fileID},
+				types.SyncStageFetch,
+				fmt.Errorf("unable to get file %s: %v", fileID, err),
+			)
+			continue
+		}
+		files = append(files, file)
+	}
+	g.processFiles(ctx, srv, files, chunkChan)
+}
+
+// isErrNotFound tells whether a Google API could not find an object
+func isErrNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// isErrInvalidPageToken tells whether a Google API rejected a page token,
+// e.g. one that expired since it was checkpointed
+func isErrInvalidPageToken(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest
+}
+
+// driveChangesCursorKey is the sync cursor holding the page token of the
+// Drive changes feed
+const driveChangesCursorKey = "changes"
+
+// driveWithheldCursorKey is the sync cursor holding the page token of the
+// changes feed last withheld because some of its files could not be fetched
+const driveWithheldCursorKey = "changes_withheld"
+
+const (
+	driveFolderMimeType = "application/vnd.google-apps.folder"
+	driveFileFields     = "id, name, webViewLink, createdTime, modifiedTime, mimeType, parents, trashed"
+	// Maximum page size allowed by the Drive API
+	drivePageSize = 1000
+	// Maximum number of files processed in parallel
+	driveParallelism = 10
+)
+
+// listChanges processes all changes since the given page token: changed
+// files are re-indexed, and a tombstone is reported for every file that was
+// removed, trashed, or moved out of the selected folders. The page token is
+// committed after every page so that an interrupted sync resumes from it.
+// When files of a page cannot be fetched, the token of that page is kept
+// instead, so that the next sync processes it again. A page withheld once is
+// not withheld again, its failed files are then left to the retry of sync
+// errors.
+func (g *GoogleDriveConnector) listChanges(ctx context.Context, service *drive.Service, pageToken, withheldToken string, chunkChan chan types.ChunkSyncResult) error {
+	withheld := false
+	for {
+		changes, err := service.Changes.List(pageToken).
+			PageSize(drivePageSize).
+			SupportsAllDrives(true).
+			IncludeItemsFromAllDrives(g.settings.IncludeSharedDrives).
+			Fields(googleapi.Field(fmt.Sprintf("nextPageToken, newStartPageToken, changes(changeType, fileId, removed, file(%s))", driveFileFields))).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return fmt.Errorf("unable to list changes: %v", err)
+		}
+
+		files := []*drive.File{}
+		for _, change := range changes.Changes {
+			if change.ChangeType != "" && change.ChangeType != "file" {
+				// Changes to shared drives themselves
+				continue
+			}
+			if change.Removed || change.File == nil || change.File.Trashed {
+				chunkChan <- types.ChunkSyncResult{Tombstone: change.FileId}
+				continue
+			}
+			if change.File.MimeType == driveFolderMimeType {
+				continue
+			}
+			if !g.isSelected(ctx, service, change.File) {
+				// Possibly moved out of the selected folders
+				chunkChan <- types.ChunkSyncResult{Tombstone: change.FileId}
+				continue
+			}
+			files = append(files, change.File)
+		}
+		failed := g.processFiles(ctx, service, files, chunkChan)
+		if failed > 0 && !withheld && pageToken != withheldToken {
+			log.Printf("Unable to fetch %d files, keeping the changes page token to process them again", failed)
+			withheld = true
+			chunkChan <- types.ChunkSyncResult{
+				Cursor: &types.SyncCursor{Key: driveWithheldCursorKey, Value: pageToken},
+			}
+		}
+
+		next := changes.NextPageToken
+		if changes.NewStartPageToken != "" {
+			next = changes.NewStartPageToken
+		}
+		if !withheld {
+			chunkChan <- types.ChunkSyncResult{
+				Cursor: &types.SyncCursor{Key: driveChangesCursorKey, Value: next},
+			}
+		}
+		if changes.NewStartPageToken != "" {
+			return nil
+		}
+		pageToken = next
+	}
 }
 
-func (g *GoogleDriveConnector) processFile(ctx context.Context, service *drive.Service, file *drive.File, chunkChan chan types.ChunkSyncResult) {
+// processFiles processes files in parallel, returning once all of them are
+// done with the number of files that could not be fetched
+func (g *GoogleDriveConnector) processFiles(ctx context.Context, service *drive.Service, files []*drive.File, chunkChan chan types.ChunkSyncResult) int {
+	var failed atomic.Int32
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, driveParallelism)
+	for _, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(f *drive.File) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if !g.processFile(ctx, service, f, chunkChan) {
+				failed.Add(1)
+			}
+		}(file)
+	}
+	wg.Wait()
+	return int(failed.Load())
+}
+
+// isSelected reports whether a file is within the folders selected in the
+// connector settings
+func (g *GoogleDriveConnector) isSelected(ctx context.Context, service *drive.Service, file *drive.File) bool {
+	if len(g.settings.IncludeFolders) == 0 && len(g.settings.ExcludeFolders) == 0 {
+		return true
+	}
+
+	ancestors := g.ancestors(ctx, service, file.Parents)
+	for _, folder := range g.settings.ExcludeFolders {
+		if ancestors[folder] {
+			return false
+		}
+	}
+	if len(g.settings.IncludeFolders) == 0 {
+		return true
+	}
+	for _, folder := range g.settings.IncludeFolders {
+		if ancestors[folder] {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestors returns the set of all folders containing the given parents,
+// including the parents themselves. The root folder of a shared drive has
+// the ID of the drive, so drives can be selected like folders.
+func (g *GoogleDriveConnector) ancestors(ctx context.Context, service *drive.Service, parents []string) map[string]bool {
+	res := map[string]bool{}
+	queue := append([]string{}, parents...)
+	for len(queue) > 0 {
+		folderID := queue[0]
+		queue = queue[1:]
+		if res[folderID] {
+			continue
+		}
+		res[folderID] = true
+
+		cached, ok := g.folderParents.Load(folderID)
+		if ok {
+			queue = append(queue, cached.([]string)...)
+			continue
+		}
+		folder, err := service.Files.Get(folderID).
+			SupportsAllDrives(true).
+			Fields("id, parents").
+			Context(ctx).
+			Do()
+		if err != nil {
+			// Not accessible, treat it as a root
+			log.Printf("Unable to get folder %s: %v", folderID, err)
+			g.folderParents.Store(folderID, []string{})
+			continue
+		}
+		g.folderParents.Store(folderID, folder.Parents)
+		queue = append(queue, folder.Parents...)
+	}
+	return res
+}
+
+// processFile indexes a file, and returns false if it could not be fetched.
+// Files that fail to parse are not expected to succeed when fetched again.
+func (g *GoogleDriveConnector) processFile(ctx context.Context, service *drive.Service, file *drive.File, chunkChan chan types.ChunkSyncResult) bool {
 	var content string
 	var err error
+	// contentType is the MIME type of the extracted content, used to pick
+	// the chunking strategy
+	contentType := "text/plain"
 	if file.MimeType == "application/vnd.google-apps.document" {
-		content, err = exportFile(service, file.Id, "text/plain")
+		// Markdown preserves the heading structure of the document
+		contentType = "text/markdown"
+		content, err = exportFile(service, file.Id, contentType)
+		if err != nil {
+			log.Printf("Unable to export %s as markdown, falling back to plain text: %v", file.Name, err)
+			contentType = "text/plain"
+			content, err = exportFile(service, file.Id, contentType)
+		}
 	} else if file.MimeType == "application/vnd.google-apps.spreadsheet" {
-		content, err = exportFile(service, file.Id, "text/csv")
+		contentType = "text/csv"
+		content, err = exportFile(service, file.Id, contentType)
 	} else if file.MimeType == "application/vnd.google-apps.presentation" {
-		content, err = exportFile(service, file.Id, "text/plain")
+		content, err = exportFile(service, file.Id, contentType)
 	} else {
 		content, err = downloadAndParseBinaryFile(ctx, service, file)
 		if err != nil {
-			chunkChan <- types.ChunkSyncResult{
-				Err: fmt.Errorf("unable to process binary file %s: %v", file.Name, err),
-			}
-			return
+			chunkChan <- itemError(
+				types.Document{UniqueID: file.Id, Name: file.Name},
+				types.SyncStageParse,
+				fmt.Errorf("unable to process binary file %s: %v", file.Name, err),
+			)
+			return true
 		}
 	}
 	if err != nil {
-		chunkChan <- types.ChunkSyncResult{
-			Err: fmt.Errorf("unable to export file %s of mimetype %s: %v", file.Name, file.MimeType, err),
-		}
-		return
+		chunkChan <- itemError(
+			types.Document{UniqueID: file.Id, Name: file.Name},
+			types.SyncStageFetch,
+			fmt.Errorf("unable to export file %s of mimetype %s: %v", file.Name, file.MimeType, err),
+		)
+		return false
 	}
 
 	log.Printf("Document: %s, %s, %s", file.Name, file.CreatedTime, file.ModifiedTime)
@@ -246,28 +545,49 @@ func (g *GoogleDriveConnector) processFile(ctx context.Context, service *drive.S
 		log.Printf("Unable to delete chunks for document %s: %v", document.UniqueID, err)
 	}
 
-	emitChunks(file.Name, content, document, chunkChan)
+	g.emitChunks(content, contentType, document, chunkChan)
+	return true
 }
 
-func (g *GoogleDriveConnector) listFiles(ctx context.Context, service *drive.Service, lastSync time.Time, chunkChan chan types.ChunkSyncResult) error {
-	pageToken := ""
+// listFiles lists the files modified since the checkpoint was started,
+// resuming from its page, and checkpoints every page
+func (g *GoogleDriveConnector) listFiles(ctx context.Context, service *drive.Service, cp *listingCheckpoint, chunkChan chan types.ChunkSyncResult) error {
+	lastSync := cp.Since
+	pageToken := cp.Page
+	resumed := pageToken != ""
 	retryCount := 0
 	maxRetryCount := 3
 	retryBackoffSecs := 5
 
+	query := fmt.Sprintf("trashed = false and mimeType != '%s'", driveFolderMimeType)
+	if !lastSync.IsZero() {
+		query += " and modifiedTime > '" + lastSync.Format(time.RFC3339) + "'"
+	}
+	corpora := "user"
+	if g.settings.IncludeSharedDrives {
+		corpora = "allDrives"
+	}
+
 	for {
 		q := service.Files.List().
-			PageSize(10).
-			Fields("nextPageToken, files(id, name, webViewLink, createdTime, modifiedTime, mimeType)").
+			PageSize(drivePageSize).
+			Corpora(corpora).
+			SupportsAllDrives(true).
+			IncludeItemsFromAllDrives(g.settings.IncludeSharedDrives).
+			Q(query).
+			Fields(googleapi.Field(fmt.Sprintf("nextPageToken, files(%s)", driveFileFields))).
 			OrderBy("modifiedTime desc").Context(ctx)
-		if !lastSync.IsZero() {
-			q = q.Q("modifiedTime > '" + lastSync.Format(time.RFC3339) + "'")
-		}
 		if pageToken != "" {
 			q = q.PageToken(pageToken)
 		}
 
 		r, err := q.Do()
+		if err != nil && resumed && isErrInvalidPageToken(err) {
+			log.Printf("Checkpointed page token was rejected, listing files from the start")
+			pageToken = ""
+			resumed = false
+			continue
+		}
 		if err != nil {
 			retryCount += 1
 			if retryCount < maxRetryCount {
@@ -281,22 +601,22 @@ func (g *GoogleDriveConnector) listFiles(ctx context.Context, service *drive.Ser
 			return fmt.Errorf("unable to retrieve files: %v", err)
 		}
 		retryCount = 0 // Reset retry count after a successful operation
+		resumed = false
 
-		// Max parallelism is number of files per page (10)
-		wg := sync.WaitGroup{}
+		files := []*drive.File{}
 		for _, file := range r.Files {
-			wg.Add(1)
-			go func(f *drive.File) {
-				defer wg.Done()
-				g.processFile(ctx, service, f, chunkChan)
-			}(file)
+			if g.isSelected(ctx, service, file) {
+				files = append(files, file)
+			}
 		}
-		wg.Wait()
+		g.processFiles(ctx, service, files, chunkChan)
 
 		pageToken = r.NextPageToken
 		if pageToken == "" {
 			break
 		}
+		cp.Page = pageToken
+		saveListingCheckpoint(*cp, chunkChan)
 	}
 	return nil
 }
@@ -338,7 +658,7 @@ func downloadFile(service *drive.Service, fileId string) (string, error) {
 	var err error
 
 	for retry := 0; retry < maxRetries; retry++ {
-		resp, err = service.Files.Get(fileId).Download()
+		resp, err = service.Files.Get(fileId).SupportsAllDrives(true).Download()
 		if err == nil {
 			break
 		}
@@ -390,12 +710,10 @@ func calculateBackoff(retry int) time.Duration {
 }
 
 func createTempFilePath(fileId string) (string, error) {
-	homeDir, err := os.UserHomeDir()
+	tempDir, err := util.DataPath("tmp")
 	if err != nil {
-		return "", fmt.Errorf("failed to get user home directory: %v", err)
+		return "", err
 	}
-
-	tempDir := filepath.Join(homeDir, ".verbis", "tmp")
 	if err = os.MkdirAll(tempDir, os.ModePerm); err != nil {
 		return "", fmt.Errorf("failed to create temporary directory: %v", err)
 	}
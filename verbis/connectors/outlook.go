@@ -2,39 +2,75 @@ package connectors
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	msalcache "github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
 	msal "github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
 	abstractions "github.com/microsoft/kiota-abstractions-go"
 	msgraph "github.com/microsoftgraph/msgraph-sdk-go"
 	graphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
 	msusers "github.com/microsoftgraph/msgraph-sdk-go/users"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/microsoft"
 
+	"github.com/verbis-ai/verbis/verbis/chunker"
 	"github.com/verbis-ai/verbis/verbis/keychain"
 	"github.com/verbis-ai/verbis/verbis/types"
 )
 
 func NewOutlookConnector(creds types.BuildCredentials, st types.Store) types.Connector {
-	return &OutlookConnector{
+	c := &OutlookConnector{
 		BaseConnector: BaseConnector{
 			connectorType: types.ConnectorTypeOutlook,
 			store:         st,
 		},
 		secretValue: creds.AzureSecretValue,
 		secretID:    creds.AzureSecretID,
+		settings:    DefaultOutlookSettings,
 	}
+	c.userSettings = &c.settings
+	return c
 }
 
 type OutlookConnector struct {
 	BaseConnector
 	secretValue string
 	secretID    string
+	settings    OutlookSettings
+}
+
+// OutlookSettings hold the user configuration of an Outlook connector
+type OutlookSettings struct {
+	// Folders lists the mail folders whose emails are indexed, by display
+	// name, ID or well-known name such as inbox or archive
+	Folders []string `json:"folders" title:"Folders" description:"Mail folders whose emails are indexed, by display name or ID"`
+	// IncludeSent also indexes the emails sent by the user
+	IncludeSent bool             `json:"include_sent" title:"Include sent emails" description:"Also index the emails you sent"`
+	Chunking    chunker.Settings `json:"chunking" title:"Chunking"`
+}
+
+var DefaultOutlookSettings = OutlookSettings{
+	Folders:  []string{"inbox"},
+	Chunking: chunker.DefaultSettings,
+}
+
+func (s *OutlookSettings) Validate() error {
+	if len(s.Folders) == 0 && !s.IncludeSent {
+		return fmt.Errorf("at least one folder must be selected")
+	}
+	return s.Chunking.Validate()
+}
+
+func (s *OutlookSettings) ChunkSettings() chunker.Settings {
+	return s.Chunking
 }
 
 type OAuthAuthenticationProvider struct {
@@ -51,14 +87,22 @@ func (a *OAuthAuthenticationProvider) AuthenticateRequest(ctx context.Context, r
 	return nil
 }
 
-func (o *OutlookConnector) getClient(ctx context.Context, config *oauth2.Config) (*msgraph.GraphServiceClient, error) {
-	// Token from Keychain
+func (o *OutlookConnector) getClient(ctx context.Context) (*msgraph.GraphServiceClient, error) {
 	tok, err := keychain.TokenFromKeychain(o.ID(), o.Type())
 	if err != nil {
 		return nil, err
 	}
-
-	tokenSource := config.TokenSource(ctx, tok)
+	tokenCache := &msalCache{data: []byte(tok.RefreshToken)}
+	clientApp, err := o.msalClient(tokenCache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client app: %v", err)
+	}
+	tokenSource := o.persistingTokenSource(tok, &msalTokenSource{
+		ctx:    ctx,
+		client: clientApp,
+		cache:  tokenCache,
+		token:  tok,
+	})
 	authProvider := &OAuthAuthenticationProvider{TokenSource: tokenSource}
 	adapter, err := msgraph.NewGraphRequestAdapter(authProvider)
 	if err != nil {
@@ -69,13 +113,13 @@ func (o *OutlookConnector) getClient(ctx context.Context, config *oauth2.Config)
 	return graphClient, nil
 }
 
-func (o *OutlookConnector) requestOauthWeb(config *oauth2.Config) error {
+func (o *OutlookConnector) requestOauthWeb(config *oauth2.Config) (string, error) {
 	log.Printf("Requesting token from web with redirectURL: %v", config.RedirectURL)
-	authURL := config.AuthCodeURL(o.ID(), oauth2.AccessTypeOffline)
-	fmt.Printf("Your browser has been opened to visit:\n%v\n", authURL)
-
-	// Open URL in the default browser
-	return exec.Command("open", authURL).Start()
+	session, err := NewAuthSession(o.ID(), true)
+	if err != nil {
+		return "", err
+	}
+	return session.AuthCodeURL(config), nil
 }
 
 var outlookScopes = []string{
@@ -87,55 +131,121 @@ var outlookScopes = []string{
 
 var outlookScopesPlusOffline = append(outlookScopes, "offline_access")
 
-func (o *OutlookConnector) AuthSetup(ctx context.Context) error {
+func (o *OutlookConnector) AuthSetup(ctx context.Context) (string, error) {
 	config, err := o.outlookConfig()
 	if err != nil {
-		return fmt.Errorf("unable to get outlook config: %s", err)
+		return "", fmt.Errorf("unable to get outlook config: %s", err)
 	}
 	_, err = keychain.TokenFromKeychain(o.ID(), o.Type())
 	if err == nil {
 		// TODO: check for expiry of refresh token
 		log.Print("Token found in keychain.")
-		return nil
+		return "", nil
 	}
 	log.Print("No token found in keychain. Getting token from web.")
-	err = o.requestOauthWeb(config)
+	authURL, err := o.requestOauthWeb(config)
 	if err != nil {
-		log.Printf("Unable to request token from web: %v", err)
+		return "", fmt.Errorf("unable to request token from web: %v", err)
 	}
-	return nil
+	return authURL, nil
 }
 
 func (o *OutlookConnector) outlookConfig() (*oauth2.Config, error) {
 	return &oauth2.Config{
 		ClientID:     o.secretID,
 		ClientSecret: o.secretValue,
-		RedirectURL:  fmt.Sprintf("http://127.0.0.1:8081/connectors/%s/callback", o.Type()),
+		RedirectURL:  callbackURL(string(o.Type())),
 		Scopes:       outlookScopesPlusOffline,
 		Endpoint:     microsoft.AzureADEndpoint("common"),
 	}, nil
 }
 
-// TODO: handle token expiries
-func (o *OutlookConnector) AuthCallback(ctx context.Context, authCode string) error {
-	config, err := o.outlookConfig()
+func (o *OutlookConnector) msalClient(tokenCache *msalCache) (msal.Client, error) {
+	return msal.New(o.secretID, msal.WithAuthority("https://login.microsoftonline.com/common"), msal.WithCache(tokenCache))
+}
+
+// msalCache holds the token cache of MSAL, which includes the refresh token
+type msalCache struct {
+	data []byte
+}
+
+func (c *msalCache) Replace(ctx context.Context, cache msalcache.Unmarshaler, hints msalcache.ReplaceHints) error {
+	if len(c.data) == 0 {
+		return nil
+	}
+	return cache.Unmarshal(c.data)
+}
+
+func (c *msalCache) Export(ctx context.Context, cache msalcache.Marshaler, hints msalcache.ExportHints) error {
+	data, err := cache.Marshal()
+	if err != nil {
+		return err
+	}
+	c.data = data
+	return nil
+}
+
+// msalTokenSource refreshes Outlook tokens with MSAL. The token cache of
+// MSAL is kept as the refresh token of the oauth2.Token, and saved along
+// with it by persistingTokenSource.
+type msalTokenSource struct {
+	ctx    context.Context
+	client msal.Client
+	cache  *msalCache
+	token  *oauth2.Token
+}
+
+func (s *msalTokenSource) Token() (*oauth2.Token, error) {
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	if len(s.cache.data) == 0 {
+		// Saved by an older version, without the cache
+		return nil, errNoRefreshToken
+	}
+	accounts, err := s.client.Accounts(s.ctx)
 	if err != nil {
-		return fmt.Errorf("unable to get outlook config: %s", err)
+		return nil, fmt.Errorf("unable to get accounts from token cache: %v", err)
 	}
+	if len(accounts) == 0 {
+		return nil, errNoRefreshToken
+	}
+	result, err := s.client.AcquireTokenSilent(s.ctx, outlookScopes, msal.WithSilentAccount(accounts[0]))
+	if err != nil {
+		return nil, err
+	}
+	s.token = &oauth2.Token{
+		AccessToken:  result.AccessToken,
+		Expiry:       result.ExpiresOn,
+		RefreshToken: string(s.cache.data),
+	}
+	return s.token, nil
+}
 
-	clientApp, err := msal.New(o.secretID, msal.WithAuthority("https://login.microsoftonline.com/common"))
+func (o *OutlookConnector) AuthCallback(ctx context.Context, authCode string, verifier string) error {
+	tokenCache := &msalCache{}
+	clientApp, err := o.msalClient(tokenCache)
 	if err != nil {
 		return fmt.Errorf("failed to create client app: %v", err)
 	}
 
 	// MSAL automatically adds the offline_access scope
-	result, err := clientApp.AcquireTokenByAuthCode(ctx, authCode, "http://127.0.0.1:8081/connectors/outlook/callback", outlookScopes)
+	opts := []msal.AcquireByAuthCodeOption{}
+	if verifier != "" {
+		// Despite its name, MSAL sends the challenge as the code verifier
+		opts = append(opts, msal.WithChallenge(verifier))
+	}
+	result, err := clientApp.AcquireTokenByAuthCode(ctx, authCode, callbackURL(string(o.Type())), outlookScopes, opts...)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve token from web: %v", err)
 	}
 
+	// MSAL keeps the refresh token in its cache, which is stored in place
+	// of the refresh token, see msalTokenSource
 	tok := &oauth2.Token{
-		AccessToken: result.AccessToken,
+		AccessToken:  result.AccessToken,
+		Expiry:       result.ExpiresOn,
+		RefreshToken: string(tokenCache.data),
 	}
 
 	err = keychain.SaveTokenToKeychain(tok, o.ID(), o.Type())
@@ -143,7 +253,7 @@ func (o *OutlookConnector) AuthCallback(ctx context.Context, authCode string) er
 		return fmt.Errorf("unable to save token to keychain: %v", err)
 	}
 
-	client, err := o.getClient(ctx, config)
+	client, err := o.getClient(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to get client: %v", err)
 	}
@@ -181,31 +291,180 @@ func getOutlookUserEmail(ctx context.Context, client *msgraph.GraphServiceClient
 	return *email, nil
 }
 
-func (o *OutlookConnector) Sync(lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+func (o *OutlookConnector) Sync(ctx context.Context, lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
 	defer close(chunkChan)
-	if err := o.context.Err(); err != nil {
+	if err := ctx.Err(); err != nil {
 		errChan <- fmt.Errorf("context error: %s", err)
 		return
 	}
 
 	log.Printf("Starting outlook sync")
-	config, err := o.outlookConfig()
+	graphClient, err := o.getClient(ctx)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to get outlook config: %s", err)
+		errChan <- fmt.Errorf("unable to get client: %v", err)
 		return
 	}
 
-	graphClient, err := o.getClient(o.context, config)
+	folderIDs, err := o.selectedFolders(ctx, graphClient)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to get client: %v", err)
+		errChan <- fmt.Errorf("unable to get mail folders: %v", err)
+		return
+	}
+
+	state, err := o.Status(ctx)
+	if err != nil {
+		errChan <- fmt.Errorf("unable to get connector state: %v", err)
 		return
 	}
 
-	err = o.listEmails(o.context, graphClient, lastSync, chunkChan)
+	// Resume from the folder the last sync was interrupted in, unless it is
+	// no longer selected
+	cp := loadListingCheckpoint(state, lastSync)
+	if cp != nil && slices.Contains(folderIDs, cp.Source) {
+		folderIDs = folderIDs[slices.Index(folderIDs, cp.Source):]
+	} else {
+		cp = &listingCheckpoint{Since: lastSync}
+	}
+
+	for _, folderID := range folderIDs {
+		pageLink := ""
+		if folderID == cp.Source {
+			pageLink = cp.Page
+		}
+		err = o.listEmails(ctx, graphClient, folderID, lastSync, pageLink, chunkChan)
+		if err != nil {
+			errChan <- fmt.Errorf("unable to list emails in folder %s: %v", folderID, err)
+			return
+		}
+	}
+	clearListingCheckpoint(chunkChan)
+}
+
+// outlookMessageFields are the message properties indexed
+var outlookMessageFields = []string{"id", "subject", "receivedDateTime", "body", "sender", "from", "toRecipients", "ccRecipients", "conversationId"}
+
+// RetryItems fetches and indexes again the emails with the given IDs
+func (o *OutlookConnector) RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	defer close(chunkChan)
+	graphClient, err := o.getClient(ctx)
 	if err != nil {
-		errChan <- fmt.Errorf("unable to list emails: %v", err)
+		errChan <- fmt.Errorf("unable to get client: %v", err)
 		return
 	}
+
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("Prefer", "outlook.body-content-type=\"text\"")
+	requestConfig := &msusers.ItemMessagesMessageItemRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+		QueryParameters: &msusers.ItemMessagesMessageItemRequestBuilderGetQueryParameters{
+			Select: outlookMessageFields,
+		},
+	}
+	for _, messageID := range uniqueIDs {
+		message, err := graphClient.Me().Messages().ByMessageId(messageID).Get(ctx, requestConfig)
+		var odataErr *odataerrors.ODataError
+		if errors.As(err, &odataErr) && odataErr.ResponseStatusCode == http.StatusNotFound {
+			chunkChan <- types.ChunkSyncResult{Tombstone: messageID}
+			continue
+		}
+		if err != nil {
+			chunkChan <- itemError(
+				types.Document{UniqueID: messageID},
+				types.SyncStageFetch,
+				fmt.Errorf("unable to get email %s: %v", messageID, err),
+			)
+			continue
+		}
+		o.processEmail(ctx, message, chunkChan)
+	}
+}
+
+// selectedFolders resolves the folders selected in the connector settings to
+// folder IDs. Folders may be given by display name, by ID, or by well-known
+// name such as inbox or archive.
+func (o *OutlookConnector) selectedFolders(ctx context.Context, client *msgraph.GraphServiceClient) ([]string, error) {
+	selected := append([]string{}, o.settings.Folders...)
+	if o.settings.IncludeSent {
+		selected = append(selected, "sentitems")
+	}
+
+	result, err := client.Me().MailFolders().Get(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	displayNames := map[string]string{}
+	pageIterator, err := graphcore.NewPageIterator[*models.MailFolder](
+		result,
+		client.GetAdapter(),
+		models.CreateMailFolderCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create page iterator: %v", err)
+	}
+	err = pageIterator.Iterate(ctx, func(folder *models.MailFolder) bool {
+		if folder.GetId() != nil && folder.GetDisplayName() != nil {
+			displayNames[strings.ToLower(*folder.GetDisplayName())] = *folder.GetId()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to iterate over mail folders: %v", err)
+	}
+
+	res := []string{}
+	for _, folder := range selected {
+		if id, ok := displayNames[strings.ToLower(folder)]; ok {
+			folder = id
+		}
+		res = append(res, folder)
+	}
+	return res, nil
+}
+
+// ListInventory lists the IDs of all emails that are still in the selected
+// folders
+func (o *OutlookConnector) ListInventory(ctx context.Context) ([]string, error) {
+	client, err := o.getClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get client: %v", err)
+	}
+	folderIDs, err := o.selectedFolders(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get mail folders: %v", err)
+	}
+
+	ids := []string{}
+	for _, folderID := range folderIDs {
+		var top int32 = 1000
+		requestConfig := &msusers.ItemMailfoldersItemMessagesRequestBuilderGetRequestConfiguration{
+			QueryParameters: &msusers.ItemMailfoldersItemMessagesRequestBuilderGetQueryParameters{
+				Select: []string{"id"},
+				Top:    &top,
+			},
+		}
+		result, err := client.Me().MailFolders().ByMailFolderId(folderID).Messages().Get(ctx, requestConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list emails: %v", err)
+		}
+
+		pageIterator, err := graphcore.NewPageIterator[*models.Message](
+			result,
+			client.GetAdapter(),
+			models.CreateMessageCollectionResponseFromDiscriminatorValue)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create page iterator: %v", err)
+		}
+
+		err = pageIterator.Iterate(ctx, func(message *models.Message) bool {
+			if id := message.GetId(); id != nil {
+				ids = append(ids, *id)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to iterate over emails: %v", err)
+		}
+	}
+	return ids, nil
 }
 
 func (o *OutlookConnector) processEmail(ctx context.Context, email models.Messageable, chunkChan chan types.ChunkSyncResult) {
@@ -231,16 +490,24 @@ func (o *OutlookConnector) processEmail(ctx context.Context, email models.Messag
 		email_subject = *email_subject_ptr
 	}
 
+	metadata := map[string]string{
+		types.DocumentMetadataFrom: formatRecipient(email.GetFrom()),
+		types.DocumentMetadataTo:   formatRecipients(email.GetToRecipients()),
+		types.DocumentMetadataCc:   formatRecipients(email.GetCcRecipients()),
+	}
+	if conversationID := email.GetConversationId(); conversationID != nil {
+		metadata[types.DocumentMetadataThreadID] = *conversationID
+	}
+
 	document := types.Document{
-		UniqueID: email_id,
-		Name:     email_subject,
-		// message.GetFrom().GetEmailAddress().GetName())
-		// *message.GetReceivedDateTime()).In(location))
+		UniqueID:      email_id,
+		Name:          email_subject,
 		SourceURL:     emailURL,
 		ConnectorID:   o.ID(),
 		ConnectorType: string(o.Type()),
 		CreatedAt:     receivedAt,
 		UpdatedAt:     receivedAt,
+		Metadata:      metadata,
 	}
 
 	err := o.store.DeleteDocumentChunks(ctx, document.UniqueID, o.ID())
@@ -250,50 +517,96 @@ func (o *OutlookConnector) processEmail(ctx context.Context, email models.Messag
 
 	log.Printf("Processing email of size %d: title: %s", len(content), document.Name)
 
-	emitChunks(email_subject, content, document, chunkChan)
+	o.emitChunks(content, "text/plain", document, chunkChan)
 }
 
-func (o *OutlookConnector) listEmails(ctx context.Context, client *msgraph.GraphServiceClient, lastSync time.Time, chunkChan chan types.ChunkSyncResult) error {
+// formatRecipient formats an email address as "Name <address>"
+func formatRecipient(recipient models.Recipientable) string {
+	if recipient == nil || recipient.GetEmailAddress() == nil {
+		return ""
+	}
+	address := recipient.GetEmailAddress()
+	name, email := "", ""
+	if address.GetName() != nil {
+		name = *address.GetName()
+	}
+	if address.GetAddress() != nil {
+		email = *address.GetAddress()
+	}
+	if name == "" || name == email {
+		return email
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
+
+func formatRecipients(recipients []models.Recipientable) string {
+	res := []string{}
+	for _, recipient := range recipients {
+		if formatted := formatRecipient(recipient); formatted != "" {
+			res = append(res, formatted)
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+func (o *OutlookConnector) listEmails(ctx context.Context, client *msgraph.GraphServiceClient, folderID string, lastSync time.Time, pageLink string, chunkChan chan types.ChunkSyncResult) error {
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("Prefer", "outlook.body-content-type=\"text\"")
 
 	filter := fmt.Sprintf("receivedDateTime ge %s", lastSync.Format(time.RFC3339))
-	var top int32 = 10
+	var top int32 = 50
 	requestConfig := &msusers.ItemMailfoldersItemMessagesRequestBuilderGetRequestConfiguration{
 		Headers: headers,
 		QueryParameters: &msusers.ItemMailfoldersItemMessagesRequestBuilderGetQueryParameters{
-			Select:  []string{"id", "subject", "receivedDateTime", "body", "sender"},
+			Select:  outlookMessageFields,
 			Filter:  &filter,
 			Top:     &top,
 			Orderby: []string{"receivedDateTime DESC"},
 		},
 	}
-
-	result, err := client.Me().MailFolders().ByMailFolderId("inbox").Messages().Get(ctx, requestConfig)
-	if err != nil {
-		return fmt.Errorf("unable to list emails: %v", err)
+	// Next page links already carry the query parameters
+	nextPageConfig := &msusers.ItemMailfoldersItemMessagesRequestBuilderGetRequestConfiguration{
+		Headers: headers,
 	}
 
-	pageIterator, err := graphcore.NewPageIterator[*models.Message](
-		result,
-		client.GetAdapter(),
-		models.CreateMessageCollectionResponseFromDiscriminatorValue)
-	if err != nil {
-		return fmt.Errorf("unable to create page iterator: %v", err)
+	messages := client.Me().MailFolders().ByMailFolderId(folderID).Messages()
+	var result models.MessageCollectionResponseable
+	var err error
+	if pageLink != "" {
+		result, err = messages.WithUrl(pageLink).Get(ctx, nextPageConfig)
+		if err != nil {
+			log.Printf("Unable to resume listing folder %s from checkpoint, listing it from the start: %v", folderID, err)
+		}
+	}
+	if result == nil {
+		result, err = messages.Get(ctx, requestConfig)
+		if err != nil {
+			return fmt.Errorf("unable to list emails: %v", err)
+		}
 	}
-	pageIterator.SetHeaders(headers)
 
-	err = pageIterator.Iterate(
-		ctx,
-		func(message *models.Message) bool {
+	for {
+		for _, message := range result.GetValue() {
 			// TODO: process many in parallel
 			o.processEmail(ctx, message, chunkChan)
-			// Return true to continue the iteration
-			return true
-		})
-	if err != nil {
-		return fmt.Errorf("unable to iterate over emails: %v", err)
+		}
+
+		next := result.GetOdataNextLink()
+		if next == nil || *next == "" {
+			return nil
+		}
+		saveListingCheckpoint(listingCheckpoint{
+			Since:  lastSync,
+			Source: folderID,
+			Page:   *next,
+		}, chunkChan)
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result, err = messages.WithUrl(*next).Get(ctx, nextPageConfig)
+		if err != nil {
+			return fmt.Errorf("unable to list emails: %v", err)
+		}
 	}
-
-	return nil
 }
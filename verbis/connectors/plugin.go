@@ -0,0 +1,428 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/verbis-ai/verbis/verbis/chunker"
+	"github.com/verbis-ai/verbis/verbis/keychain"
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// PluginsDir holds the plugin executables, relative to the data dir. See
+// doc/PLUGINS.md for the protocol.
+const PluginsDir = "plugins"
+
+var pluginNameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// RegisterPlugins registers every executable in dir as a connector type named
+// after the file, without its extension. Built-in connector types cannot be
+// overridden. The stderr of each plugin goes to its own log in logDir.
+func RegisterPlugins(dir string, logDir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read plugins directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if !pluginNameRegex.MatchString(name) {
+			log.Printf("Skipping plugin %s: name must only contain lowercase letters, digits, - and _", path)
+			continue
+		}
+		logPath := filepath.Join(logDir, "plugin-"+name+".log")
+		if !registerConnector(name, pluginConstructor(path, logPath, types.ConnectorType(name))) {
+			log.Printf("Skipping plugin %s: connector type %s already exists", path, name)
+			continue
+		}
+		log.Printf("Registered plugin connector %s from %s", name, path)
+	}
+	return nil
+}
+
+func pluginConstructor(path, logPath string, connectorType types.ConnectorType) types.ConnectorConstructor {
+	return func(creds types.BuildCredentials, st types.Store) types.Connector {
+		c := &PluginConnector{
+			BaseConnector: BaseConnector{
+				connectorType: connectorType,
+				store:         st,
+			},
+			path:     path,
+			logPath:  logPath,
+			settings: PluginSettings{},
+		}
+		c.userSettings = &c.settings
+		return c
+	}
+}
+
+// PluginConnector runs a connector implemented by an external executable. A
+// process is started for each connector, and restarted if it exits.
+type PluginConnector struct {
+	BaseConnector
+	path string
+	// logPath is the log receiving the stderr of the plugin
+	logPath  string
+	settings PluginSettings
+	// schema describes the settings of the plugin, as returned by init
+	schema map[string]interface{}
+
+	procLock sync.Mutex
+	proc     *pluginProcess
+}
+
+// PluginSettings are free-form, and described by the schema that the plugin
+// returns from init. The optional chunking key holds chunker.Settings.
+type PluginSettings map[string]interface{}
+
+func (s *PluginSettings) ChunkSettings() chunker.Settings {
+	settings := chunker.DefaultSettings
+	if chunking, ok := (*s)["chunking"]; ok {
+		b, _ := json.Marshal(chunking)
+		json.Unmarshal(b, &settings)
+	}
+	return settings
+}
+
+func (s *PluginSettings) Validate() error {
+	if _, ok := (*s)["chunking"]; ok {
+		return s.ChunkSettings().Validate()
+	}
+	return nil
+}
+
+type pluginInitParams struct {
+	ConnectorID string `json:"connector_id"`
+}
+
+type pluginInitResult struct {
+	SettingsSchema  map[string]interface{} `json:"settings_schema"`
+	DefaultSettings map[string]interface{} `json:"default_settings"`
+}
+
+type pluginAuthParams struct {
+	ConnectorID string `json:"connector_id"`
+	RedirectURL string `json:"redirect_url"`
+	Code        string `json:"code,omitempty"`
+	// State must be passed as the OAuth state, and CodeVerifier may be used
+	// for PKCE
+	State        string `json:"state,omitempty"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
+}
+
+// pluginAuthResult either asks the user to visit AuthURL, or completes the
+// authentication with a token to store for the connector
+type pluginAuthResult struct {
+	AuthURL string        `json:"auth_url"`
+	Token   *oauth2.Token `json:"token"`
+	User    string        `json:"user"`
+}
+
+type pluginSyncParams struct {
+	ConnectorID string            `json:"connector_id"`
+	LastSync    time.Time         `json:"last_sync"`
+	Cursors     map[string]string `json:"cursors"`
+	Settings    PluginSettings    `json:"settings"`
+	Token       *oauth2.Token     `json:"token"`
+	// UniqueIDs lists the documents to sync again, for retry_items
+	UniqueIDs []string `json:"unique_ids,omitempty"`
+}
+
+type pluginSyncResult struct {
+	// Token, if set, replaces the stored token, e.g. after a refresh
+	Token *oauth2.Token `json:"token"`
+}
+
+type pluginDocument struct {
+	Document types.Document `json:"document"`
+	Content  string         `json:"content"`
+	MimeType string         `json:"mime_type"`
+}
+
+// process returns the running plugin process, starting it if needed
+func (p *PluginConnector) process() (*pluginProcess, error) {
+	p.procLock.Lock()
+	defer p.procLock.Unlock()
+	if p.proc != nil && !p.proc.exited() {
+		return p.proc, nil
+	}
+	proc, err := startPluginProcess(p.path, p.logPath)
+	if err != nil {
+		return nil, err
+	}
+	p.proc = proc
+	return proc, nil
+}
+
+func (p *PluginConnector) call(ctx context.Context, method string, params interface{}, result interface{}, notify func(string, json.RawMessage)) error {
+	proc, err := p.process()
+	if err != nil {
+		return err
+	}
+	return proc.call(ctx, method, params, result, notify)
+}
+
+func (p *PluginConnector) Init(ctx context.Context, connectorID string) error {
+	if connectorID != "" {
+		p.id = connectorID
+	}
+	if p.id == "" {
+		p.id = uuid.New().String()
+	}
+
+	var res pluginInitResult
+	err := p.call(ctx, "init", pluginInitParams{ConnectorID: p.ID()}, &res, nil)
+	if err != nil && connectorID == "" {
+		return fmt.Errorf("unable to init plugin: %v", err)
+	}
+	if err != nil {
+		// Keep the connector and its data around, the plugin is started
+		// again on the next sync
+		log.Printf("Unable to init plugin %s for connector %s: %v", p.path, p.ID(), err)
+	}
+	p.schema = res.SettingsSchema
+	if res.DefaultSettings != nil {
+		p.settings = res.DefaultSettings
+	}
+
+	return p.BaseConnector.Init(ctx, connectorID)
+}
+
+func (p *PluginConnector) Cancel() {
+	p.BaseConnector.Cancel()
+	p.procLock.Lock()
+	defer p.procLock.Unlock()
+	if p.proc != nil {
+		p.proc.stop()
+	}
+}
+
+func (p *PluginConnector) SettingsSchema() map[string]interface{} {
+	if p.schema == nil {
+		return p.BaseConnector.SettingsSchema()
+	}
+	return p.schema
+}
+
+// UpdateSettings lets the plugin validate the settings before storing them.
+// Plugins that do not implement validate_settings accept any settings.
+func (p *PluginConnector) UpdateSettings(ctx context.Context, data []byte) error {
+	p.settingsLock.RLock()
+	settings, err := decodeSettings(&p.settings, data)
+	p.settingsLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	err = p.call(ctx, "validate_settings", map[string]interface{}{"settings": settings}, nil, nil)
+	if err != nil && !isErrMethodNotFound(err) {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	return p.BaseConnector.UpdateSettings(ctx, data)
+}
+
+func (p *PluginConnector) redirectURL() string {
+	return callbackURL(p.ID())
+}
+
+func (p *PluginConnector) AuthSetup(ctx context.Context) (string, error) {
+	session, err := NewAuthSession(p.ID(), true)
+	if err != nil {
+		return "", err
+	}
+	var res pluginAuthResult
+	err = p.call(ctx, "auth_setup", pluginAuthParams{
+		ConnectorID:  p.ID(),
+		RedirectURL:  p.redirectURL(),
+		State:        session.State,
+		CodeVerifier: session.Verifier,
+	}, &res, nil)
+	if err != nil {
+		ConsumeAuthSession(session.State)
+		return "", fmt.Errorf("unable to set up plugin auth: %v", err)
+	}
+	if res.AuthURL != "" {
+		return res.AuthURL, nil
+	}
+	// No callback is expected
+	ConsumeAuthSession(session.State)
+	return "", p.completeAuth(ctx, res)
+}
+
+func (p *PluginConnector) AuthCallback(ctx context.Context, authCode string, verifier string) error {
+	var res pluginAuthResult
+	err := p.call(ctx, "auth_callback", pluginAuthParams{
+		ConnectorID:  p.ID(),
+		RedirectURL:  p.redirectURL(),
+		Code:         authCode,
+		CodeVerifier: verifier,
+	}, &res, nil)
+	if err != nil {
+		return fmt.Errorf("unable to complete plugin auth: %v", err)
+	}
+	return p.completeAuth(ctx, res)
+}
+
+func (p *PluginConnector) completeAuth(ctx context.Context, res pluginAuthResult) error {
+	if res.Token == nil {
+		return fmt.Errorf("plugin returned neither an auth URL nor a token")
+	}
+	err := keychain.SaveTokenToKeychain(res.Token, p.ID(), p.Type())
+	if err != nil {
+		return fmt.Errorf("unable to save token to keychain: %v", err)
+	}
+	p.user = res.User
+
+	state, err := p.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get connector state: %v", err)
+	}
+	state.User = p.user
+	err = p.UpdateConnectorState(ctx, state)
+	if err != nil {
+		return err
+	}
+	return p.store.SetConnectorAuth(ctx, p.ID(), true, "")
+}
+
+func (p *PluginConnector) Sync(ctx context.Context, lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	defer close(chunkChan)
+	err := p.callSync(ctx, "sync", lastSync, nil, chunkChan)
+	if err != nil {
+		errChan <- err
+	}
+}
+
+// RetryItems asks the plugin to sync the given documents again. Plugins that
+// do not implement retry_items fail the retry.
+func (p *PluginConnector) RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	defer close(chunkChan)
+	err := p.callSync(ctx, "retry_items", time.Time{}, uniqueIDs, chunkChan)
+	if err != nil {
+		errChan <- err
+	}
+}
+
+// callSync calls a sync method of the plugin, and turns its notifications
+// into sync results
+func (p *PluginConnector) callSync(ctx context.Context, method string, lastSync time.Time, uniqueIDs []string, chunkChan chan types.ChunkSyncResult) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("context error: %s", err)
+	}
+
+	state, err := p.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get connector state: %v", err)
+	}
+	token, err := keychain.TokenFromKeychain(p.ID(), p.Type())
+	if err != nil {
+		return fmt.Errorf("unable to get token from keychain: %v", err)
+	}
+
+	var res pluginSyncResult
+	err = p.call(ctx, method, pluginSyncParams{
+		ConnectorID: p.ID(),
+		LastSync:    lastSync,
+		Cursors:     state.SyncCursors,
+		Settings:    p.settings,
+		Token:       token,
+		UniqueIDs:   uniqueIDs,
+	}, &res, func(method string, params json.RawMessage) {
+		p.handleSyncNotification(ctx, method, params, chunkChan)
+	})
+	if isErrMethodNotFound(err) {
+		return fmt.Errorf("plugin does not implement %s", method)
+	}
+	if reason, ok := authInvalidReason(err); ok {
+		p.invalidateAuth(reason)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s failed: %v", method, err)
+	}
+
+	if res.Token != nil {
+		err = keychain.SaveTokenToKeychain(res.Token, p.ID(), p.Type())
+		if err != nil {
+			return fmt.Errorf("unable to save token to keychain: %v", err)
+		}
+	}
+	return nil
+}
+
+// handleSyncNotification turns the notifications sent by a plugin during a
+// sync into sync results
+func (p *PluginConnector) handleSyncNotification(ctx context.Context, method string, params json.RawMessage, chunkChan chan types.ChunkSyncResult) {
+	switch method {
+	case "document":
+		var doc pluginDocument
+		err := json.Unmarshal(params, &doc)
+		if err != nil || doc.Document.UniqueID == "" {
+			chunkChan <- itemError(doc.Document, types.SyncStageParse, fmt.Errorf("invalid document from plugin: %v", err))
+			return
+		}
+		doc.Document.ConnectorID = p.ID()
+		doc.Document.ConnectorType = string(p.Type())
+		if doc.MimeType == "" {
+			doc.MimeType = "text/plain"
+		}
+
+		err = p.store.DeleteDocumentChunks(ctx, doc.Document.UniqueID, p.ID())
+		if err != nil {
+			chunkChan <- itemError(doc.Document, types.SyncStageIndex, fmt.Errorf("unable to delete chunks of document %s: %v", doc.Document.UniqueID, err))
+			return
+		}
+		p.emitChunks(doc.Content, doc.MimeType, doc.Document, chunkChan)
+	case "cursor":
+		var cursor types.SyncCursor
+		err := json.Unmarshal(params, &cursor)
+		if err != nil || cursor.Key == "" {
+			log.Printf("Ignoring invalid cursor from plugin %s: %v", p.path, err)
+			return
+		}
+		chunkChan <- types.ChunkSyncResult{Cursor: &cursor}
+	case "tombstone":
+		var tombstone struct {
+			UniqueID string `json:"unique_id"`
+		}
+		err := json.Unmarshal(params, &tombstone)
+		if err != nil || tombstone.UniqueID == "" {
+			log.Printf("Ignoring invalid tombstone from plugin %s: %v", p.path, err)
+			return
+		}
+		chunkChan <- types.ChunkSyncResult{Tombstone: tombstone.UniqueID}
+	case "error":
+		var syncErr struct {
+			Message  string `json:"message"`
+			UniqueID string `json:"unique_id"`
+			Name     string `json:"name"`
+			Stage    string `json:"stage"`
+		}
+		json.Unmarshal(params, &syncErr)
+		chunkChan <- itemError(
+			types.Document{UniqueID: syncErr.UniqueID, Name: syncErr.Name},
+			syncErr.Stage,
+			fmt.Errorf("plugin error: %s", syncErr.Message),
+		)
+	default:
+		log.Printf("Ignoring unknown %s notification from plugin %s", method, p.path)
+	}
+}
@@ -0,0 +1,255 @@
+package connectors
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+const (
+	// JSON-RPC 2.0 error code returned by plugins that do not implement a
+	// method
+	rpcMethodNotFound = -32601
+	// Error code returned by plugins whose token is no longer valid, which
+	// requires the user to authenticate again
+	rpcAuthInvalid = -32001
+)
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+func isErrMethodNotFound(err error) bool {
+	var rpcErr *rpcError
+	return errors.As(err, &rpcErr) && rpcErr.Code == rpcMethodNotFound
+}
+
+// authInvalidReason returns the message of an auth error returned by a
+// plugin, if err is one
+func authInvalidReason(err error) (string, bool) {
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) && rpcErr.Code == rpcAuthInvalid {
+		return rpcErr.Message, true
+	}
+	return "", false
+}
+
+// pluginProcess is a running plugin executable, exchanging newline delimited
+// JSON-RPC 2.0 messages over its stdin and stdout. Its stderr goes to the
+// log of the plugin.
+type pluginProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcMessage
+	// notify handles the notifications sent by the plugin while a request is
+	// in flight, such as the documents emitted during a sync
+	notify func(method string, params json.RawMessage)
+
+	done chan struct{}
+	err  error
+}
+
+func startPluginProcess(path string, logPath string) (*pluginProcess, error) {
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open plugin log: %v", err)
+	}
+	// The process has its own handle on the file once started
+	defer logFile.Close()
+
+	cmd := exec.Command(path)
+	cmd.Stderr = logFile
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open plugin stdin: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open plugin stdout: %v", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("unable to start plugin %s: %v", path, err)
+	}
+
+	p := &pluginProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: map[int64]chan rpcMessage{},
+		done:    make(chan struct{}),
+	}
+	go p.readLoop(stdout)
+	return p, nil
+}
+
+func (p *pluginProcess) readLoop(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	var err error
+	for {
+		var line []byte
+		line, err = reader.ReadBytes('\n')
+		if len(line) > 0 {
+			p.handleLine(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	waitErr := p.cmd.Wait()
+	if err == io.EOF {
+		err = fmt.Errorf("plugin exited: %v", waitErr)
+	}
+
+	p.lock.Lock()
+	p.err = err
+	p.pending = map[int64]chan rpcMessage{}
+	p.lock.Unlock()
+	close(p.done)
+}
+
+func (p *pluginProcess) handleLine(line []byte) {
+	var msg rpcMessage
+	err := json.Unmarshal(line, &msg)
+	if err != nil {
+		log.Printf("Ignoring invalid message from plugin: %v", err)
+		return
+	}
+
+	if msg.ID == nil {
+		if msg.Method == "log" {
+			var params struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			log.Printf("Plugin %s: %s", p.cmd.Path, params.Message)
+			return
+		}
+		p.lock.Lock()
+		notify := p.notify
+		p.lock.Unlock()
+		if notify == nil {
+			log.Printf("Ignoring unexpected %s notification from plugin %s", msg.Method, p.cmd.Path)
+			return
+		}
+		// Notifications are handled in order, and before the response of the
+		// request that caused them
+		notify(msg.Method, msg.Params)
+		return
+	}
+
+	p.lock.Lock()
+	ch, ok := p.pending[*msg.ID]
+	delete(p.pending, *msg.ID)
+	p.lock.Unlock()
+	if !ok {
+		log.Printf("Ignoring response to unknown request %d from plugin %s", *msg.ID, p.cmd.Path)
+		return
+	}
+	ch <- msg
+}
+
+// call sends a request to the plugin and decodes its result into result,
+// unless nil. If notify is set, it receives the notifications sent by the
+// plugin until the response arrives. Cancelling ctx kills the plugin.
+func (p *pluginProcess) call(ctx context.Context, method string, params interface{}, result interface{}, notify func(string, json.RawMessage)) error {
+	encodedParams, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s params: %v", method, err)
+	}
+
+	ch := make(chan rpcMessage, 1)
+	p.lock.Lock()
+	if p.err != nil {
+		p.lock.Unlock()
+		return p.err
+	}
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	if notify != nil {
+		p.notify = notify
+		defer func() {
+			p.lock.Lock()
+			p.notify = nil
+			p.lock.Unlock()
+		}()
+	}
+	p.lock.Unlock()
+
+	request, err := json.Marshal(rpcMessage{
+		JSONRPC: "2.0",
+		ID:      &id,
+		Method:  method,
+		Params:  encodedParams,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to encode %s request: %v", method, err)
+	}
+	p.writeLock.Lock()
+	_, err = p.stdin.Write(append(request, '\n'))
+	p.writeLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to send %s request: %v", method, err)
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil {
+			return nil
+		}
+		err = json.Unmarshal(msg.Result, result)
+		if err != nil {
+			return fmt.Errorf("unable to decode %s result: %v", method, err)
+		}
+		return nil
+	case <-p.done:
+		return fmt.Errorf("%s request failed: %v", method, p.err)
+	case <-ctx.Done():
+		p.stop()
+		return ctx.Err()
+	}
+}
+
+func (p *pluginProcess) stop() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+}
+
+func (p *pluginProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
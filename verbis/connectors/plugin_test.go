@@ -0,0 +1,56 @@
+package connectors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+func TestRegisterPlugins(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]os.FileMode{
+		"test-plugin.py":                       0755,
+		"not_executable":                       0644,
+		"Invalid Name":                         0755,
+		string(types.ConnectorTypeGoogleDrive): 0755,
+	}
+	for name, mode := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		allConnectorsLock.Lock()
+		delete(allConnectors, "test-plugin")
+		allConnectorsLock.Unlock()
+	}()
+
+	err := RegisterPlugins(dir, t.TempDir())
+	if err != nil {
+		t.Fatalf("RegisterPlugins: %v", err)
+	}
+	constructor, ok := ConnectorConstructor("test-plugin")
+	if !ok {
+		t.Fatal("plugin was not registered")
+	}
+	if c := constructor(types.BuildCredentials{}, nil); c.Type() != "test-plugin" {
+		t.Errorf("type = %s, want test-plugin", c.Type())
+	}
+	for _, name := range []string{"not_executable", "Invalid Name"} {
+		if IsConnectorType(name) {
+			t.Errorf("%s was registered", name)
+		}
+	}
+	constructor, _ = ConnectorConstructor(string(types.ConnectorTypeGoogleDrive))
+	if _, ok := constructor(types.BuildCredentials{}, nil).(*GoogleDriveConnector); !ok {
+		t.Error("built-in connector type was overridden")
+	}
+
+	err = RegisterPlugins(filepath.Join(dir, "missing"), t.TempDir())
+	if err != nil {
+		t.Errorf("missing directory: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package connectors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/verbis-ai/verbis/verbis/chunker"
+)
+
+// Settings is implemented by the settings struct of every connector. Clients
+// render a form for it from util.JSONSchema, so its fields should carry
+// title and description tags.
+type Settings interface {
+	// Validate returns an error describing the first invalid field
+	Validate() error
+	// ChunkSettings returns the settings used to chunk documents
+	ChunkSettings() chunker.Settings
+}
+
+var (
+	ErrInvalidSettings      = errors.New("invalid settings")
+	ErrSettingsWhileSyncing = errors.New("settings cannot be changed while the connector is syncing")
+)
+
+func IsErrInvalidSettings(err error) bool {
+	return errors.Is(err, ErrInvalidSettings)
+}
+
+// decodeSettings applies JSON encoded settings on top of a copy of current,
+// and validates the result. Fields missing from data keep their current
+// value, and current is left untouched.
+func decodeSettings(current Settings, data []byte) (Settings, error) {
+	decoded, err := copySettings(current)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	err = decoded.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	return decoded, nil
+}
+
+// copySettings returns a deep copy of settings. It round trips through JSON
+// rather than copying the struct, so that the copy does not share slices
+// with settings.
+func copySettings(settings Settings) (Settings, error) {
+	base, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode settings: %v", err)
+	}
+	copied := reflect.New(reflect.TypeOf(settings).Elem()).Interface().(Settings)
+	err = json.Unmarshal(base, copied)
+	if err != nil {
+		return nil, fmt.Errorf("unable to copy settings: %v", err)
+	}
+	return copied, nil
+}
+
+// syncResetter is implemented by settings of which not every change requires
+// syncing again from scratch
+type syncResetter interface {
+	// ResetsSync tells whether moving from the previous settings to these
+	// requires clearing the last sync time, so that sources without a cursor
+	// are listed in full, and whether it requires clearing all cursors
+	ResetsSync(previous Settings) (lastSync bool, cursors bool)
+}
+
+// resetsSync tells what part of the sync position must be reset after the
+// settings changed. Unless the settings implement syncResetter, any change
+// may alter what is indexed, and the next sync starts over from a full
+// listing.
+func resetsSync(previous Settings, settings Settings) (lastSync bool, cursors bool) {
+	if reflect.DeepEqual(previous, settings) {
+		return false, false
+	}
+	resetter, ok := settings.(syncResetter)
+	if ok {
+		return resetter.ResetsSync(previous)
+	}
+	return true, true
+}
+
+// applySettings replaces the settings pointed to by dst with those pointed
+// to by src, which must be of the same type
+func applySettings(dst Settings, src Settings) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
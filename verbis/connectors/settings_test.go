@@ -0,0 +1,72 @@
+package connectors
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/verbis-ai/verbis/verbis/chunker"
+)
+
+func TestDecodeSettings(t *testing.T) {
+	current := &GmailSettings{
+		Labels:   []string{"INBOX"},
+		Chunking: chunker.DefaultSettings,
+	}
+
+	decoded, err := decodeSettings(current, []byte(`{"include_sent": true}`))
+	if err != nil {
+		t.Fatalf("decodeSettings: %v", err)
+	}
+	want := &GmailSettings{
+		Labels:      []string{"INBOX"},
+		IncludeSent: true,
+		Chunking:    chunker.DefaultSettings,
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded = %+v, want %+v", decoded, want)
+	}
+
+	decoded.(*GmailSettings).Labels[0] = "SENT"
+	if current.Labels[0] != "INBOX" || current.IncludeSent {
+		t.Errorf("current settings were modified: %+v", current)
+	}
+
+	_, err = decodeSettings(current, []byte(`{"chunking": {"max_chunk_size": 1}}`))
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("err = %v, want ErrInvalidSettings", err)
+	}
+}
+
+func TestResetsSync(t *testing.T) {
+	drive := &GoogleDriveSettings{Chunking: chunker.DefaultSettings}
+	slack := &SlackSettings{
+		ConversationTypes: []string{"public_channel"},
+		Chunking:          chunker.DefaultSettings,
+	}
+	tests := []struct {
+		name         string
+		previous     Settings
+		data         string
+		wantLastSync bool
+		wantCursors  bool
+	}{
+		{"unchanged", drive, `{}`, false, false},
+		{"drive folders", drive, `{"include_folders": ["abc"]}`, true, true},
+		{"slack unchanged", slack, `{"conversation_types": ["public_channel"]}`, false, false},
+		{"slack conversations", slack, `{"conversation_types": ["public_channel", "im"]}`, true, false},
+		{"slack files", slack, `{"index_files": true}`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := decodeSettings(tt.previous, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("decodeSettings: %v", err)
+			}
+			lastSync, cursors := resetsSync(tt.previous, settings)
+			if lastSync != tt.wantLastSync || cursors != tt.wantCursors {
+				t.Errorf("resetsSync = %v, %v, want %v, %v", lastSync, cursors, tt.wantLastSync, tt.wantCursors)
+			}
+		})
+	}
+}
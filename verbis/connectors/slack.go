@@ -2,9 +2,13 @@ package connectors
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,32 +17,91 @@ import (
 	"golang.org/x/oauth2"
 	oauthslack "golang.org/x/oauth2/slack"
 
+	"github.com/verbis-ai/verbis/verbis/chunker"
 	"github.com/verbis-ai/verbis/verbis/keychain"
 	"github.com/verbis-ai/verbis/verbis/store"
 	"github.com/verbis-ai/verbis/verbis/types"
-	"github.com/verbis-ai/verbis/verbis/util"
 )
 
 const (
 	slackRateLimitBackoff = 11 * time.Second
+	// Rate limited user lookups are given up after this many attempts, and
+	// the user ID shown instead
+	slackMaxUserLookups = 3
 )
 
 func NewSlackConnector(creds types.BuildCredentials, st types.Store) types.Connector {
-	return &SlackConnector{
+	c := &SlackConnector{
 		BaseConnector: BaseConnector{
 			connectorType: types.ConnectorTypeSlack,
 			store:         st,
 		},
 		clientID:     creds.SlackClientID,
 		clientSecret: creds.SlackClientSecret,
+		settings:     DefaultSlackSettings,
 	}
+	c.userSettings = &c.settings
+	return c
 }
 
 type SlackConnector struct {
 	BaseConnector
 	clientID      string
 	clientSecret  string
+	settings      SlackSettings
 	messageBuffer string
+	// Ordinal of the next chunk of the channel being synced
+	nextOrdinal int
+	// userNames caches the display names of user IDs
+	userNames map[string]string
+}
+
+// SlackSettings hold the user configuration of a Slack connector
+type SlackSettings struct {
+	// ConversationTypes lists the types of conversations that are indexed
+	ConversationTypes []string `json:"conversation_types" title:"Conversations" description:"Types of conversations to index" enum:"public_channel,private_channel,mpim,im"`
+	// IndexFiles indexes the files shared in conversations as documents of
+	// their own
+	IndexFiles bool             `json:"index_files" title:"Index shared files" description:"Also index the files shared in conversations"`
+	Chunking   chunker.Settings `json:"chunking" title:"Chunking"`
+}
+
+var DefaultSlackSettings = SlackSettings{
+	ConversationTypes: []string{"public_channel", "private_channel", "im"},
+	IndexFiles:        true,
+	Chunking:          chunker.DefaultSettings,
+}
+
+var slackConversationTypes = map[string]bool{
+	"public_channel":  true,
+	"private_channel": true,
+	"mpim":            true,
+	"im":              true,
+}
+
+func (s *SlackSettings) Validate() error {
+	if len(s.ConversationTypes) == 0 {
+		return fmt.Errorf("at least one conversation type must be selected")
+	}
+	for _, t := range s.ConversationTypes {
+		if !slackConversationTypes[t] {
+			return fmt.Errorf("unknown conversation type %s", t)
+		}
+	}
+	return s.Chunking.Validate()
+}
+
+// ResetsSync keeps the cursors of the channels already synced, as syncing
+// them again would index their messages twice. Channels that are newly
+// included are synced from the start. Shared files and chunking settings
+// only apply to the messages synced from then on.
+func (s *SlackSettings) ResetsSync(previous Settings) (bool, bool) {
+	prev := previous.(*SlackSettings)
+	return !slices.Equal(prev.ConversationTypes, s.ConversationTypes), false
+}
+
+func (s *SlackSettings) ChunkSettings() chunker.Settings {
+	return s.Chunking
 }
 
 func (s *SlackConnector) getClient() (*slack.Client, error) {
@@ -51,13 +114,14 @@ func (s *SlackConnector) getClient() (*slack.Client, error) {
 	return slack.New(tok.AccessToken), nil
 }
 
-func (g *SlackConnector) requestOauthWeb(config *oauth2.Config) error {
+func (g *SlackConnector) requestOauthWeb(config *oauth2.Config) (string, error) {
 	log.Printf("Requesting token from web with redirectURL: %v", config.RedirectURL)
-	authURL := config.AuthCodeURL(g.ID(), oauth2.AccessTypeOffline)
-	fmt.Printf("Your browser has been opened to visit:\n%v\n", authURL)
-
-	// Open URL in the default browser
-	return exec.Command("open", authURL).Start()
+	// Slack does not support PKCE
+	session, err := NewAuthSession(g.ID(), false)
+	if err != nil {
+		return "", err
+	}
+	return session.AuthCodeURL(config), nil
 }
 
 var slackScopes = []string{
@@ -69,36 +133,38 @@ var slackScopes = []string{
 	"im:read",
 	"mpim:history",
 	"mpim:read",
+	"users:read",
+	"files:read",
 }
 
 func (s *SlackConnector) slackConfig() (*oauth2.Config, error) {
 	return &oauth2.Config{
 		ClientID:     s.clientID,
 		ClientSecret: s.clientSecret,
-		RedirectURL:  fmt.Sprintf("https://localhost:8082/connectors/%s/callback", s.Type()),
+		RedirectURL:  secureCallbackURL(string(s.Type())),
 		Scopes:       slackScopes,
 		Endpoint:     oauthslack.Endpoint,
 	}, nil
 }
 
-func (s *SlackConnector) AuthSetup(ctx context.Context) error {
+func (s *SlackConnector) AuthSetup(ctx context.Context) (string, error) {
 	config, err := s.slackConfig()
 	if err != nil {
-		return fmt.Errorf("unable to get slack config: %v", err)
+		return "", fmt.Errorf("unable to get slack config: %v", err)
 	}
 
 	_, err = keychain.TokenFromKeychain(s.ID(), s.Type())
 	if err == nil {
 		// TODO: check for expiry of refresh token
 		log.Print("Token found in keychain.")
-		return nil
+		return "", nil
 	}
 	log.Print("No token found in keychain. Getting token from web.")
-	err = s.requestOauthWeb(config)
+	authURL, err := s.requestOauthWeb(config)
 	if err != nil {
-		log.Printf("Unable to request token from web: %v", err)
+		return "", fmt.Errorf("unable to request token from web: %v", err)
 	}
-	return nil
+	return authURL, nil
 }
 
 func (s *SlackConnector) getUserString(client *slack.Client) (string, error) {
@@ -111,13 +177,13 @@ func (s *SlackConnector) getUserString(client *slack.Client) (string, error) {
 }
 
 // TODO: handle token expiries
-func (s *SlackConnector) AuthCallback(ctx context.Context, authCode string) error {
+func (s *SlackConnector) AuthCallback(ctx context.Context, authCode string, verifier string) error {
 	config, err := s.slackConfig()
 	if err != nil {
 		return fmt.Errorf("unable to get slack config: %v", err)
 	}
 
-	tok, err := config.Exchange(ctx, authCode)
+	tok, err := config.Exchange(ctx, authCode, exchangeOptions(verifier)...)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve token from web: %v", err)
 	}
@@ -145,9 +211,9 @@ func (s *SlackConnector) AuthCallback(ctx context.Context, authCode string) erro
 	return s.UpdateConnectorState(ctx, state)
 }
 
-func (s *SlackConnector) Sync(lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+func (s *SlackConnector) Sync(ctx context.Context, lastSync time.Time, chunkChan chan types.ChunkSyncResult, errChan chan error) {
 	defer close(chunkChan)
-	if err := s.context.Err(); err != nil {
+	if err := ctx.Err(); err != nil {
 		errChan <- fmt.Errorf("context error: %s", err)
 		return
 	}
@@ -159,13 +225,36 @@ func (s *SlackConnector) Sync(lastSync time.Time, chunkChan chan types.ChunkSync
 		return
 	}
 
-	err = s.fetchAllMessages(s.context, client, lastSync, chunkChan)
+	state, err := s.Status(ctx)
+	if err != nil {
+		errChan <- fmt.Errorf("unable to get connector state: %v", err)
+		return
+	}
+
+	err = s.fetchAllMessages(ctx, client, lastSync, state.SyncCursors, chunkChan)
+	if isSlackAuthError(err) {
+		s.invalidateAuth(fmt.Sprintf("Slack rejected the token: %v", err))
+	}
 	if err != nil {
 		errChan <- fmt.Errorf("error fetching messages: %v", err)
 	}
 }
 
-func (s *SlackConnector) fetchAllMessages(ctx context.Context, client *slack.Client, lastSync time.Time, chunkChan chan types.ChunkSyncResult) error {
+// isSlackAuthError tells whether Slack rejected the token, e.g. because the
+// app was uninstalled
+func isSlackAuthError(err error) bool {
+	var slackErr slack.SlackErrorResponse
+	if !errors.As(err, &slackErr) {
+		return false
+	}
+	switch slackErr.Err {
+	case "invalid_auth", "not_authed", "token_revoked", "token_expired", "account_inactive":
+		return true
+	}
+	return false
+}
+
+func (s *SlackConnector) fetchAllMessages(ctx context.Context, client *slack.Client, lastSync time.Time, cursors map[string]string, chunkChan chan types.ChunkSyncResult) error {
 	log.Printf("Fetching channels")
 	channels, err := s.fetchAllChannels(client)
 	if err != nil {
@@ -174,10 +263,31 @@ func (s *SlackConnector) fetchAllMessages(ctx context.Context, client *slack.Cli
 
 	log.Printf("Processing messages in %d channels", len(channels))
 	for _, channel := range channels {
-		err = s.fetchAndProcessChannelMessages(ctx, client, channel, lastSync, chunkChan)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+
+		cursor, err := parseSlackChannelCursor(cursors[channel.ID], lastSync)
+		if err != nil {
+			log.Printf("Ignoring invalid cursor for channel %s: %v", channel.ID, err)
+		}
+		if cursors[channel.ID] == "" || err != nil {
+			// Pin the start of the channel, as the connector-wide last sync
+			// moves on even if the channel fails
+			s.emitCursor(channel.ID, cursor, chunkChan)
+		}
+
+		err = s.fetchAndProcessChannelMessages(ctx, client, channel, cursor, chunkChan)
+		if err != nil {
+			// A failing channel should not prevent the others from syncing.
+			// Its stored cursor was not advanced past the failure, so it
+			// will be retried on the next sync.
+			chunkChan <- itemError(
+				types.Document{Name: s.channelTitle(ctx, client, channel)},
+				types.SyncStageFetch,
+				fmt.Errorf("unable to sync channel %s: %v", channel.ID, err),
+			)
+		}
 	}
 
 	return nil
@@ -185,7 +295,7 @@ func (s *SlackConnector) fetchAllMessages(ctx context.Context, client *slack.Cli
 
 func (s *SlackConnector) fetchAllChannels(client *slack.Client) ([]slack.Channel, error) {
 	params := &slack.GetConversationsParameters{
-		Types: []string{"public_channel", "private_channel", "im"},
+		Types: s.settings.ConversationTypes,
 		Limit: 100,
 	}
 
@@ -195,7 +305,7 @@ func (s *SlackConnector) fetchAllChannels(client *slack.Client) ([]slack.Channel
 		params.Cursor = cursor
 		ch, nextCursor, err := client.GetConversations(params)
 		if err != nil {
-			return nil, fmt.Errorf("error fetching channels: %v", err)
+			return nil, fmt.Errorf("error fetching channels: %w", err)
 		}
 		channels = append(channels, ch...)
 		if nextCursor == "" {
@@ -207,15 +317,112 @@ func (s *SlackConnector) fetchAllChannels(client *slack.Client) ([]slack.Channel
 }
 
 func (s *SlackConnector) TimestampToTime(ts string) (time.Time, error) {
-	// Looks like 1355517523.000005
+	return slackTimestampToTime(ts)
+}
 
-	parts := strings.Split(ts, ".")
-	unixMilli, err := strconv.ParseInt(parts[0], 10, 64)
+// slackTimestampToTime parses a Slack message timestamp, which holds the
+// seconds since the epoch and a microsecond suffix, e.g. 1355517523.000005
+func slackTimestampToTime(ts string) (time.Time, error) {
+	secs, micros, _ := strings.Cut(ts, ".")
+	unixSecs, err := strconv.ParseInt(secs, 10, 64)
 	if err != nil {
 		return time.Time{}, fmt.Errorf("unable to parse timestamp: %v", err)
 	}
 
-	return time.UnixMilli(unixMilli), nil
+	var unixMicros int64
+	if micros != "" {
+		unixMicros, err = strconv.ParseInt(micros, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to parse timestamp: %v", err)
+		}
+	}
+
+	return time.Unix(unixSecs, unixMicros*int64(time.Microsecond)), nil
+}
+
+func timeToSlackTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/int(time.Microsecond))
+}
+
+// slackTimestampAfter reports whether ts is more recent than other. Empty
+// timestamps are older than any other.
+func slackTimestampAfter(ts, other string) bool {
+	if other == "" {
+		return ts != ""
+	}
+	t, err := slackTimestampToTime(ts)
+	if err != nil {
+		return false
+	}
+	o, err := slackTimestampToTime(other)
+	if err != nil {
+		return true
+	}
+	return t.After(o)
+}
+
+// slackChannelCursor is the sync position of a channel, persisted as JSON in
+// the connector state. Slack returns the history of a channel newest first,
+// so the messages of a sync window are processed backwards from the most
+// recent one. If the sync is interrupted, the rest of the window is resumed
+// by fetching the messages between Oldest and Latest, and the window is only
+// committed by moving Oldest to High once it has been fully processed.
+type slackChannelCursor struct {
+	// All messages up to Oldest have been indexed
+	Oldest string `json:"oldest"`
+	// Latest is the oldest message processed in a pending window, if any
+	Latest string `json:"latest,omitempty"`
+	// High is the most recent message processed in a pending window
+	High string `json:"high,omitempty"`
+	// Ordinal of the next chunk of the channel. Chunks are numbered in the
+	// order they are processed, newest first within each sync window like
+	// the messages in a chunk, so that neighbours are adjacent messages.
+	Ordinal int `json:"ordinal,omitempty"`
+}
+
+func (c slackChannelCursor) pending() bool {
+	return c.Latest != ""
+}
+
+// parseSlackChannelCursor reads a stored cursor. Channels without a cursor
+// start from the last connector-wide sync, as recorded by older versions.
+func parseSlackChannelCursor(value string, lastSync time.Time) (slackChannelCursor, error) {
+	cursor := slackChannelCursor{Oldest: "0"}
+	if !lastSync.IsZero() {
+		cursor.Oldest = timeToSlackTimestamp(lastSync)
+	}
+	if value == "" {
+		return cursor, nil
+	}
+
+	stored := slackChannelCursor{}
+	err := json.Unmarshal([]byte(value), &stored)
+	if err != nil {
+		return cursor, err
+	}
+	return stored, nil
+}
+
+func (s *SlackConnector) emitCursor(channelID string, cursor slackChannelCursor, chunkChan chan types.ChunkSyncResult) {
+	b, err := json.Marshal(cursor)
+	if err != nil {
+		log.Printf("Unable to marshal cursor for channel %s: %v", channelID, err)
+		return
+	}
+	chunkChan <- types.ChunkSyncResult{
+		Cursor: &types.SyncCursor{Key: channelID, Value: string(b)},
+	}
+}
+
+// waitSlackRateLimit waits before retrying a rate limited call, unless the
+// sync is cancelled meanwhile
+func waitSlackRateLimit(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(slackRateLimitBackoff):
+		return nil
+	}
 }
 
 func IsErrSlackRateLimit(err error) bool {
@@ -229,17 +436,7 @@ func IsErrSlackRateLimit(err error) bool {
 	return false
 }
 
-func (s *SlackConnector) fetchAndProcessChannelMessages(ctx context.Context, client *slack.Client, channel slack.Channel, lastSync time.Time, chunkChan chan types.ChunkSyncResult) error {
-	since := "0"
-	if !lastSync.IsZero() {
-		since = fmt.Sprintf("%d", lastSync.UnixMilli())
-	}
-	params := slack.GetConversationHistoryParameters{
-		ChannelID: channel.ID,
-		Limit:     100,
-		Oldest:    since,
-	}
-
+func (s *SlackConnector) fetchAndProcessChannelMessages(ctx context.Context, client *slack.Client, channel slack.Channel, cursor slackChannelCursor, chunkChan chan types.ChunkSyncResult) error {
 	// Each channel is stored as a single document
 	var doc *types.Document
 	doc, err := s.store.GetDocument(ctx, channel.ID)
@@ -253,25 +450,59 @@ func (s *SlackConnector) fetchAndProcessChannelMessages(ctx context.Context, cli
 		}
 		doc = &types.Document{
 			UniqueID:      channel.ID,
-			Name:          channel.ID, // TODO: store channel name instead?
-			SourceURL:     "",         // Sent with the first chunk as it needs a timestamp
+			Name:          s.channelTitle(ctx, client, channel),
+			SourceURL:     "", // Sent with the first chunk as it needs a timestamp
 			ConnectorID:   s.ID(),
 			ConnectorType: string(s.Type()),
 			// TODO: CreatedAt
 			UpdatedAt: time.Now(),
 		}
 	}
+	// GetDocument does not return the unique ID
+	doc.UniqueID = channel.ID
 
+	wasPending := cursor.pending()
+	cursor, err = s.syncChannelWindow(ctx, client, channel, *doc, cursor, chunkChan)
+	if err != nil {
+		return err
+	}
+	if wasPending {
+		// The interrupted window is complete, catch up with newer messages
+		_, err = s.syncChannelWindow(ctx, client, channel, *doc, cursor, chunkChan)
+	}
+	return err
+}
+
+// syncChannelWindow indexes the messages of a channel that are more recent
+// than cursor.Oldest, or that are in the pending window of the cursor. The
+// cursor is persisted after every page of messages, and the completed cursor
+// is returned.
+func (s *SlackConnector) syncChannelWindow(ctx context.Context, client *slack.Client, channel slack.Channel, doc types.Document, cursor slackChannelCursor, chunkChan chan types.ChunkSyncResult) (slackChannelCursor, error) {
+	params := slack.GetConversationHistoryParameters{
+		ChannelID: channel.ID,
+		Limit:     100,
+		Oldest:    cursor.Oldest,
+		Latest:    cursor.Latest,
+	}
+
+	s.messageBuffer = ""
+	s.nextOrdinal = cursor.Ordinal
 	for {
-		log.Printf("Fetching conversation history for channel %s since %s", channel.ID, since)
-		history, err := client.GetConversationHistory(&params)
+		if err := ctx.Err(); err != nil {
+			return cursor, err
+		}
+
+		log.Printf("Fetching conversation history for channel %s in (%s, %s)", channel.ID, params.Oldest, params.Latest)
+		history, err := client.GetConversationHistoryContext(ctx, &params)
 		if err != nil {
 			if IsErrSlackRateLimit(err) {
-				time.Sleep(slackRateLimitBackoff)
+				if err := waitSlackRateLimit(ctx); err != nil {
+					return cursor, err
+				}
 				continue
 			}
 
-			return fmt.Errorf("error fetching channel history for channel %s: %v", channel.ID, err)
+			return cursor, fmt.Errorf("error fetching channel history for channel %s: %v", channel.ID, err)
 		}
 		if !history.Ok {
 			log.Printf("History error: %s", history.Error)
@@ -279,12 +510,30 @@ func (s *SlackConnector) fetchAndProcessChannelMessages(ctx context.Context, cli
 		log.Printf("Fetched %d messages, latest: %s", len(history.Messages), history.Latest)
 
 		// Slack messages are much shorter than a chunk, so we can batch them to maintain conversation context
-		s.messageBuffer = ""
 		for _, message := range history.Messages {
-			err := s.processMessage(*doc, client, channel.ID, message, chunkChan)
+			err := s.processMessage(ctx, doc, client, channel.ID, message, chunkChan)
 			if err != nil {
-				return err
+				return cursor, err
 			}
+
+			if message.ReplyCount > 0 && message.ThreadTimestamp == message.Timestamp {
+				err = s.processReplies(ctx, doc, client, channel.ID, message, chunkChan)
+				if err != nil {
+					return cursor, err
+				}
+			}
+
+			if slackTimestampAfter(message.Timestamp, cursor.High) {
+				cursor.High = message.Timestamp
+			}
+			cursor.Latest = message.Timestamp
+		}
+
+		// Commit the page so that an interrupted sync resumes after it
+		s.flushMessageBuffer(doc, chunkChan)
+		cursor.Ordinal = s.nextOrdinal
+		if len(history.Messages) > 0 {
+			s.emitCursor(channel.ID, cursor, chunkChan)
 		}
 
 		if !history.HasMore {
@@ -292,8 +541,53 @@ func (s *SlackConnector) fetchAndProcessChannelMessages(ctx context.Context, cli
 		}
 		params.Cursor = history.ResponseMetaData.NextCursor
 	}
-	s.flushMessageBuffer(*doc, chunkChan)
-	return nil
+
+	if cursor.High != "" {
+		cursor.Oldest = cursor.High
+	}
+	cursor.Latest = ""
+	cursor.High = ""
+	s.emitCursor(channel.ID, cursor, chunkChan)
+	return cursor, nil
+}
+
+// processReplies adds the replies of a thread right after its parent
+// message, so that they end up in the same chunk whenever possible
+func (s *SlackConnector) processReplies(ctx context.Context, document types.Document, client *slack.Client, channelID string, parent slack.Message, chunkChan chan types.ChunkSyncResult) error {
+	params := &slack.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: parent.Timestamp,
+		Limit:     100,
+	}
+
+	for {
+		replies, hasMore, nextCursor, err := client.GetConversationRepliesContext(ctx, params)
+		if err != nil {
+			if IsErrSlackRateLimit(err) {
+				if err := waitSlackRateLimit(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("error fetching replies to message %s in channel %s: %v", parent.Timestamp, channelID, err)
+		}
+
+		for _, reply := range replies {
+			if reply.Timestamp == parent.Timestamp {
+				// The parent message is always returned first
+				continue
+			}
+			err = s.processMessage(ctx, document, client, channelID, reply, chunkChan)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !hasMore {
+			return nil
+		}
+		params.Cursor = nextCursor
+	}
 }
 
 func (s *SlackConnector) flushMessageBuffer(document types.Document, chunkChan chan types.ChunkSyncResult) {
@@ -302,27 +596,38 @@ func (s *SlackConnector) flushMessageBuffer(document types.Document, chunkChan c
 			Chunk: types.Chunk{
 				Text:     s.messageBuffer,
 				Document: document,
+				Ordinal:  s.nextOrdinal,
 			},
-			SkipClean: true,
 		}
 		s.messageBuffer = ""
+		s.nextOrdinal++
 	}
 }
 
-func (s *SlackConnector) processMessage(document types.Document, client *slack.Client, channelID string, message slack.Message, chunkChan chan types.ChunkSyncResult) error {
+func (s *SlackConnector) processMessage(ctx context.Context, document types.Document, client *slack.Client, channelID string, message slack.Message, chunkChan chan types.ChunkSyncResult) error {
+	author := s.messageAuthor(ctx, client, message)
+	if message.ThreadTimestamp != "" && message.ThreadTimestamp != message.Timestamp {
+		author = "(in thread) " + author
+	}
+
+	content := s.resolveMarkup(ctx, client, message.Text)
+	for _, file := range message.Files {
+		content += fmt.Sprintf(" [shared file: %s]", fileTitle(file))
+		if s.settings.IndexFiles {
+			s.processFile(ctx, client, file, chunkChan)
+		}
+	}
+
 	// In the slack connector we do not delete a previous document's chunks as
 	// we are not expecting to re-index the entire document/channel.
-	content := util.CleanChunk(message.Text)
 	log.Printf("Processing %s message %s: %s", document.UniqueID, message.User, content)
-	currentWordCount := len(strings.Fields(s.messageBuffer))
-	incomingWordCount := len(strings.Fields(content))
-
-	if incomingWordCount > MaxChunkSize {
-		// TODO: handle large messages
-	}
+	currentTokens := chunker.EstimateTokens(s.messageBuffer)
+	incomingTokens := chunker.EstimateTokens(content)
+	// The limit the chunker applies, so that both agree on when to split
+	maxTokens := s.chunkSettings().MaxTokens()
 
-	if currentWordCount+incomingWordCount <= MaxChunkSize {
-		s.messageBuffer += fmt.Sprintf("%s: %s \n", message.User, content)
+	if currentTokens+incomingTokens <= maxTokens {
+		s.messageBuffer += fmt.Sprintf("%s: %s \n", author, content)
 		return nil
 	}
 
@@ -337,6 +642,249 @@ func (s *SlackConnector) processMessage(document types.Document, client *slack.C
 
 	document.SourceURL = link
 	s.flushMessageBuffer(document, chunkChan)
-	s.messageBuffer = fmt.Sprintf("%s: %s |\n", message.User, content)
+
+	if incomingTokens > maxTokens {
+		// Messages larger than a chunk are split on their own
+		for _, chunk := range (&chunker.RecursiveChunker{Settings: s.chunkSettings()}).Chunk(content) {
+			s.messageBuffer = fmt.Sprintf("%s: %s |\n", author, chunk.Text)
+			s.flushMessageBuffer(document, chunkChan)
+		}
+		return nil
+	}
+
+	s.messageBuffer = fmt.Sprintf("%s: %s |\n", author, content)
 	return nil
 }
+
+// userName resolves a user ID to its display name. Lookups are cached for
+// the lifetime of the connector, and the ID itself is returned if the user
+// cannot be found.
+func (s *SlackConnector) userName(ctx context.Context, client *slack.Client, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	if name, ok := s.userNames[userID]; ok {
+		return name
+	}
+
+	var user *slack.User
+	var err error
+	for i := 0; i < slackMaxUserLookups; i++ {
+		user, err = client.GetUserInfoContext(ctx, userID)
+		if !IsErrSlackRateLimit(err) || i == slackMaxUserLookups-1 {
+			break
+		}
+		if waitErr := waitSlackRateLimit(ctx); waitErr != nil {
+			err = waitErr
+			break
+		}
+	}
+	if err != nil {
+		// Not cached, so that the lookup is retried on the next sync
+		log.Printf("Unable to get user info for %s: %v", userID, err)
+		return userID
+	}
+
+	name := user.Profile.DisplayName
+	if name == "" {
+		name = user.RealName
+	}
+	if name == "" {
+		name = user.Name
+	}
+	if s.userNames == nil {
+		s.userNames = map[string]string{}
+	}
+	s.userNames[userID] = name
+	return name
+}
+
+func (s *SlackConnector) messageAuthor(ctx context.Context, client *slack.Client, message slack.Message) string {
+	if message.User != "" {
+		return s.userName(ctx, client, message.User)
+	}
+	if message.Username != "" {
+		return message.Username
+	}
+	return message.BotID
+}
+
+var (
+	// Slack encodes mentions, channel references and links as <...>, with
+	// an optional label after a pipe
+	slackMentionRegex = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|[^>]*)?>`)
+	slackChannelRegex = regexp.MustCompile(`<#[A-Z0-9]+\|([^>]*)>`)
+	slackLinkRegex    = regexp.MustCompile(`<((?:https?|mailto):[^|>]+)(?:\|([^>]*))?>`)
+)
+
+// resolveMarkup replaces user IDs with display names and unwraps channel
+// references and links in the text of a message
+func (s *SlackConnector) resolveMarkup(ctx context.Context, client *slack.Client, text string) string {
+	text = slackMentionRegex.ReplaceAllStringFunc(text, func(mention string) string {
+		userID := slackMentionRegex.FindStringSubmatch(mention)[1]
+		return "@" + s.userName(ctx, client, userID)
+	})
+	text = slackChannelRegex.ReplaceAllString(text, "#$1")
+	return slackLinkRegex.ReplaceAllStringFunc(text, func(link string) string {
+		match := slackLinkRegex.FindStringSubmatch(link)
+		if match[2] == "" || match[2] == match[1] {
+			return match[1]
+		}
+		return fmt.Sprintf("%s (%s)", match[2], match[1])
+	})
+}
+
+// channelTitle returns a human readable name for a channel, used as the
+// title of its document
+func (s *SlackConnector) channelTitle(ctx context.Context, client *slack.Client, channel slack.Channel) string {
+	switch {
+	case channel.IsIM:
+		return "Direct messages with " + s.userName(ctx, client, channel.User)
+	case channel.Name != "":
+		return "#" + channel.Name
+	default:
+		return channel.ID
+	}
+}
+
+// ListInventory lists the channels of the selected types, and the shared
+// files when they are indexed. Messages deleted from a channel are not
+// removed, as they are merged into the chunks of the channel document.
+func (s *SlackConnector) ListInventory(ctx context.Context) ([]string, error) {
+	client, err := s.getClient()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get client: %v", err)
+	}
+	channels, err := s.fetchAllChannels(client)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, channel := range channels {
+		ids = append(ids, channel.ID)
+	}
+	if !s.settings.IndexFiles {
+		return ids, nil
+	}
+
+	params := slack.ListFilesParameters{Limit: 200}
+	for {
+		files, next, err := client.ListFilesContext(ctx, params)
+		if IsErrSlackRateLimit(err) {
+			if err := waitSlackRateLimit(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to list files: %v", err)
+		}
+		for _, file := range files {
+			ids = append(ids, file.ID)
+		}
+		if next == nil || next.Cursor == "" {
+			return ids, nil
+		}
+		params.Cursor = next.Cursor
+	}
+}
+
+// RetryItems indexes again the files with the given IDs. Channels are not
+// retried individually, as their cursors already resume failed syncs.
+func (s *SlackConnector) RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	defer close(chunkChan)
+	client, err := s.getClient()
+	if err != nil {
+		errChan <- fmt.Errorf("unable to get client: %v", err)
+		return
+	}
+
+	for _, fileID := range uniqueIDs {
+		file, _, _, err := client.GetFileInfoContext(ctx, fileID, 0, 0)
+		if err != nil && err.Error() == "file_not_found" {
+			chunkChan <- types.ChunkSyncResult{Tombstone: fileID}
+			continue
+		}
+		if err != nil {
+			chunkChan <- itemError(
+				types.Document{UniqueID: fileID},
+				types.SyncStageFetch,
+				fmt.Errorf("unable to get slack file %s: %v", fileID, err),
+			)
+			continue
+		}
+		s.processFile(ctx, client, *file, chunkChan)
+	}
+}
+
+func fileTitle(file slack.File) string {
+	if file.Title != "" {
+		return file.Title
+	}
+	return file.Name
+}
+
+// processFile indexes a file shared in a channel as a document of its own.
+// Errors are reported through chunkChan so that they do not abort the sync
+// of the channel.
+func (s *SlackConnector) processFile(ctx context.Context, client *slack.Client, file slack.File, chunkChan chan types.ChunkSyncResult) {
+	if _, ok := SupportedMimeTypes[file.Mimetype]; !ok || file.Mode == "tombstone" || file.Mode == "hidden_by_limit" {
+		return
+	}
+	log.Printf("Processing slack file: %s", file.Name)
+
+	content, err := s.downloadAndParseFile(ctx, client, file)
+	if err != nil {
+		chunkChan <- itemError(
+			types.Document{UniqueID: file.ID, Name: fileTitle(file)},
+			types.SyncStageFetch,
+			fmt.Errorf("unable to process slack file %s: %v", file.Name, err),
+		)
+		return
+	}
+
+	document := types.Document{
+		UniqueID:      file.ID,
+		Name:          fileTitle(file),
+		SourceURL:     file.Permalink,
+		ConnectorID:   s.ID(),
+		ConnectorType: string(s.Type()),
+		CreatedAt:     file.Created.Time(),
+		UpdatedAt:     file.Created.Time(),
+	}
+
+	// Files shared again in another message are re-indexed from scratch
+	err = s.store.DeleteDocumentChunks(ctx, document.UniqueID, s.ID())
+	if err != nil {
+		log.Printf("Unable to delete chunks for document %s: %v", document.UniqueID, err)
+	}
+
+	s.emitChunks(content, "text/plain", document, chunkChan)
+}
+
+func (s *SlackConnector) downloadAndParseFile(ctx context.Context, client *slack.Client, file slack.File) (string, error) {
+	tempFilePath, err := createTempFilePath(file.ID)
+	if err != nil {
+		return "", err
+	}
+
+	outFile, err := os.Create(tempFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	err = client.GetFileContext(ctx, file.URLPrivateDownload, outFile)
+	outFile.Close()
+	defer func() {
+		if err := os.Remove(tempFilePath); err != nil {
+			log.Printf("Error deleting file %s: %s", tempFilePath, err)
+		}
+	}()
+	if err != nil {
+		return "", fmt.Errorf("failed to download file: %v", err)
+	}
+
+	return ParseBinaryFile(ctx, &ParseRequest{
+		Type: file.Mimetype,
+		Path: tempFilePath,
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// Number of texts embedded per request to ollama
+const embedBatchSize = 32
+
+var embedClient = &http.Client{
+	// Batches of long chunks take a while on CPU
+	Timeout: 2 * time.Minute,
+}
+
+// taskPrefixes are prepended to the texts embedded by models trained to tell
+// documents from queries
+type taskPrefixes struct {
+	document string
+	query    string
+}
+
+var embeddingPrefixes = map[string]taskPrefixes{
+	"nomic-embed-text":       {document: "search_document: ", query: "search_query: "},
+	"mxbai-embed-large":      {query: "Represent this sentence for searching relevant passages: "},
+	"snowflake-arctic-embed": {query: "Represent this sentence for searching relevant passages: "},
+}
+
+// modelPrefixes returns the task prefixes of a model, ignoring its tag and
+// namespace
+func modelPrefixes(model string) taskPrefixes {
+	name, _, _ := strings.Cut(model, ":")
+	name = name[strings.LastIndex(name, "/")+1:]
+	return embeddingPrefixes[name]
+}
+
+// OllamaEmbedder embeds chunks and queries with the /api/embed endpoint of
+// ollama
+type OllamaEmbedder struct{}
+
+func NewOllamaEmbedder() *OllamaEmbedder {
+	return &OllamaEmbedder{}
+}
+
+type EmbedRequest struct {
+	Model     string   `json:"model"`
+	Input     []string `json:"input"`
+	Truncate  bool     `json:"truncate"`
+	KeepAlive string   `json:"keep_alive"`
+}
+
+type EmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// EmbedDocuments returns the vectors of chunks to add to the index
+func (e *OllamaEmbedder) EmbedDocuments(ctx context.Context, index *types.EmbeddingIndex, texts []string) ([][]float32, error) {
+	prefix := ""
+	if index.Prefixed {
+		prefix = modelPrefixes(index.Model).document
+	}
+	vectors := [][]float32{}
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch, err := e.embed(ctx, index, prefix, texts[start:min(start+embedBatchSize, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// EmbedQuery returns the vector to search the index with
+func (e *OllamaEmbedder) EmbedQuery(ctx context.Context, index *types.EmbeddingIndex, text string) ([]float32, error) {
+	prefix := ""
+	if index.Prefixed {
+		prefix = modelPrefixes(index.Model).query
+	}
+	vectors, err := e.embed(ctx, index, prefix, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// embed computes the vectors of texts with the model of the index, and
+// checks that they have the dimensions of the index once recorded
+func (e *OllamaEmbedder) embed(ctx context.Context, index *types.EmbeddingIndex, prefix string, texts []string) ([][]float32, error) {
+	input := []string{}
+	for _, text := range texts {
+		input = append(input, prefix+text)
+	}
+	jsonData, err := json.Marshal(EmbedRequest{
+		Model:     index.Model,
+		Input:     input,
+		Truncate:  true,
+		KeepAlive: KeepAliveTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	for i := 0; i < 3; i++ {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/api/embed", OllamaHost), bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = embedClient.Do(req)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		// Ollama may be restarting
+		time.Sleep(2 * time.Second * time.Duration(i+1))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed with %s: %v", index.Model, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to embed with %s: unexpected status %s: %s", index.Model, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var embedResp EmbedResponse
+	err = json.NewDecoder(resp.Body).Decode(&embedResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %v", err)
+	}
+	if embedResp.Model != "" && !sameModel(embedResp.Model, index.Model) {
+		return nil, fmt.Errorf("embedded with model %s instead of %s", embedResp.Model, index.Model)
+	}
+	if len(embedResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(embedResp.Embeddings), len(texts))
+	}
+	for _, vector := range embedResp.Embeddings {
+		if index.Dimensions != 0 && len(vector) != index.Dimensions {
+			return nil, fmt.Errorf("model %s returned %d dimensions, index %s has %d", index.Model, len(vector), index.ClassName, index.Dimensions)
+		}
+		if len(vector) != len(embedResp.Embeddings[0]) {
+			return nil, fmt.Errorf("model %s returned vectors of different dimensions", index.Model)
+		}
+	}
+	return embedResp.Embeddings, nil
+}
@@ -16,6 +16,7 @@ require (
 	github.com/weaviate/weaviate v1.24.8
 	github.com/weaviate/weaviate-go-client/v4 v4.13.1
 	github.com/zalando/go-keyring v0.2.4
+	golang.org/x/crypto v0.21.0
 	golang.org/x/oauth2 v0.19.0
 	google.golang.org/api v0.172.0
 )
@@ -73,7 +74,6 @@ require (
 	go.opentelemetry.io/otel v1.24.0 // indirect
 	go.opentelemetry.io/otel/metric v1.24.0 // indirect
 	go.opentelemetry.io/otel/trace v1.24.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
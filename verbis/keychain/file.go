@@ -0,0 +1,206 @@
+package keychain
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	fileStoreVersion = 1
+
+	// scrypt parameters recommended for interactive logins
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+// encryptedFile is the on-disk format of the file store. Data holds the
+// secrets encoded as a JSON map, encrypted with AES-GCM using a key derived
+// from the passphrase with scrypt.
+type encryptedFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// FileStore stores secrets in a file encrypted with a passphrase, for
+// systems without an OS keyring
+type FileStore struct {
+	path string
+
+	lock    sync.Mutex
+	salt    []byte
+	aead    cipher.AEAD
+	secrets map[string]string
+}
+
+// NewFileStore opens the encrypted file at path, creating it on the first
+// write if it does not exist yet
+func NewFileStore(path, passphrase string) (*FileStore, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required for the encrypted file secret store, set %s or %s", envSecretPassphrase, envSecretPassphraseFile)
+	}
+	f := &FileStore{
+		path:    path,
+		secrets: map[string]string{},
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		f.salt = make([]byte, saltLen)
+		if _, err := rand.Read(f.salt); err != nil {
+			return nil, fmt.Errorf("unable to generate salt: %v", err)
+		}
+		f.aead, err = deriveAEAD(passphrase, f.salt)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret file: %v", err)
+	}
+
+	var ef encryptedFile
+	err = json.Unmarshal(b, &ef)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse secret file: %v", err)
+	}
+	if ef.Version != fileStoreVersion || ef.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported secret file version %d with kdf %q", ef.Version, ef.KDF)
+	}
+	f.salt = ef.Salt
+	f.aead, err = deriveAEAD(passphrase, f.salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := f.aead.Open(nil, ef.Nonce, ef.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt secret file, wrong passphrase or corrupted file")
+	}
+	err = json.Unmarshal(plaintext, &f.secrets)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse decrypted secrets: %v", err)
+	}
+	return f, nil
+}
+
+func deriveAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (f *FileStore) Name() string {
+	return SecretStoreFile
+}
+
+func (f *FileStore) Get(key string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	value, ok := f.secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *FileStore) Set(key, value string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	prev, existed := f.secrets[key]
+	f.secrets[key] = value
+	err := f.write()
+	if err != nil {
+		if existed {
+			f.secrets[key] = prev
+		} else {
+			delete(f.secrets, key)
+		}
+	}
+	return err
+}
+
+func (f *FileStore) Delete(key string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	prev, ok := f.secrets[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(f.secrets, key)
+	err := f.write()
+	if err != nil {
+		f.secrets[key] = prev
+	}
+	return err
+}
+
+// write encrypts all secrets with a fresh nonce and atomically replaces the
+// file, which is only readable by the user
+func (f *FileStore) write() error {
+	plaintext, err := json.Marshal(f.secrets)
+	if err != nil {
+		return fmt.Errorf("unable to marshal secrets: %v", err)
+	}
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce: %v", err)
+	}
+	b, err := json.Marshal(encryptedFile{
+		Version: fileStoreVersion,
+		KDF:     "scrypt",
+		Salt:    f.salt,
+		Nonce:   nonce,
+		Data:    f.aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal secret file: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(f.path), 0700)
+	if err != nil {
+		return fmt.Errorf("unable to create secret file directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary secret file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write secret file: %v", err)
+	}
+	// CreateTemp already uses 0600
+	err = os.Rename(tmp.Name(), f.path)
+	if err != nil {
+		return fmt.Errorf("unable to replace secret file: %v", err)
+	}
+	return nil
+}
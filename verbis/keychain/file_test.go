@@ -0,0 +1,88 @@
+package keychain
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", "secrets.enc")
+	f, err := NewFileStore(path, "passphrase")
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := f.Set("token", "secret-value"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := f.Set("other", "other-value"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := f.Delete("other"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("file mode = %o, want 600", perm)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(b), "secret-value") {
+		t.Error("secret written in plaintext")
+	}
+
+	reopened, err := NewFileStore(path, "passphrase")
+	if err != nil {
+		t.Fatalf("NewFileStore reopen: %v", err)
+	}
+	value, err := reopened.Get("token")
+	if err != nil || value != "secret-value" {
+		t.Errorf("Get = %q, %v, want secret-value", value, err)
+	}
+	_, err = reopened.Get("other")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get deleted secret err = %v, want ErrNotFound", err)
+	}
+
+	_, err = NewFileStore(path, "wrong")
+	if err == nil {
+		t.Error("NewFileStore with wrong passphrase succeeded")
+	}
+	_, err = NewFileStore(path, "")
+	if err == nil {
+		t.Error("NewFileStore without passphrase succeeded")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	from := NewMemoryStore()
+	to := NewMemoryStore()
+	from.Set("a", "1")
+	from.Set("b", "2")
+
+	moved, err := Migrate(from, to, []string{"a", "b", "missing"})
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("moved = %d, want 2", moved)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		value, err := to.Get(key)
+		if err != nil || value != want {
+			t.Errorf("to.Get(%s) = %q, %v, want %q", key, value, err, want)
+		}
+		_, err = from.Get(key)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("from.Get(%s) err = %v, want ErrNotFound", key, err)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/verbis-ai/verbis/verbis/types"
-	"github.com/zalando/go-keyring"
 	"golang.org/x/oauth2"
 )
 
@@ -13,11 +12,16 @@ const (
 	keyringService = "VerbisAI"
 )
 
+// TokenKey is the key of the OAuth token of a connector in the secret store
+func TokenKey(connectorID string, connectorType types.ConnectorType) string {
+	return fmt.Sprintf("%s-%s-token", string(connectorType), connectorID)
+}
+
 func TokenFromKeychain(connectorID string, connectorType types.ConnectorType) (*oauth2.Token, error) {
-	tokenKey := fmt.Sprintf("%s-%s-token", string(connectorType), connectorID)
-	tokenJSON, err := keyring.Get(keyringService, tokenKey)
+	s := Store()
+	tokenJSON, err := s.Get(TokenKey(connectorID, connectorType))
 	if err != nil {
-		return nil, fmt.Errorf("unable to get token from keyring: %s", err)
+		return nil, fmt.Errorf("unable to get token from %s secret store: %w", s.Name(), err)
 	}
 	var token oauth2.Token
 	err = json.Unmarshal([]byte(tokenJSON), &token)
@@ -25,20 +29,19 @@ func TokenFromKeychain(connectorID string, connectorType types.ConnectorType) (*
 }
 
 func SaveTokenToKeychain(token *oauth2.Token, connectorID string, connectorType types.ConnectorType) error {
-	tokenKey := fmt.Sprintf("%s-%s-token", string(connectorType), connectorID)
 	bytes, err := json.Marshal(token)
 	if err != nil {
 		return fmt.Errorf("unable to marshal token: %v", err)
 	}
-	err = keyring.Set(keyringService, tokenKey, string(bytes))
+	s := Store()
+	err = s.Set(TokenKey(connectorID, connectorType), string(bytes))
 	if err != nil {
-		return fmt.Errorf("unable to save token to keychain: %v", err)
+		return fmt.Errorf("unable to save token to %s secret store: %v", s.Name(), err)
 	}
 
 	return nil
 }
 
 func DeleteTokenFromKeychain(connectorID string, connectorType types.ConnectorType) error {
-	tokenKey := fmt.Sprintf("%s-%s-token", string(connectorType), connectorID)
-	return keyring.Delete(keyringService, tokenKey)
-}
\ No newline at end of file
+	return Store().Delete(TokenKey(connectorID, connectorType))
+}
@@ -0,0 +1,228 @@
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+
+	"github.com/verbis-ai/verbis/verbis/util"
+)
+
+const (
+	SecretStoreAuto    = "auto"
+	SecretStoreKeyring = "keyring"
+	SecretStoreFile    = "file"
+	SecretStoreMemory  = "memory"
+
+	defaultSecretFile = "secrets.enc" // Relative to the data dir
+
+	// Environment variables selecting the secret store
+	envSecretStore          = "VERBIS_SECRET_STORE"
+	envSecretFile           = "VERBIS_SECRET_FILE"
+	envSecretPassphrase     = "VERBIS_SECRET_PASSPHRASE"
+	envSecretPassphraseFile = "VERBIS_SECRET_PASSPHRASE_FILE"
+	envSecretMigrateFrom    = "VERBIS_SECRET_MIGRATE_FROM"
+)
+
+// ErrNotFound is returned when a secret is not in the store
+var ErrNotFound = errors.New("secret not found")
+
+// SecretStore persists the secrets of connectors, such as their OAuth tokens
+type SecretStore interface {
+	Name() string
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// SecretStoreConfig selects the backend used to store secrets
+type SecretStoreConfig struct {
+	// Backend is one of auto, keyring, file or memory. With auto, the OS
+	// keyring is used when available, and the encrypted file otherwise.
+	Backend string
+	// FilePath and Passphrase are only used by the file backend
+	FilePath   string
+	Passphrase string
+	// MigrateFrom is the backend that secrets are moved from on boot, if any
+	MigrateFrom string
+}
+
+// SecretStoreConfigFromEnv reads the secret store configuration from the
+// environment, defaulting to auto
+func SecretStoreConfigFromEnv() (*SecretStoreConfig, error) {
+	cfg := &SecretStoreConfig{
+		Backend:     os.Getenv(envSecretStore),
+		FilePath:    os.Getenv(envSecretFile),
+		Passphrase:  os.Getenv(envSecretPassphrase),
+		MigrateFrom: os.Getenv(envSecretMigrateFrom),
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = SecretStoreAuto
+	}
+	if cfg.FilePath == "" {
+		path, err := util.DataPath(defaultSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FilePath = path
+	}
+	if cfg.Passphrase == "" && os.Getenv(envSecretPassphraseFile) != "" {
+		b, err := os.ReadFile(os.Getenv(envSecretPassphraseFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase file: %v", err)
+		}
+		cfg.Passphrase = strings.TrimSpace(string(b))
+	}
+	return cfg, nil
+}
+
+// NewSecretStore returns the store for the given backend
+func NewSecretStore(backend string, cfg *SecretStoreConfig) (SecretStore, error) {
+	switch backend {
+	case SecretStoreKeyring:
+		return NewKeyringStore(), nil
+	case SecretStoreFile:
+		return NewFileStore(cfg.FilePath, cfg.Passphrase)
+	case SecretStoreMemory:
+		return NewMemoryStore(), nil
+	case SecretStoreAuto:
+		if keyringAvailable() {
+			return NewKeyringStore(), nil
+		}
+		log.Printf("OS keyring unavailable, falling back to encrypted file %s", cfg.FilePath)
+		return NewFileStore(cfg.FilePath, cfg.Passphrase)
+	default:
+		return nil, fmt.Errorf("unknown secret store %q", backend)
+	}
+}
+
+var (
+	storeLock sync.RWMutex
+	store     SecretStore = NewKeyringStore()
+)
+
+// SetStore sets the store used for all secrets
+func SetStore(s SecretStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store = s
+}
+
+// Store returns the store used for all secrets
+func Store() SecretStore {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	return store
+}
+
+// Migrate moves the given secrets from one store to another. Secrets missing
+// from the source are skipped, and a secret is only deleted from the source
+// once it has been written to the destination. It returns the number of
+// secrets moved.
+func Migrate(from, to SecretStore, keys []string) (int, error) {
+	moved := 0
+	for _, key := range keys {
+		value, err := from.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return moved, fmt.Errorf("unable to read secret %s from %s: %v", key, from.Name(), err)
+		}
+		err = to.Set(key, value)
+		if err != nil {
+			return moved, fmt.Errorf("unable to write secret %s to %s: %v", key, to.Name(), err)
+		}
+		err = from.Delete(key)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Unable to delete migrated secret %s from %s: %v", key, from.Name(), err)
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// KeyringStore stores secrets in the OS keyring, i.e. the macOS Keychain or
+// a Secret Service daemon on Linux
+type KeyringStore struct{}
+
+func NewKeyringStore() *KeyringStore {
+	return &KeyringStore{}
+}
+
+func (k *KeyringStore) Name() string {
+	return SecretStoreKeyring
+}
+
+func (k *KeyringStore) Get(key string) (string, error) {
+	value, err := keyring.Get(keyringService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (k *KeyringStore) Set(key, value string) error {
+	return keyring.Set(keyringService, key, value)
+}
+
+func (k *KeyringStore) Delete(key string) error {
+	err := keyring.Delete(keyringService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// keyringAvailable tells whether the OS keyring can be reached, which is
+// not the case on headless Linux without a Secret Service daemon
+func keyringAvailable() bool {
+	_, err := keyring.Get(keyringService, "availability-probe")
+	return err == nil || errors.Is(err, keyring.ErrNotFound)
+}
+
+// MemoryStore keeps secrets in memory only, for tests
+type MemoryStore struct {
+	lock    sync.Mutex
+	secrets map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{secrets: map[string]string{}}
+}
+
+func (m *MemoryStore) Name() string {
+	return SecretStoreMemory
+}
+
+func (m *MemoryStore) Get(key string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, ok := m.secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *MemoryStore) Set(key, value string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.secrets[key] = value
+	return nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.secrets[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.secrets, key)
+	return nil
+}
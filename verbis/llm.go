@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -29,8 +28,15 @@ const (
 	MaxNumRerankedChunks      = 3
 	RerankNoResultScoreCutoff = 0.2
 	RerankSoloScoreCliff      = 0.3
+)
 
+var (
+	// OllamaHost is the address ollama listens on, set from the daemon config
 	OllamaHost = "127.0.0.1:11435"
+	// NumNeighbourChunks is the number of chunks on each side of a search
+	// result that are added to its content in the prompt, set from the daemon
+	// config. 0 disables the expansion.
+	NumNeighbourChunks = 1
 )
 
 var (
@@ -43,64 +49,6 @@ func IsCustomModel(modelName string) bool {
 	return strings.HasPrefix(modelName, "custom-")
 }
 
-type ModelCreateRequest struct {
-	Name      string `json:"name"`
-	Modelfile string `json:"modelfile"`
-	Stream    bool   `json:"stream"`
-}
-
-func createModel(modelName string) error {
-	url := fmt.Sprintf("http://%s/api/create", OllamaHost)
-
-	path, err := util.GetDistPath()
-	if err != nil {
-		return fmt.Errorf("failed to get dist path: %v", err)
-	}
-
-	modelFileName := fmt.Sprintf("Modelfile.%s", modelName)
-	modelFileData, err := os.ReadFile(filepath.Join(path, modelFileName))
-	if err != nil {
-		return fmt.Errorf("unable to read modelfile: %v", err)
-	}
-
-	log.Printf("Modelfile contents: %s", string(modelFileData))
-
-	payload := ModelCreateRequest{
-		Name:      modelName,
-		Modelfile: string(modelFileData),
-	}
-	// Marshal the payload into JSON
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	// Create a new HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	// Set the appropriate headers
-	req.Header.Set("Content-Type", "application/json")
-
-	// Make the HTTP request using the default client
-	client := &http.Client{}
-	response, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	// Read the response body
-	responseData, err := io.ReadAll(response.Body)
-	if err != nil {
-		return err
-	}
-	log.Printf("Response: %v", string(responseData))
-	return nil
-}
-
 type StreamResponse struct {
 	Model     string            `json:"model"`
 	CreatedAt time.Time         `json:"created_at"`
@@ -243,7 +191,7 @@ func chatWithModel(prompt string, model string, history []types.HistoryItem) (*A
 }
 
 func sourcesFromChunks(chunks []*types.Chunk) []types.Source {
-	sources := []types.Source{} 
+	sources := []types.Source{}
 	for _, chunk := range chunks {
 		skip := false
 		for _, source := range sources {
@@ -257,9 +205,12 @@ func sourcesFromChunks(chunks []*types.Chunk) []types.Source {
 			continue
 		}
 		sourceObj := types.Source{
-			Title: chunk.Name,
-			URL:   chunk.SourceURL,
-			Type:  chunk.ConnectorType,
+			Title:   chunk.Name,
+			URL:     chunk.SourceURL,
+			Type:    chunk.ConnectorType,
+			Section: strings.Join(chunk.HeadingPath, " > "),
+			Page:    chunk.PageStart,
+			From:    chunk.Metadata[types.DocumentMetadataFrom],
 		}
 		sources = append(sources, sourceObj)
 	}
@@ -397,7 +348,15 @@ func RunRerankModel(ctx context.Context, jsonData []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to get dist path: %v", err)
 	}
 	rerankFilePath := filepath.Join(distPath, rerankDistPath)
+	modelsDir, err := util.DataPath(miscModelsPath)
+	if err != nil {
+		return nil, err
+	}
 	cmd := exec.CommandContext(ctx, rerankFilePath)
+	cmd.Env = append(os.Environ(),
+		"VERBIS_MODELS_DIR="+modelsDir,
+		"VERBIS_RERANKER_MODEL="+rerankerModelName,
+	)
 	cmd.Stdin = bytes.NewReader(jsonData)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -547,8 +506,61 @@ func MakeRerankMessages(chunks []*types.Chunk, query string) ([]types.HistoryIte
 	return messages, nil
 }
 
+// GetNeighbours fetches the chunks surrounding each of the given chunks in
+// their documents, keyed by the hash of the chunk they surround. Failures are
+// logged and leave the chunks without neighbours.
+func GetNeighbours(ctx context.Context, st types.Store, chunks []*types.Chunk) map[string][]*types.Chunk {
+	if NumNeighbourChunks == 0 {
+		return map[string][]*types.Chunk{}
+	}
+	neighbours, err := st.GetChunkNeighbours(ctx, chunks, NumNeighbourChunks)
+	if err != nil {
+		log.Printf("Failed to get neighbours of chunks: %s", err)
+		return map[string][]*types.Chunk{}
+	}
+	return neighbours
+}
+
+// expandChunkText returns the text of a chunk surrounded by the text of its
+// neighbours in document order, without repeating the overlap between them
+func expandChunkText(chunk *types.Chunk, neighbours []*types.Chunk) string {
+	text := ""
+	inserted := false
+	for _, n := range neighbours {
+		if !inserted && n.Ordinal > chunk.Ordinal {
+			text = joinOverlapping(text, chunk.Text)
+			inserted = true
+		}
+		text = joinOverlapping(text, n.Text)
+	}
+	if !inserted {
+		text = joinOverlapping(text, chunk.Text)
+	}
+	return text
+}
+
+// joinOverlapping concatenates two consecutive chunks, dropping the start of
+// the second one if it repeats the end of the first one
+func joinOverlapping(first, second string) string {
+	if first == "" {
+		return second
+	}
+	maxOverlap := len(first)
+	if len(second) < maxOverlap {
+		maxOverlap = len(second)
+	}
+	for n := maxOverlap; n > 0; n-- {
+		if strings.HasSuffix(first, second[:n]) {
+			return first + second[n:]
+		}
+	}
+	return first + "\n" + second
+}
+
 // TODO: function calling?
-func MakePrompt(chunks []*types.Chunk, query string) string {
+// MakePrompt builds the prompt for the generation model. When neighbours are
+// given for a chunk, its content is expanded with theirs.
+func MakePrompt(chunks []*types.Chunk, neighbours map[string][]*types.Chunk, query string) string {
 	// Create a builder to efficiently concatenate strings
 	var builder strings.Builder
 
@@ -571,7 +583,26 @@ func MakePrompt(chunks []*types.Chunk, query string) string {
 	for i, chunk := range chunks {
 		builder.WriteString(fmt.Sprintf("\n===== Document %d ======\n", i))
 		builder.WriteString(fmt.Sprintf("Title: %s\n", chunk.Name))
-		builder.WriteString(fmt.Sprintf("Content: %s\n", chunk.Text))
+		if from := chunk.Metadata[types.DocumentMetadataFrom]; from != "" {
+			builder.WriteString(fmt.Sprintf("From: %s\n", from))
+		}
+		if to := chunk.Metadata[types.DocumentMetadataTo]; to != "" {
+			builder.WriteString(fmt.Sprintf("To: %s\n", to))
+		}
+		if len(chunk.HeadingPath) > 0 {
+			builder.WriteString(fmt.Sprintf("Section: %s\n", strings.Join(chunk.HeadingPath, " > ")))
+		}
+		if chunk.PageStart > 0 {
+			if chunk.PageEnd > chunk.PageStart {
+				builder.WriteString(fmt.Sprintf("Pages: %d-%d\n", chunk.PageStart, chunk.PageEnd))
+			} else {
+				builder.WriteString(fmt.Sprintf("Page: %d\n", chunk.PageStart))
+			}
+		}
+		if len(chunk.URLs) > 0 {
+			builder.WriteString(fmt.Sprintf("Links: %s\n", strings.Join(chunk.URLs, " ")))
+		}
+		builder.WriteString(fmt.Sprintf("Content: %s\n", expandChunkText(chunk, neighbours[chunk.Hash])))
 	}
 
 	// Return the final combined prompt
@@ -579,11 +610,10 @@ func MakePrompt(chunks []*types.Chunk, query string) string {
 }
 
 func WritePromptLog(prompt string) error {
-	home, err := os.UserHomeDir()
+	path, err := util.DataPath(PromptLogFile)
 	if err != nil {
-		return fmt.Errorf("unable to get user home directory: %w", err)
+		return err
 	}
-	path := filepath.Join(home, PromptLogFile)
 	// Open the file for writing, creating it if it doesn't exist
 	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -594,80 +624,3 @@ func WritePromptLog(prompt string) error {
 	_, err = file.WriteString("\n===\n" + prompt + "\n")
 	return err
 }
-
-// Struct to define the request payload
-type EmbedRequestPayload struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-}
-
-// Struct to define the API response format
-type EmbedApiResponse struct {
-	Embedding []float32 `json:"embedding"`
-}
-
-// Function to call ollama model
-func EmbedFromModel(prompt string) (*EmbedApiResponse, error) {
-	// URL of the API endpoint
-	url := fmt.Sprintf("http://%s/api/embeddings", OllamaHost)
-
-	// Create the payload
-	payload := EmbedRequestPayload{
-		Model:  embeddingsModelName,
-		Prompt: prompt,
-	}
-
-	// Marshal the payload into JSON
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create a new HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-
-	// Set the appropriate headers
-	req.Header.Set("Content-Type", "application/json")
-
-	maxRetries := 3
-	initialBackoff := 2 * time.Second
-	var response *http.Response
-	var responseData []byte
-	for i := 0; i < maxRetries; i++ {
-		// Make the HTTP request using the default client
-		response, err = httpClient.Do(req)
-		if err != nil {
-			// Check if the error is a timeout
-			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-				// Wait for a backoff period before retrying
-				time.Sleep(initialBackoff * time.Duration(i+1))
-				continue
-			}
-			return nil, err
-		}
-		defer response.Body.Close()
-
-		// Read the response body
-		responseData, err = io.ReadAll(response.Body)
-		if err != nil {
-			return nil, err
-		}
-		break
-	}
-
-	if response == nil {
-		return nil, errors.New("failed to get a response after retries")
-	}
-
-	// Unmarshal JSON data into ApiResponse struct
-	var apiResponse EmbedApiResponse
-	if err := json.Unmarshal(responseData, &apiResponse); err != nil {
-		return nil, err
-	}
-
-	// Return the structured response
-	return &apiResponse, nil
-}
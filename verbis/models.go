@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/verbis-ai/verbis/verbis/util"
+)
+
+// ErrModelNotFound is returned when a model is not installed in ollama
+var ErrModelNotFound = errors.New("model not found")
+
+// generationModelLock guards generationModelName, which can be switched
+// through the API while prompts are answered
+var generationModelLock sync.RWMutex
+
+// GenerationModel returns the model used to answer prompts
+func GenerationModel() string {
+	generationModelLock.RLock()
+	defer generationModelLock.RUnlock()
+	return generationModelName
+}
+
+func SetGenerationModel(name string) {
+	generationModelLock.Lock()
+	defer generationModelLock.Unlock()
+	generationModelName = name
+}
+
+// ModelInfo describes a model installed in ollama
+type ModelInfo struct {
+	Name              string    `json:"name"`
+	Size              int64     `json:"size"` // Disk usage in bytes
+	Digest            string    `json:"digest"`
+	ModifiedAt        time.Time `json:"modified_at"`
+	Family            string    `json:"family,omitempty"`
+	ParameterSize     string    `json:"parameter_size,omitempty"`
+	QuantizationLevel string    `json:"quantization_level,omitempty"`
+}
+
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name       string    `json:"name"`
+		Size       int64     `json:"size"`
+		Digest     string    `json:"digest"`
+		ModifiedAt time.Time `json:"modified_at"`
+		Details    struct {
+			Family            string `json:"family"`
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+	} `json:"models"`
+}
+
+// listModels returns the models installed in ollama
+func listModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/api/tags", OllamaHost), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list models: unexpected status %s", resp.Status)
+	}
+	var tags ollamaTagsResponse
+	err = json.NewDecoder(resp.Body).Decode(&tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode models: %v", err)
+	}
+	models := []ModelInfo{}
+	for _, m := range tags.Models {
+		models = append(models, ModelInfo{
+			Name:              m.Name,
+			Size:              m.Size,
+			Digest:            m.Digest,
+			ModifiedAt:        m.ModifiedAt,
+			Family:            m.Details.Family,
+			ParameterSize:     m.Details.ParameterSize,
+			QuantizationLevel: m.Details.QuantizationLevel,
+		})
+	}
+	return models, nil
+}
+
+// sameModel tells whether two model names refer to the same model, ollama
+// adding the latest tag to names without one
+func sameModel(a, b string) bool {
+	if !strings.Contains(a, ":") {
+		a += ":latest"
+	}
+	if !strings.Contains(b, ":") {
+		b += ":latest"
+	}
+	return a == b
+}
+
+func hasModel(models []ModelInfo, name string) bool {
+	for _, m := range models {
+		if sameModel(m.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelProgress is a status update sent by ollama while pulling or creating
+// a model. Total and Completed are in bytes, and only set while downloading.
+type ModelProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// pullModel downloads a model from the ollama library, passing status
+// updates to progress if not nil
+func pullModel(ctx context.Context, name string, progress func(ModelProgress)) error {
+	return ollamaStream(ctx, "/api/pull", map[string]interface{}{
+		"name":   name,
+		"stream": true,
+	}, progress)
+}
+
+type ModelCreateRequest struct {
+	Name      string `json:"name"`
+	Modelfile string `json:"modelfile"`
+	Stream    bool   `json:"stream"`
+}
+
+// createModel builds a model from the contents of a Modelfile
+func createModel(ctx context.Context, name, modelfile string, progress func(ModelProgress)) error {
+	return ollamaStream(ctx, "/api/create", ModelCreateRequest{
+		Name:      name,
+		Modelfile: modelfile,
+		Stream:    true,
+	}, progress)
+}
+
+// createCustomModel builds one of the custom models shipped as a Modelfile
+// in the dist directory
+func createCustomModel(ctx context.Context, name string, progress func(ModelProgress)) error {
+	path, err := util.GetDistPath()
+	if err != nil {
+		return fmt.Errorf("failed to get dist path: %v", err)
+	}
+
+	modelFileName := fmt.Sprintf("Modelfile.%s", name)
+	modelFileData, err := os.ReadFile(filepath.Join(path, modelFileName))
+	if err != nil {
+		return fmt.Errorf("unable to read modelfile: %v", err)
+	}
+
+	log.Printf("Modelfile contents: %s", string(modelFileData))
+	return createModel(ctx, name, string(modelFileData), progress)
+}
+
+// ollamaStream posts a request to an ollama endpoint answering with a stream
+// of progress updates, and returns nil only if the last one is "success"
+func ollamaStream(ctx context.Context, endpoint string, payload interface{}, progress func(ModelProgress)) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s%s", OllamaHost, endpoint), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Without timeout, as downloads take up to hours
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	status := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var p ModelProgress
+		err = json.Unmarshal(scanner.Bytes(), &p)
+		if err != nil {
+			return fmt.Errorf("failed to decode progress: %v", err)
+		}
+		if p.Error != "" {
+			return errors.New(p.Error)
+		}
+		status = p.Status
+		if progress != nil {
+			progress(p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read progress: %v", err)
+	}
+	if status != "success" {
+		return fmt.Errorf("ended with status %q", status)
+	}
+	return nil
+}
+
+// deleteModel removes a model and the layers only it uses from disk
+func deleteModel(ctx context.Context, name string) error {
+	jsonData, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("http://%s/api/delete", OllamaHost), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrModelNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// logProgress returns a progress function logging each new status of the
+// model, rather than every downloaded chunk
+func logProgress(name string) func(ModelProgress) {
+	last := ""
+	return func(p ModelProgress) {
+		if p.Status == last {
+			return
+		}
+		last = p.Status
+		log.Printf("Model %s: %s", name, p.Status)
+	}
+}
+
+func initModels(ctx context.Context, models []string) error {
+	for _, modelName := range models {
+		if IsCustomModel(modelName) {
+			err := createCustomModel(ctx, modelName, logProgress(modelName))
+			if err != nil {
+				return fmt.Errorf("failed to create model %s: %v", modelName, err)
+			}
+		} else {
+			err := pullModel(ctx, modelName, logProgress(modelName))
+			if err != nil {
+				return fmt.Errorf("failed to pull model %s: %v", modelName, err)
+			}
+		}
+	}
+	return nil
+}
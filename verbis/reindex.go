@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/verbis-ai/verbis/verbis/store"
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// Number of chunks embedded at once while building an index
+const reindexPageSize = 100
+
+// Reindexer builds an embedding index for a new model from the stored chunks
+// in the background, without fetching sources again, and switches search to
+// it once complete
+type Reindexer struct {
+	store types.Store
+
+	lock    sync.Mutex
+	running string // ID of the index being built, if any
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewReindexer(st types.Store) *Reindexer {
+	return &Reindexer{store: st}
+}
+
+// Start creates an index for the model and builds it in the background
+func (r *Reindexer) Start(ctx context.Context, model string) (*types.EmbeddingIndex, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.running != "" {
+		return nil, store.ErrEmbeddingIndexBuilding
+	}
+	// The model of the active index is allowed if it was vectorized by an
+	// older version, to embed its chunks as queries are
+	active := r.store.ActiveEmbeddingIndex()
+	if sameModel(model, active.Model) && active.Prefixed {
+		return nil, fmt.Errorf("model %s is already used by the active index", model)
+	}
+
+	index, err := r.store.CreateEmbeddingIndex(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+	// The build updates its own copy, read through ListEmbeddingIndexes
+	build := *index
+	r.start(ctx, &build)
+	return index, nil
+}
+
+// Resume builds the index left building when Verbis last stopped, from the
+// last chunk copied
+func (r *Reindexer) Resume(ctx context.Context) error {
+	indexes, err := r.store.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, index := range indexes {
+		if index.InProgress() && r.running == "" {
+			log.Printf("Resuming build of embedding index %s", index.ClassName)
+			r.start(ctx, index)
+		}
+	}
+	return nil
+}
+
+// start runs the build of the index, with the lock held
+func (r *Reindexer) start(ctx context.Context, index *types.EmbeddingIndex) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	r.running, r.cancel, r.done = index.ID, cancel, done
+	go func() {
+		defer close(done)
+		defer cancel()
+		r.run(jobCtx, index)
+
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.running, r.cancel, r.done = "", nil, nil
+	}()
+}
+
+// Delete deletes an index other than the active one, cancelling its build
+// if running
+func (r *Reindexer) Delete(ctx context.Context, id string) error {
+	r.lock.Lock()
+	if r.running == id {
+		cancel, done := r.cancel, r.done
+		r.lock.Unlock()
+		cancel()
+		<-done
+	} else {
+		r.lock.Unlock()
+	}
+	return r.store.DeleteEmbeddingIndex(ctx, id)
+}
+
+func (r *Reindexer) run(ctx context.Context, index *types.EmbeddingIndex) {
+	err := r.build(ctx, index)
+	if ctx.Err() != nil {
+		// Either deleted, or resumed on next boot
+		log.Printf("Build of embedding index %s stopped", index.ClassName)
+		return
+	}
+	if errors.Is(err, store.ErrEmbeddingIndexFailed) {
+		// Already recorded when embedding synced chunks
+		log.Printf("Build of embedding index %s failed while syncing", index.ClassName)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to build embedding index %s: %s", index.ClassName, err)
+		err = r.store.FailEmbeddingIndex(ctx, index, err)
+		if err != nil {
+			log.Printf("Failed to record failure of embedding index %s: %s", index.ClassName, err)
+		}
+	}
+}
+
+func (r *Reindexer) build(ctx context.Context, index *types.EmbeddingIndex) error {
+	log.Printf("Building embedding index %s for model %s", index.ClassName, index.Model)
+	err := initModels(ctx, []string{index.Model})
+	if err != nil {
+		return err
+	}
+
+	if index.Status == types.EmbeddingIndexPending {
+		// From now on, synced chunks are also written to the index, so that
+		// those missed by the copy are not lost
+		index.Status = types.EmbeddingIndexBuilding
+		err = r.store.UpdateEmbeddingIndex(ctx, index)
+		if err != nil {
+			return err
+		}
+		index.Total, err = r.store.CountChunks(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		n, err := r.store.CopyChunks(ctx, index, reindexPageSize)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		// Chunks synced meanwhile are copied too
+		index.Done += n
+		index.Total = max(index.Total, index.Done)
+		err = r.store.UpdateEmbeddingIndex(ctx, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.store.ActivateEmbeddingIndex(ctx, index.ID)
+}
@@ -0,0 +1,693 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"github.com/weaviate/weaviate/entities/models"
+
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// Chunks are stored in one class per embeddings model, listed in the
+// EmbeddingIndex class. Search reads the active one, and writes go to every
+// live one, so that an index being built or kept for rollback stays in sync.
+const embeddingIndexClassName = "EmbeddingIndex"
+
+var (
+	ErrEmbeddingIndexBuilding = errors.New("an embedding index is already being built")
+	ErrEmbeddingIndexNotFound = errors.New("embedding index not found")
+	ErrNoPreviousIndex        = errors.New("no previous embedding index to roll back to")
+	ErrEmbeddingIndexActive   = errors.New("the active embedding index cannot be deleted")
+	ErrEmbeddingIndexFailed   = errors.New("the embedding index failed while being built")
+)
+
+var embeddingIndexFields = []graphql.Field{
+	{Name: "class_name"},
+	{Name: "model"},
+	{Name: "dimensions"},
+	{Name: "prefixed"},
+	{Name: "status"},
+	{Name: "total"},
+	{Name: "done"},
+	{Name: "cursor"},
+	{Name: "error"},
+	{Name: "created_at"},
+	{Name: "activated_at"},
+	{Name: "_additional", Fields: []graphql.Field{{Name: "id"}}},
+}
+
+func embeddingIndexProperties(index *types.EmbeddingIndex) map[string]interface{} {
+	props := map[string]interface{}{
+		"class_name":   index.ClassName,
+		"model":        index.Model,
+		"prefixed":     index.Prefixed,
+		"status":       string(index.Status),
+		"total":        index.Total,
+		"done":         index.Done,
+		"cursor":       index.Cursor,
+		"error":        index.Error,
+		"created_at":   index.CreatedAt,
+		"activated_at": index.ActivatedAt,
+	}
+	// Left as recorded by AddVectors while the index is being built from
+	// a copy without them
+	if index.Dimensions != 0 {
+		props["dimensions"] = index.Dimensions
+	}
+	return props
+}
+
+func parseEmbeddingIndex(m map[string]interface{}) *types.EmbeddingIndex {
+	index := &types.EmbeddingIndex{}
+	index.ID, _ = m["_additional"].(map[string]interface{})["id"].(string)
+	index.ClassName, _ = m["class_name"].(string)
+	index.Model, _ = m["model"].(string)
+	index.Dimensions = parseInt(m["dimensions"])
+	index.Prefixed, _ = m["prefixed"].(bool)
+	status, _ := m["status"].(string)
+	index.Status = types.EmbeddingIndexStatus(status)
+	index.Total = parseInt(m["total"])
+	index.Done = parseInt(m["done"])
+	index.Cursor, _ = m["cursor"].(string)
+	index.Error, _ = m["error"].(string)
+	index.CreatedAt = parseDate(m["created_at"])
+	index.ActivatedAt = parseDate(m["activated_at"])
+	return index
+}
+
+// chunkClass returns the class of chunks searched
+func (w *WeaviateStore) chunkClass() string {
+	w.indexLock.RLock()
+	defer w.indexLock.RUnlock()
+	return w.activeIndex.ClassName
+}
+
+// liveEmbeddingIndexes returns the indexes that chunks are written to
+func (w *WeaviateStore) liveEmbeddingIndexes() []*types.EmbeddingIndex {
+	w.indexLock.RLock()
+	defer w.indexLock.RUnlock()
+	indexes := []*types.EmbeddingIndex{}
+	for _, index := range w.liveIndexes {
+		index := *index
+		indexes = append(indexes, &index)
+	}
+	return indexes
+}
+
+func (w *WeaviateStore) liveChunkClasses() []string {
+	classNames := []string{}
+	for _, index := range w.liveEmbeddingIndexes() {
+		classNames = append(classNames, index.ClassName)
+	}
+	return classNames
+}
+
+func (w *WeaviateStore) isLiveIndex(id string) bool {
+	w.indexLock.RLock()
+	defer w.indexLock.RUnlock()
+	for _, index := range w.liveIndexes {
+		if index.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveEmbeddingIndex returns the index searched, whose model must embed
+// queries
+func (w *WeaviateStore) ActiveEmbeddingIndex() *types.EmbeddingIndex {
+	w.indexLock.RLock()
+	defer w.indexLock.RUnlock()
+	index := *w.activeIndex
+	return &index
+}
+
+// loadIndexes reads the indexes, and sets the active and live ones in memory.
+// If a switch was interrupted and left several indexes active, the last
+// activated one is searched.
+func (w *WeaviateStore) loadIndexes(ctx context.Context) ([]*types.EmbeddingIndex, error) {
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var active *types.EmbeddingIndex
+	live := []*types.EmbeddingIndex{}
+	for _, index := range indexes {
+		if index.Status == types.EmbeddingIndexActive && (active == nil || index.ActivatedAt.After(active.ActivatedAt)) {
+			active = index
+		}
+		if index.Live() {
+			live = append(live, index)
+		}
+	}
+	if active == nil {
+		return nil, errors.New("no active embedding index")
+	}
+
+	w.indexLock.Lock()
+	defer w.indexLock.Unlock()
+	w.activeIndex = active
+	w.liveIndexes = live
+	return indexes, nil
+}
+
+func (w *WeaviateStore) ListEmbeddingIndexes(ctx context.Context) ([]*types.EmbeddingIndex, error) {
+	resp, err := w.client.GraphQL().Get().
+		WithClassName(embeddingIndexClassName).
+		WithFields(embeddingIndexFields...).
+		WithSort(graphql.Sort{Path: []string{"created_at"}, Order: graphql.Asc}).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list embedding indexes: %v", err)
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("failed to list embedding indexes: %s", resp.Errors[0].Message)
+	}
+
+	indexes := []*types.EmbeddingIndex{}
+	if resp.Data["Get"] == nil {
+		return indexes, nil
+	}
+	objs, _ := resp.Data["Get"].(map[string]interface{})[embeddingIndexClassName].([]interface{})
+	for _, obj := range objs {
+		indexes = append(indexes, parseEmbeddingIndex(obj.(map[string]interface{})))
+	}
+	return indexes, nil
+}
+
+func (w *WeaviateStore) getEmbeddingIndex(ctx context.Context, id string) (*types.EmbeddingIndex, error) {
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if index.ID == id {
+			return index, nil
+		}
+	}
+	return nil, ErrEmbeddingIndexNotFound
+}
+
+// UsesWeaviateVectorizer tells whether the class of an index was created by
+// an older version with a weaviate vectorizer. Vectorizers cannot be changed,
+// and such a class cannot be written to without the vectorizer module, even
+// with the vectors given.
+func (w *WeaviateStore) UsesWeaviateVectorizer(ctx context.Context) (bool, error) {
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, index := range indexes {
+		if index.Status == types.EmbeddingIndexFailed {
+			continue
+		}
+		class, err := w.client.Schema().ClassGetter().WithClassName(index.ClassName).Do(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to get class %s: %v", index.ClassName, err)
+		}
+		if class.Vectorizer != "" && class.Vectorizer != "none" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateEmbeddingIndex creates an empty chunk class vectorized with the given
+// model. Once the model is pulled and the index set to building, it is filled
+// with CopyChunks and activated.
+func (w *WeaviateStore) CreateEmbeddingIndex(ctx context.Context, model string) (*types.EmbeddingIndex, error) {
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if index.InProgress() {
+			return nil, ErrEmbeddingIndexBuilding
+		}
+	}
+
+	index := &types.EmbeddingIndex{
+		ID:        uuid.NewString(),
+		ClassName: fmt.Sprintf("%s_%d", chunkClassName, time.Now().Unix()),
+		Model:     model,
+		Prefixed:  true,
+		Status:    types.EmbeddingIndexPending,
+		CreatedAt: time.Now(),
+	}
+	err = w.createOrUpdateClass(ctx, chunkClassSchema(index.ClassName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create class %s: %v", index.ClassName, err)
+	}
+	_, err = w.client.Data().Creator().
+		WithClassName(embeddingIndexClassName).
+		WithID(index.ID).
+		WithProperties(embeddingIndexProperties(index)).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add embedding index: %v", err)
+	}
+	log.Printf("Created embedding index %s for model %s", index.ClassName, model)
+
+	_, err = w.loadIndexes(ctx)
+	return index, err
+}
+
+// UpdateEmbeddingIndex saves the progress of a build, unless the index was
+// failed meanwhile by AddVectors
+func (w *WeaviateStore) UpdateEmbeddingIndex(ctx context.Context, index *types.EmbeddingIndex) error {
+	w.chunksLock.Lock()
+	defer w.chunksLock.Unlock()
+	stored, err := w.getEmbeddingIndex(ctx, index.ID)
+	if err != nil {
+		return err
+	}
+	if stored.Status == types.EmbeddingIndexFailed {
+		return ErrEmbeddingIndexFailed
+	}
+	err = w.saveEmbeddingIndex(ctx, index)
+	if err != nil {
+		return err
+	}
+	_, err = w.loadIndexes(ctx)
+	return err
+}
+
+// CountChunks returns the number of chunks in the active index
+func (w *WeaviateStore) CountChunks(ctx context.Context) (int, error) {
+	className := w.chunkClass()
+	resp, err := w.client.GraphQL().Aggregate().
+		WithClassName(className).
+		WithFields(graphql.Field{Name: "meta", Fields: []graphql.Field{{Name: "count"}}}).
+		Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count chunks: %v", err)
+	}
+	if resp.Data["Aggregate"] == nil {
+		return 0, nil
+	}
+	res, _ := resp.Data["Aggregate"].(map[string]interface{})[className].([]interface{})
+	if len(res) == 0 {
+		return 0, nil
+	}
+	meta, _ := res[0].(map[string]interface{})["meta"].(map[string]interface{})
+	return parseInt(meta["count"]), nil
+}
+
+// CopyChunks copies up to limit chunks following index.Cursor from the active
+// index into the given one, embedding them with its model. It
+// advances the cursor and returns the number of chunks copied, 0 once all
+// have been.
+func (w *WeaviateStore) CopyChunks(ctx context.Context, index *types.EmbeddingIndex, limit int) (int, error) {
+	// Chunks are not written or deleted while a page is copied, so that a
+	// chunk deleted after being read is not recreated
+	w.chunksLock.Lock()
+	defer w.chunksLock.Unlock()
+	if !w.isLiveIndex(index.ID) {
+		return 0, ErrEmbeddingIndexFailed
+	}
+
+	getter := w.client.Data().ObjectsGetter().
+		WithClassName(w.chunkClass()).
+		WithLimit(limit)
+	if index.Cursor != "" {
+		getter = getter.WithAfter(index.Cursor)
+	}
+	objs, err := getter.Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunks: %v", err)
+	}
+	if len(objs) == 0 {
+		return 0, nil
+	}
+
+	// Chunks keep their ID in every index, so that the cursor does not
+	// depend on the index read
+	texts := []string{}
+	for _, obj := range objs {
+		props, _ := obj.Properties.(map[string]interface{})
+		title, _ := props["document_title"].(string)
+		text, _ := props["chunk"].(string)
+		texts = append(texts, embeddingText(title, text))
+	}
+	vectors, err := w.embed(ctx, index, texts)
+	if err != nil {
+		return 0, err
+	}
+	copies := []*models.Object{}
+	for i, obj := range objs {
+		copies = append(copies, &models.Object{
+			Class:      index.ClassName,
+			ID:         obj.ID,
+			Properties: obj.Properties,
+			Vector:     vectors[i],
+		})
+	}
+	resp, err := w.client.Batch().ObjectsBatcher().WithObjects(copies...).Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write chunks: %v", err)
+	}
+	err = batchError(resp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write chunks: %v", err)
+	}
+
+	index.Cursor = objs[len(objs)-1].ID.String()
+	return len(objs), nil
+}
+
+// embeddingText returns the text of a chunk that is embedded, including the
+// title of its document. Weaviate embedded the class name and text properties
+// instead, so chunks synced into an index of an older version do not match
+// its stored ones until it is embedded again on boot.
+func embeddingText(title, chunk string) string {
+	if title == "" {
+		return chunk
+	}
+	return title + "\n\n" + chunk
+}
+
+// embed computes the vectors of chunks to write to an index, recording their
+// dimensions on the first write so that vectors of another size are rejected
+func (w *WeaviateStore) embed(ctx context.Context, index *types.EmbeddingIndex, texts []string) ([][]float32, error) {
+	vectors, err := w.embedder.EmbedDocuments(ctx, index, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed chunks for index %s: %v", index.ClassName, err)
+	}
+	if index.Dimensions != 0 || len(vectors) == 0 {
+		return vectors, nil
+	}
+
+	index.Dimensions = len(vectors[0])
+	err = w.client.Data().Updater().
+		WithID(index.ID).
+		WithClassName(embeddingIndexClassName).
+		WithProperties(map[string]interface{}{"dimensions": index.Dimensions}).
+		WithMerge().
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record dimensions of embedding index %s: %v", index.ClassName, err)
+	}
+	log.Printf("Embedding index %s has %d dimensions", index.ClassName, index.Dimensions)
+	_, err = w.loadIndexes(ctx)
+	return vectors, err
+}
+
+// storedDimensions returns the dimensions of the vectors stored in a class,
+// 0 if it is empty
+func (w *WeaviateStore) storedDimensions(ctx context.Context, className string) (int, error) {
+	objs, err := w.client.Data().ObjectsGetter().
+		WithClassName(className).
+		WithLimit(1).
+		WithVector().
+		Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunks of %s: %v", className, err)
+	}
+	if len(objs) == 0 {
+		return 0, nil
+	}
+	return len(objs[0].Vector), nil
+}
+
+// batchError returns the first error of the objects of a batch
+func batchError(resp []models.ObjectsGetResponse) error {
+	for _, obj := range resp {
+		if obj.Result == nil || obj.Result.Errors == nil {
+			continue
+		}
+		msgs := []string{}
+		for _, e := range obj.Result.Errors.Error {
+			msgs = append(msgs, e.Message)
+		}
+		if len(msgs) > 0 {
+			return fmt.Errorf("object %s: %s", obj.ID, strings.Join(msgs, ", "))
+		}
+	}
+	return nil
+}
+
+// ActivateEmbeddingIndex switches search to a built index. The active index
+// is kept for rollback, replacing the previous one. The target is promoted
+// first, so that an interrupted switch still leaves an active index.
+func (w *WeaviateStore) ActivateEmbeddingIndex(ctx context.Context, id string) error {
+	// Not failed by AddVectors while switching
+	w.chunksLock.Lock()
+	defer w.chunksLock.Unlock()
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	var target *types.EmbeddingIndex
+	for _, index := range indexes {
+		if index.ID == id {
+			target = index
+		}
+	}
+	if target == nil {
+		return ErrEmbeddingIndexNotFound
+	}
+	if target.Status == types.EmbeddingIndexFailed {
+		return ErrEmbeddingIndexFailed
+	}
+	if target.Status != types.EmbeddingIndexBuilding {
+		return fmt.Errorf("embedding index %s is %s", target.ClassName, target.Status)
+	}
+
+	target.Status = types.EmbeddingIndexActive
+	target.ActivatedAt = time.Now()
+	err = w.saveEmbeddingIndex(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		switch {
+		case index.Status == types.EmbeddingIndexPrevious:
+			err = w.deleteEmbeddingIndex(ctx, index)
+		case index.Status == types.EmbeddingIndexActive && index.ID != target.ID:
+			index.Status = types.EmbeddingIndexPrevious
+			err = w.saveEmbeddingIndex(ctx, index)
+		}
+		if err != nil {
+			_, loadErr := w.loadIndexes(ctx)
+			return errors.Join(err, loadErr)
+		}
+	}
+	_, err = w.loadIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Activated embedding index %s for model %s", target.ClassName, target.Model)
+	return nil
+}
+
+// RollbackEmbeddingIndex switches search back to the previous index
+func (w *WeaviateStore) RollbackEmbeddingIndex(ctx context.Context) error {
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	var previous *types.EmbeddingIndex
+	for _, index := range indexes {
+		if index.Status == types.EmbeddingIndexPrevious && (previous == nil || index.ActivatedAt.After(previous.ActivatedAt)) {
+			previous = index
+		}
+	}
+	if previous == nil {
+		return ErrNoPreviousIndex
+	}
+
+	// Promoted before the active index is demoted, as in
+	// ActivateEmbeddingIndex
+	previous.Status = types.EmbeddingIndexActive
+	previous.ActivatedAt = time.Now()
+	err = w.saveEmbeddingIndex(ctx, previous)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Status != types.EmbeddingIndexActive || index.ID == previous.ID {
+			continue
+		}
+		index.Status = types.EmbeddingIndexPrevious
+		err = w.saveEmbeddingIndex(ctx, index)
+		if err != nil {
+			_, loadErr := w.loadIndexes(ctx)
+			return errors.Join(err, loadErr)
+		}
+	}
+	_, err = w.loadIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Rolled back to embedding index %s for model %s", previous.ClassName, previous.Model)
+	return nil
+}
+
+// DeleteEmbeddingIndex drops an index other than the active one, and its
+// chunks
+func (w *WeaviateStore) DeleteEmbeddingIndex(ctx context.Context, id string) error {
+	index, err := w.getEmbeddingIndex(ctx, id)
+	if err != nil {
+		return err
+	}
+	if index.Status == types.EmbeddingIndexActive {
+		return ErrEmbeddingIndexActive
+	}
+	err = w.deleteEmbeddingIndex(ctx, index)
+	if err != nil {
+		return err
+	}
+	_, err = w.loadIndexes(ctx)
+	return err
+}
+
+// FailEmbeddingIndex records the error of an index that could not be built,
+// and drops its chunks
+func (w *WeaviateStore) FailEmbeddingIndex(ctx context.Context, index *types.EmbeddingIndex, buildErr error) error {
+	err := w.client.Schema().ClassDeleter().WithClassName(index.ClassName).Do(ctx)
+	if err != nil {
+		log.Printf("Failed to delete class %s: %v", index.ClassName, err)
+	}
+	index.Status = types.EmbeddingIndexFailed
+	index.Error = buildErr.Error()
+	err = w.saveEmbeddingIndex(ctx, index)
+	if err != nil {
+		return err
+	}
+	_, err = w.loadIndexes(ctx)
+	return err
+}
+
+func (w *WeaviateStore) saveEmbeddingIndex(ctx context.Context, index *types.EmbeddingIndex) error {
+	err := w.client.Data().Updater().
+		WithID(index.ID).
+		WithClassName(embeddingIndexClassName).
+		WithProperties(embeddingIndexProperties(index)).
+		WithMerge().
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update embedding index %s: %v", index.ClassName, err)
+	}
+	return nil
+}
+
+func (w *WeaviateStore) deleteEmbeddingIndex(ctx context.Context, index *types.EmbeddingIndex) error {
+	if index.Status != types.EmbeddingIndexFailed {
+		err := w.client.Schema().ClassDeleter().WithClassName(index.ClassName).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete class %s: %v", index.ClassName, err)
+		}
+	}
+	err := w.client.Data().Deleter().
+		WithClassName(embeddingIndexClassName).
+		WithID(index.ID).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete embedding index %s: %v", index.ClassName, err)
+	}
+	log.Printf("Deleted embedding index %s for model %s", index.ClassName, index.Model)
+	return nil
+}
+
+// initEmbeddingIndexes creates the class of indexes, registers the chunk
+// class of older versions as the active index, and makes sure that the
+// class of each live index exists
+func (w *WeaviateStore) initEmbeddingIndexes(ctx context.Context, force bool) error {
+	if force {
+		indexes, _ := w.ListEmbeddingIndexes(ctx)
+		for _, index := range indexes {
+			w.client.Schema().ClassDeleter().WithClassName(index.ClassName).Do(ctx)
+		}
+		w.client.Schema().ClassDeleter().WithClassName(embeddingIndexClassName).Do(ctx)
+		w.client.Schema().ClassDeleter().WithClassName(chunkClassName).Do(ctx)
+	}
+
+	err := w.createOrUpdateClass(ctx, &models.Class{
+		Class:      embeddingIndexClassName,
+		Vectorizer: "none",
+		Properties: []*models.Property{
+			{Name: "class_name", DataType: []string{"text"}},
+			{Name: "model", DataType: []string{"text"}},
+			{Name: "dimensions", DataType: []string{"int"}},
+			{Name: "prefixed", DataType: []string{"boolean"}},
+			{Name: "status", DataType: []string{"text"}},
+			{Name: "total", DataType: []string{"int"}},
+			{Name: "done", DataType: []string{"int"}},
+			{Name: "cursor", DataType: []string{"text"}},
+			{Name: "error", DataType: []string{"text"}},
+			{Name: "created_at", DataType: []string{"date"}},
+			{Name: "activated_at", DataType: []string{"date"}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create embedding index class: %v", err)
+	}
+
+	indexes, err := w.ListEmbeddingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		// The chunk class exists if it was vectorized by weaviate in an older
+		// version, otherwise it is created below for the embedder
+		exists, err := w.client.Schema().ClassExistenceChecker().WithClassName(chunkClassName).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check for chunk class: %v", err)
+		}
+		index := &types.EmbeddingIndex{
+			ID:          uuid.NewString(),
+			ClassName:   chunkClassName,
+			Model:       w.embeddingsModelName,
+			Prefixed:    !exists,
+			Status:      types.EmbeddingIndexActive,
+			CreatedAt:   time.Now(),
+			ActivatedAt: time.Now(),
+		}
+		_, err = w.client.Data().Creator().
+			WithClassName(embeddingIndexClassName).
+			WithID(index.ID).
+			WithProperties(embeddingIndexProperties(index)).
+			Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to add embedding index: %v", err)
+		}
+	}
+
+	indexes, err = w.loadIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Status == types.EmbeddingIndexFailed {
+			continue
+		}
+		err = w.createOrUpdateClass(ctx, chunkClassSchema(index.ClassName))
+		if err != nil {
+			return fmt.Errorf("failed to create class %s: %v", index.ClassName, err)
+		}
+		if index.Dimensions != 0 {
+			continue
+		}
+		// Indexes of older versions were vectorized by weaviate
+		index.Dimensions, err = w.storedDimensions(ctx, index.ClassName)
+		if err != nil {
+			return err
+		}
+		if index.Dimensions != 0 {
+			err = w.saveEmbeddingIndex(ctx, index)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = w.loadIndexes(ctx)
+	return err
+}
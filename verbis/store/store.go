@@ -8,6 +8,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-openapi/strfmt"
@@ -25,6 +26,9 @@ var (
 	chunkClassName        = "VerbisChunk"
 	documentClassName     = "Document"
 	stateClassName        = "ConnectorState"
+	settingsClassName     = "ConnectorSettings"
+	syncRunClassName      = "SyncRun"
+	syncErrorClassName    = "SyncError"
 	conversationClassName = "Conversation"
 	configClassName       = "Config"
 )
@@ -35,25 +39,57 @@ const (
 )
 
 type WeaviateStore struct {
-	client              *weaviate.Client
-	ollamaURL           string
+	client   *weaviate.Client
+	embedder types.Embedder
+	// Model of the chunk class of older versions, until indexes are recorded
 	embeddingsModelName string
+
+	indexLock   sync.RWMutex
+	activeIndex *types.EmbeddingIndex
+	liveIndexes []*types.EmbeddingIndex
+	// Held for writing while chunks are copied to a new index
+	chunksLock sync.RWMutex
 }
 
-func NewWeaviateStore(ollamaURL, embeddingsModelName string) types.Store {
+func NewWeaviateStore(embedder types.Embedder, embeddingsModelName string) types.Store {
 	return &WeaviateStore{
 		client:              GetWeaviateClient(),
-		ollamaURL:           ollamaURL,
+		embedder:            embedder,
 		embeddingsModelName: embeddingsModelName,
 	}
 }
 
+// WeaviateHost is the address weaviate listens on, set from the daemon config
+var WeaviateHost = "127.0.0.1:8088"
+
+// WeaviateAPIKey authenticates requests to weaviate, set on boot
+var WeaviateAPIKey = ""
+
 func GetWeaviateClient() *weaviate.Client {
 	// Initialize Weaviate client
-	return weaviate.New(weaviate.Config{
-		Host:   "localhost:8088",
+	config := weaviate.Config{
+		Host:   WeaviateHost,
 		Scheme: "http",
-	})
+	}
+	if WeaviateAPIKey != "" {
+		config.Headers = map[string]string{"Authorization": "Bearer " + WeaviateAPIKey}
+	}
+	return weaviate.New(config)
+}
+
+// chunkFields are the stored properties of a chunk returned by all queries
+var chunkFields = []graphql.Field{
+	{Name: "chunk"},
+	{Name: "hash"},
+	{Name: "documentid"},
+	{Name: "document_title"},
+	{Name: "ordinal"},
+	{Name: "page_start"},
+	{Name: "page_end"},
+	{Name: "heading_path"},
+	{Name: "start_offset"},
+	{Name: "end_offset"},
+	{Name: "urls"},
 }
 
 var ErrChunkNotFound = errors.New("chunk not found")
@@ -75,19 +111,15 @@ func (w *WeaviateStore) ChunkHashExists(ctx context.Context, hash string) (bool,
 }
 
 func (w *WeaviateStore) GetChunkByHash(ctx context.Context, hash string) (*types.Chunk, error) {
+	className := w.chunkClass()
 	where := filters.Where().
 		WithPath([]string{"hash"}).
 		WithOperator(filters.Equal).
 		WithValueString(hash)
 
 	resp, err := w.client.GraphQL().Get().
-		WithClassName(chunkClassName).
-		WithFields([]graphql.Field{
-			{Name: "hash"},
-			{Name: "documentid"},
-			{Name: "document_title"},
-			{Name: "chunk"},
-		}...).
+		WithClassName(className).
+		WithFields(chunkFields...).
 		WithWhere(where).
 		Do(ctx)
 	if err != nil {
@@ -99,7 +131,7 @@ func (w *WeaviateStore) GetChunkByHash(ctx context.Context, hash string) (*types
 	}
 
 	get := resp.Data["Get"].(map[string]interface{})
-	chunks, ok := get[chunkClassName].([]interface{})
+	chunks, ok := get[className].([]interface{})
 	if !ok || len(chunks) == 0 {
 		return nil, ErrChunkNotFound
 	}
@@ -116,6 +148,192 @@ func (w *WeaviateStore) GetChunkByHash(ctx context.Context, hash string) (*types
 	return parsedChunks[0], nil
 }
 
+// withChunkFields returns chunkFields followed by extra fields, without
+// modifying the shared slice
+func withChunkFields(extra ...graphql.Field) []graphql.Field {
+	fields := make([]graphql.Field, 0, len(chunkFields)+len(extra))
+	fields = append(fields, chunkFields...)
+	return append(fields, extra...)
+}
+
+// GetChunkNeighbours returns the chunks within window positions of each of the
+// given chunks in their document, in document order and keyed by the hash of
+// the chunk they surround. Chunks are located with one query, then the
+// neighbours of each document are fetched with one query.
+func (w *WeaviateStore) GetChunkNeighbours(ctx context.Context, chunks []*types.Chunk, window int) (map[string][]*types.Chunk, error) {
+	res := map[string][]*types.Chunk{}
+	if len(chunks) == 0 || window <= 0 {
+		return res, nil
+	}
+	hashes := []string{}
+	for _, chunk := range chunks {
+		hashes = append(hashes, chunk.Hash)
+	}
+
+	className := w.chunkClass()
+	resp, err := w.client.GraphQL().Get().
+		WithClassName(className).
+		WithFields([]graphql.Field{
+			{Name: "hash"},
+			{Name: "documentid"},
+			{Name: "ordinal"},
+		}...).
+		WithWhere(filters.Where().
+			WithPath([]string{"hash"}).
+			WithOperator(filters.ContainsAny).
+			WithValueText(hashes...)).
+		WithLimit(len(hashes)).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("failed to get chunks: %s", resp.Errors[0].Message)
+	}
+
+	// Ordinals of the chunks of each document
+	ordinals := map[string]map[string]int{}
+	if resp.Data["Get"] != nil {
+		found, _ := resp.Data["Get"].(map[string]interface{})[className].([]interface{})
+		for _, obj := range found {
+			c := obj.(map[string]interface{})
+			docid, _ := c["documentid"].(string)
+			hash, _ := c["hash"].(string)
+			ordinal, ok := c["ordinal"].(float64)
+			if docid == "" || !ok {
+				// Chunk stored before ordinals were recorded
+				continue
+			}
+			if ordinals[docid] == nil {
+				ordinals[docid] = map[string]int{}
+			}
+			ordinals[docid][hash] = int(ordinal)
+		}
+	}
+
+	for docid, docOrdinals := range ordinals {
+		neighbours, err := w.getChunksAround(ctx, docid, docOrdinals, window)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get neighbouring chunks: %v", err)
+		}
+		for hash, ordinal := range docOrdinals {
+			res[hash] = []*types.Chunk{}
+			for _, n := range neighbours {
+				if n.Ordinal != ordinal && n.Ordinal >= ordinal-window && n.Ordinal <= ordinal+window {
+					res[hash] = append(res[hash], n)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// getChunksAround returns the chunks of a document within window positions of
+// any of the given ordinals, sorted by ordinal
+func (w *WeaviateStore) getChunksAround(ctx context.Context, docid string, ordinals map[string]int, window int) ([]*types.Chunk, error) {
+	className := w.chunkClass()
+	ranges := []*filters.WhereBuilder{}
+	for _, ordinal := range ordinals {
+		ranges = append(ranges, filters.Where().
+			WithOperator(filters.And).
+			WithOperands([]*filters.WhereBuilder{
+				filters.Where().
+					WithPath([]string{"ordinal"}).
+					WithOperator(filters.GreaterThanEqual).
+					WithValueInt(int64(ordinal - window)),
+				filters.Where().
+					WithPath([]string{"ordinal"}).
+					WithOperator(filters.LessThanEqual).
+					WithValueInt(int64(ordinal + window)),
+			}))
+	}
+	where := filters.Where().
+		WithOperator(filters.And).
+		WithOperands([]*filters.WhereBuilder{
+			filters.Where().
+				WithPath([]string{"documentid"}).
+				WithOperator(filters.Equal).
+				WithValueText(docid),
+			filters.Where().
+				WithOperator(filters.Or).
+				WithOperands(ranges),
+		})
+
+	resp, err := w.client.GraphQL().Get().
+		WithClassName(className).
+		WithFields(chunkFields...).
+		WithWhere(where).
+		WithSort(graphql.Sort{Path: []string{"ordinal"}, Order: graphql.Asc}).
+		WithLimit(len(ordinals) * (2*window + 1)).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("%s", resp.Errors[0].Message)
+	}
+
+	if resp.Data["Get"] == nil {
+		return []*types.Chunk{}, nil
+	}
+	get := resp.Data["Get"].(map[string]interface{})
+	chunks, ok := get[className].([]interface{})
+	if !ok {
+		return []*types.Chunk{}, nil
+	}
+	return parseChunks(ctx, w.client, chunks, false)
+}
+
+// GetDocumentChunks returns all chunks of a document in document order,
+// including their raw content
+func (w *WeaviateStore) GetDocumentChunks(ctx context.Context, uniqueID string) ([]*types.Chunk, error) {
+	className := w.chunkClass()
+	docid, err := getDocumentIDFromUniqueID(ctx, w.client, uniqueID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get document ID: %v", err)
+	}
+	if docid == "" {
+		return nil, ErrDocumentNotFound
+	}
+
+	fields := withChunkFields(graphql.Field{Name: "raw_chunk"})
+	res := []*types.Chunk{}
+	pageSize := 100
+	for offset := 0; ; offset += pageSize {
+		resp, err := w.client.GraphQL().Get().
+			WithClassName(className).
+			WithFields(fields...).
+			WithWhere(filters.Where().
+				WithPath([]string{"documentid"}).
+				WithOperator(filters.Equal).
+				WithValueText(docid)).
+			WithSort(graphql.Sort{Path: []string{"ordinal"}, Order: graphql.Asc}).
+			WithLimit(pageSize).
+			WithOffset(offset).
+			Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Data["Get"] == nil {
+			break
+		}
+		get := resp.Data["Get"].(map[string]interface{})
+		chunks, ok := get[className].([]interface{})
+		if !ok || len(chunks) == 0 {
+			break
+		}
+		parsed, err := parseChunks(ctx, w.client, chunks, false)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, parsed...)
+		if len(chunks) < pageSize {
+			break
+		}
+	}
+	return res, nil
+}
+
 var ErrDocumentNotFound = errors.New("document not found")
 
 func IsErrDocumentNotFound(err error) bool {
@@ -145,10 +363,55 @@ func (w *WeaviateStore) GetDocument(ctx context.Context, uniqueID string) (*type
 		ConnectorType: docData["connectorType"].(string),
 		CreatedAt:     createdAt,
 		UpdatedAt:     updatedAt,
+		Metadata:      decodeStringMap(docData["metadata"]),
 	}
 	return doc, nil
 }
 
+// GetDocumentID returns the ID of the stored document with the given unique
+// ID, or an empty string if there is none
+func (w *WeaviateStore) GetDocumentID(ctx context.Context, uniqueID string) (string, error) {
+	return getDocumentIDFromUniqueID(ctx, w.client, uniqueID)
+}
+
+// ListDocumentIDs returns the unique IDs of all documents of a connector,
+// mapped to the IDs of the stored documents
+func (w *WeaviateStore) ListDocumentIDs(ctx context.Context, connectorID string) (map[string]string, error) {
+	res := map[string]string{}
+	pageSize := 1000
+	// Paginate with the object ID cursor rather than offset, which is capped
+	// by Weaviate. The cursor cannot be combined with a filter, so documents
+	// of other connectors are skipped here.
+	after := ""
+	for {
+		getter := w.client.Data().ObjectsGetter().
+			WithClassName(documentClassName).
+			WithLimit(pageSize)
+		if after != "" {
+			getter = getter.WithAfter(after)
+		}
+		objs, err := getter.Do(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list documents: %v", err)
+		}
+		for _, obj := range objs {
+			props, _ := obj.Properties.(map[string]interface{})
+			if props["connectorID"] != connectorID {
+				continue
+			}
+			uniqueID, ok := props["unique_id"].(string)
+			if !ok {
+				continue
+			}
+			res[uniqueID] = obj.ID.String()
+		}
+		if len(objs) < pageSize {
+			return res, nil
+		}
+		after = objs[len(objs)-1].ID.String()
+	}
+}
+
 func getDocumentIDFromUniqueID(ctx context.Context, client *weaviate.Client, uniqueID string) (string, error) {
 	where := filters.Where().
 		WithPath([]string{"unique_id"}).
@@ -206,7 +469,13 @@ func getDocumentIDFromUniqueID(ctx context.Context, client *weaviate.Client, uni
 }
 
 func (w *WeaviateStore) AddVectors(ctx context.Context, items []types.AddVectorItem) (*types.AddVectorResponse, error) {
+	w.chunksLock.RLock()
+	defer w.chunksLock.RUnlock()
+	indexes := w.liveEmbeddingIndexes()
 	objects := []*models.Object{}
+	chunks := []*models.Object{}
+	texts := []string{}
+	numDocs := 0
 
 	for _, item := range items {
 		// Look if a document with the same ID exists
@@ -231,25 +500,61 @@ func (w *WeaviateStore) AddVectors(ctx context.Context, items []types.AddVectorI
 					"connectorType": item.Document.ConnectorType,
 					"createdAt":     item.Document.CreatedAt.Format(time.RFC3339),
 					"updatedAt":     item.Document.UpdatedAt.Format(time.RFC3339),
+					"metadata":      encodeStringMap(item.Document.Metadata),
 				},
 			}
 			objects = append(objects, documentObj)
+			numDocs++
 		}
 
 		// TODO: if the provided document sourceURL is different from the stored one, update it
 
-		// Create a new chunk
-		chunkObj := &models.Object{
-			Class: chunkClassName,
-			ID:    strfmt.UUID(uuid.NewString()),
-			Properties: map[string]interface{}{
-				"chunk":          item.Chunk.Text,
-				"hash":           item.Chunk.Hash,
-				"documentid":     docID,
-				"document_title": item.Document.Name, // Stored both here and in document, to facilitate hybrid search
-			},
+		// Create a new chunk, with the same ID in every index
+		chunkID := strfmt.UUID(uuid.NewString())
+		chunkProperties := map[string]interface{}{
+			"chunk":          item.Chunk.Text,
+			"hash":           item.Chunk.Hash,
+			"documentid":     docID,
+			"document_title": item.Document.Name, // Stored both here and in document, to facilitate hybrid search
+			"ordinal":        item.Chunk.Ordinal,
+			"page_start":     item.Chunk.PageStart,
+			"page_end":       item.Chunk.PageEnd,
+			"heading_path":   item.Chunk.HeadingPath,
+			"start_offset":   item.Chunk.StartOffset,
+			"end_offset":     item.Chunk.EndOffset,
+			"raw_chunk":      item.Chunk.RawText,
+			"urls":           item.Chunk.URLs,
+		}
+		chunks = append(chunks, &models.Object{
+			ID:         chunkID,
+			Properties: chunkProperties,
+		})
+		texts = append(texts, embeddingText(item.Document.Name, item.Chunk.Text))
+	}
+
+	for _, index := range indexes {
+		vectors, err := w.embed(ctx, index, texts)
+		if err != nil && index.Status == types.EmbeddingIndexBuilding && ctx.Err() == nil {
+			// A broken model of a new index does not stop syncing into the
+			// active one
+			log.Printf("Failing build of embedding index %s: %v", index.ClassName, err)
+			failErr := w.FailEmbeddingIndex(ctx, index, err)
+			if failErr != nil {
+				log.Printf("Failed to record failure of embedding index %s: %v", index.ClassName, failErr)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, chunk := range chunks {
+			objects = append(objects, &models.Object{
+				Class:      index.ClassName,
+				ID:         chunk.ID,
+				Properties: chunk.Properties,
+				Vector:     vectors[i],
+			})
 		}
-		objects = append(objects, chunkObj)
 	}
 
 	_, err := w.client.Batch().ObjectsBatcher().WithObjects(objects...).Do(ctx)
@@ -259,7 +564,7 @@ func (w *WeaviateStore) AddVectors(ctx context.Context, items []types.AddVectorI
 
 	return &types.AddVectorResponse{
 		NumChunksAdded: len(items),
-		NumDocsAdded:   len(objects) - len(items), // Total set of objects created versus the known num of chunks
+		NumDocsAdded:   numDocs,
 	}, nil
 }
 
@@ -279,32 +584,29 @@ func getDocument(ctx context.Context, client *weaviate.Client, docid string) (ma
 	return docs[0].Properties.(map[string]interface{}), nil
 }
 
-// Search for a vector in Weaviate
-func (w *WeaviateStore) HybridSearch(ctx context.Context, query string, vector []float32) ([]*types.Chunk, error) {
+// Search for a vector in Weaviate, in the given index as the vector must have
+// been computed with its model
+func (w *WeaviateStore) HybridSearch(ctx context.Context, index *types.EmbeddingIndex, query string, vector []float32) ([]*types.Chunk, error) {
 	fmt.Println("Query vector length: ", len(vector))
 
-	_chunk_fields := []graphql.Field{
-		{Name: "chunk"},
-		{Name: "hash"},
-		{Name: "documentid"},
-		{Name: "document_title"},
-		{Name: "_additional", Fields: []graphql.Field{
+	_chunk_fields := withChunkFields(graphql.Field{
+		Name: "_additional", Fields: []graphql.Field{
 			{Name: "score"},
 			{Name: "explainScore"},
-		}},
-	}
+		},
+	})
 
 	log.Printf("Searching for chunks with query: %s\n", query)
 	hybrid := w.client.GraphQL().HybridArgumentBuilder().
 		WithQuery(query).
 		WithVector(vector).
 		WithAlpha(HybridSearchAlpha).
-		WithProperties([]string{"chunk", "document_title^2"}).
+		WithProperties([]string{"chunk", "document_title^2", "urls"}).
 		WithFusionType(graphql.RelativeScore)
 
 	resp, err := w.client.GraphQL().
 		Get().
-		WithClassName(chunkClassName).
+		WithClassName(index.ClassName).
 		WithHybrid(hybrid).
 		WithLimit(MaxNumSearchResults).
 		WithFields(_chunk_fields...).
@@ -318,18 +620,20 @@ func (w *WeaviateStore) HybridSearch(ctx context.Context, query string, vector [
 		return nil, fmt.Errorf("no chunks found")
 	}
 	get := resp.Data["Get"].(map[string]interface{})
-	if get[chunkClassName] == nil {
+	if get[index.ClassName] == nil {
 		// return empty result
 		return []*types.Chunk{}, nil
 	}
 
-	return parseChunks(ctx, w.client, get[chunkClassName].([]interface{}), true)
+	return parseChunks(ctx, w.client, get[index.ClassName].([]interface{}), true)
 }
 
 func parseChunks(ctx context.Context, client *weaviate.Client, chunks []interface{}, withScore bool) ([]*types.Chunk, error) {
 	res := []*types.Chunk{}
 	score := 0.0
 	var err error
+	// Chunks of the same document, such as neighbours, share its lookup
+	docs := map[string]map[string]interface{}{}
 	for _, chunkMap := range chunks {
 		c := chunkMap.(map[string]interface{})
 
@@ -350,9 +654,13 @@ func parseChunks(ctx context.Context, client *weaviate.Client, chunks []interfac
 		if !ok {
 			return nil, fmt.Errorf("documentid is nil")
 		}
-		docData, err := getDocument(ctx, client, docid)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get document: %v", err)
+		docData, ok := docs[docid]
+		if !ok {
+			docData, err = getDocument(ctx, client, docid)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get document: %v", err)
+			}
+			docs[docid] = docData
 		}
 		createdAt, _ := time.Parse(time.RFC3339, docData["createdAt"].(string))
 		updatedAt, _ := time.Parse(time.RFC3339, docData["updatedAt"].(string))
@@ -365,11 +673,41 @@ func parseChunks(ctx context.Context, client *weaviate.Client, chunks []interfac
 				ConnectorType: docData["connectorType"].(string),
 				CreatedAt:     createdAt,
 				UpdatedAt:     updatedAt,
+				Metadata:      decodeStringMap(docData["metadata"]),
 			},
 			Text: c["chunk"].(string),
 			Hash: c["hash"].(string),
 			// Document Title is not exported separately, although it's stored in the chunk
 		}
+		// Position properties are missing from chunks stored by older versions
+		if ordinal, ok := c["ordinal"].(float64); ok {
+			chunk.Ordinal = int(ordinal)
+		}
+		if pageStart, ok := c["page_start"].(float64); ok {
+			chunk.PageStart = int(pageStart)
+		}
+		if pageEnd, ok := c["page_end"].(float64); ok {
+			chunk.PageEnd = int(pageEnd)
+		}
+		if startOffset, ok := c["start_offset"].(float64); ok {
+			chunk.StartOffset = int(startOffset)
+		}
+		if endOffset, ok := c["end_offset"].(float64); ok {
+			chunk.EndOffset = int(endOffset)
+		}
+		if headingPath, ok := c["heading_path"].([]interface{}); ok {
+			for _, heading := range headingPath {
+				chunk.HeadingPath = append(chunk.HeadingPath, heading.(string))
+			}
+		}
+		if urls, ok := c["urls"].([]interface{}); ok {
+			for _, url := range urls {
+				chunk.URLs = append(chunk.URLs, url.(string))
+			}
+		}
+		if rawText, ok := c["raw_chunk"].(string); ok {
+			chunk.RawText = rawText
+		}
 		if withScore {
 			chunk.Score = score
 		}
@@ -394,16 +732,27 @@ func (w *WeaviateStore) CreateConfigClass(ctx context.Context, force bool) error
 				Name:     "enableTelemetry",
 				DataType: []string{"boolean"},
 			},
+			{
+				Name:     "generationModel",
+				DataType: []string{"text"},
+			},
 		},
 	}
 
-	// Create the class in Weaviate
-	err := w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+	exists, err := w.client.Schema().ClassExistenceChecker().WithClassName(configClassName).Do(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create chunk class: %v", err)
+		return fmt.Errorf("failed to check for config class: %v", err)
+	}
+	if exists {
+		return w.ensureProperties(ctx, configClassName, class.Properties)
 	}
 
 	// Create the class in Weaviate
+	err = w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create config class: %v", err)
+	}
+
 	return nil
 }
 
@@ -418,6 +767,9 @@ func (w *WeaviateStore) GetConfig(ctx context.Context) (*types.Config, error) {
 				{
 					Name: "enableTelemetry",
 				},
+				{
+					Name: "generationModel",
+				},
 				{
 					Name: "_additional",
 					Fields: []graphql.Field{
@@ -450,9 +802,12 @@ func (w *WeaviateStore) GetConfig(ctx context.Context) (*types.Config, error) {
 }
 
 func parseConfig(cfgMap map[string]interface{}) *types.Config {
+	// Unset in configs saved before the model could be selected
+	generationModel, _ := cfgMap["generationModel"].(string)
 	return &types.Config{
 		ID:              cfgMap["_additional"].(map[string]interface{})["id"].(string),
 		EnableTelemetry: cfgMap["enableTelemetry"].(bool),
+		GenerationModel: generationModel,
 	}
 }
 
@@ -468,16 +823,23 @@ func (w *WeaviateStore) UpdateConfig(ctx context.Context, cfg *types.Config) err
 		_, err := w.client.Data().Creator().WithClassName(configClassName).
 			WithProperties(map[string]interface{}{
 				"enableTelemetry": cfg.EnableTelemetry,
+				"generationModel": cfg.GenerationModel,
 			}).
 			Do(ctx)
 		return err
 	}
 
+	// Clients unaware of the model selection keep it
+	generationModel := cfg.GenerationModel
+	if generationModel == "" {
+		generationModel = prevCfg.GenerationModel
+	}
 	return w.client.Data().Updater(). // replaces the entire object
 						WithID(prevCfg.ID).
 						WithClassName(configClassName).
 						WithProperties(map[string]interface{}{
 			"enableTelemetry": cfg.EnableTelemetry,
+			"generationModel": generationModel,
 		}).
 		Do(ctx)
 }
@@ -516,11 +878,23 @@ func (w *WeaviateStore) CreateDocumentClass(ctx context.Context, force bool) err
 				Name:     "updatedAt",
 				DataType: []string{"date"},
 			},
+			{
+				Name:     "metadata", // JSON encoded map
+				DataType: []string{"text"},
+			},
 		},
 	}
 
+	exists, err := w.client.Schema().ClassExistenceChecker().WithClassName(documentClassName).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check for document class: %v", err)
+	}
+	if exists {
+		return w.ensureProperties(ctx, documentClassName, class.Properties)
+	}
+
 	// Create the class in Weaviate
-	err := w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+	err = w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to create chunk class: %v", err)
 	}
@@ -529,21 +903,19 @@ func (w *WeaviateStore) CreateDocumentClass(ctx context.Context, force bool) err
 	return nil
 }
 
+// CreateChunkClass creates the classes of the embedding indexes, each holding
+// the chunks vectorized with one model
 func (w *WeaviateStore) CreateChunkClass(ctx context.Context, force bool) error {
-	// DEBUG: attempt to delete the class, don't fail if it doesn't exist
-	if force {
-		w.client.Schema().ClassDeleter().WithClassName(chunkClassName).Do(ctx)
-	}
+	return w.initEmbeddingIndexes(ctx, force)
+}
 
-	class := &models.Class{
-		Class:      chunkClassName,
-		Vectorizer: "text2vec-ollama",
-		ModuleConfig: map[string]map[string]string{
-			"text2vec-ollama": {
-				"apiEndpoint": w.ollamaURL,
-				"model":       w.embeddingsModelName,
-			},
-		},
+// chunkClassSchema returns the class of an index, whose vectors are computed
+// by the embedder
+func chunkClassSchema(className string) *models.Class {
+	noIndex := false
+	return &models.Class{
+		Class:      className,
+		Vectorizer: "none",
 		Properties: []*models.Property{
 			{
 				Name:     "chunk",
@@ -561,15 +933,67 @@ func (w *WeaviateStore) CreateChunkClass(ctx context.Context, force bool) error
 				Name:     "document_title", // Stored both here and in document, to facilitate hybrid search
 				DataType: []string{"text"},
 			},
+			{
+				Name:     "ordinal",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "page_start",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "page_end",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "heading_path", // Already part of the chunk text
+				DataType: []string{"text[]"},
+			},
+			{
+				Name:     "start_offset",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "end_offset",
+				DataType: []string{"int"},
+			},
+			{
+				Name:            "raw_chunk", // Content before sanitisation, kept for debugging
+				DataType:        []string{"text"},
+				IndexSearchable: &noIndex,
+				IndexFilterable: &noIndex,
+			},
+			{
+				Name:     "urls", // Removed from the chunk text by the sanitizer
+				DataType: []string{"text[]"},
+			},
 		},
 	}
+}
 
-	// Create the class in Weaviate
-	err := w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+// ensureProperties adds any of the given properties that are missing from an
+// existing class, so that classes created by older versions keep working
+func (w *WeaviateStore) ensureProperties(ctx context.Context, className string, properties []*models.Property) error {
+	class, err := w.client.Schema().ClassGetter().WithClassName(className).Do(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create chunk class: %v", err)
+		return fmt.Errorf("failed to get class %s: %v", className, err)
+	}
+
+	existing := map[string]bool{}
+	for _, prop := range class.Properties {
+		existing[prop.Name] = true
 	}
 
+	for _, prop := range properties {
+		if existing[prop.Name] {
+			continue
+		}
+		log.Printf("Adding property %s to class %s", prop.Name, className)
+		err = w.client.Schema().PropertyCreator().WithClassName(className).WithProperty(prop).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to add property %s to class %s: %v", prop.Name, className, err)
+		}
+	}
 	return nil
 }
 
@@ -851,13 +1275,154 @@ func (w *WeaviateStore) CreateConnectorStateClass(ctx context.Context, force boo
 				Name:     "numErrors",
 				DataType: []string{"int"},
 			},
+			{
+				Name:     "syncCursors", // JSON encoded map
+				DataType: []string{"text"},
+			},
+			{
+				Name:     "schedule",
+				DataType: []string{"text"},
+			},
+			{
+				Name:     "nextSync",
+				DataType: []string{"date"},
+			},
+			{
+				Name:     "syncFailures",
+				DataType: []string{"int"},
+			},
+			{
+				Name:     "paused",
+				DataType: []string{"boolean"},
+			},
+			{
+				Name:     "authError",
+				DataType: []string{"text"},
+			},
 		},
 	}
 
+	exists, err := w.client.Schema().ClassExistenceChecker().WithClassName(stateClassName).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check for connector state class: %v", err)
+	}
+	if exists {
+		return w.ensureProperties(ctx, stateClassName, class.Properties)
+	}
+
 	// Create the class in Weaviate
 	return w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
 }
 
+var stateFields = []graphql.Field{
+	{Name: "connector_id"},
+	{Name: "type"},
+	{Name: "user"},
+	{Name: "syncing"},
+	{Name: "auth_valid"},
+	{Name: "lastSync"},
+	{Name: "numDocuments"},
+	{Name: "numChunks"},
+	{Name: "numErrors"},
+	{Name: "syncCursors"},
+	{Name: "schedule"},
+	{Name: "nextSync"},
+	{Name: "syncFailures"},
+	{Name: "paused"},
+	{Name: "authError"},
+}
+
+func stateProperties(state *types.ConnectorState) map[string]interface{} {
+	return map[string]interface{}{
+		"connector_id": state.ConnectorID,
+		"type":         state.ConnectorType,
+		"user":         state.User,
+		"syncing":      state.Syncing,
+		"auth_valid":   state.AuthValid,
+		"lastSync":     state.LastSync,
+		"numDocuments": state.NumDocuments,
+		"numChunks":    state.NumChunks,
+		"numErrors":    state.NumErrors,
+		"syncCursors":  encodeStringMap(state.SyncCursors),
+		"schedule":     state.Schedule,
+		"nextSync":     state.NextSync,
+		"syncFailures": state.SyncFailures,
+		"paused":       state.Paused,
+		"authError":    state.AuthError,
+	}
+}
+
+// parseConnectorState reads a state object. Properties added after the
+// object was created are missing, and left to their zero value.
+func parseConnectorState(c map[string]interface{}) *types.ConnectorState {
+	state := &types.ConnectorState{
+		SyncCursors: decodeStringMap(c["syncCursors"]),
+	}
+	state.ConnectorID, _ = c["connector_id"].(string)
+	state.ConnectorType, _ = c["type"].(string)
+	state.User, _ = c["user"].(string)
+	state.Syncing, _ = c["syncing"].(bool)
+	state.AuthValid, _ = c["auth_valid"].(bool)
+	state.Paused, _ = c["paused"].(bool)
+	state.Schedule, _ = c["schedule"].(string)
+	state.AuthError, _ = c["authError"].(string)
+	state.LastSync = parseDate(c["lastSync"])
+	state.NextSync = parseDate(c["nextSync"])
+	state.NumDocuments = parseInt(c["numDocuments"])
+	state.NumChunks = parseInt(c["numChunks"])
+	state.NumErrors = parseInt(c["numErrors"])
+	state.SyncFailures = parseInt(c["syncFailures"])
+	return state
+}
+
+func parseDate(value interface{}) time.Time {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		log.Printf("Failed to parse date %s: %s\n", s, err)
+	}
+	return t
+}
+
+// parseInt reads an int property, which GraphQL returns as a float
+func parseInt(value interface{}) int {
+	f, _ := value.(float64)
+	return int(f)
+}
+
+// encodeStringMap encodes a map property, such as the sync cursors of a
+// connector, as JSON text
+func encodeStringMap(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		// A map of strings always marshals
+		log.Printf("Failed to marshal map: %s", err)
+		return ""
+	}
+	return string(b)
+}
+
+// decodeStringMap parses a map property stored with encodeStringMap. The
+// property is missing from objects stored by older versions.
+func decodeStringMap(value interface{}) map[string]string {
+	m := map[string]string{}
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return m
+	}
+	err := json.Unmarshal([]byte(s), &m)
+	if err != nil {
+		log.Printf("Failed to parse map: %s", err)
+	}
+	return m
+}
+
 var ErrSyncingAlreadyExpected = errors.New("syncing is already at the expected value")
 
 func IsSyncingAlreadyExpected(err error) bool {
@@ -879,12 +1444,13 @@ func (w *WeaviateStore) SetConnectorSyncing(ctx context.Context, connectorID str
 	return state, err
 }
 
-// Add or update the connector state in Weaviate
-func (w *WeaviateStore) UpdateConnectorState(ctx context.Context, state *types.ConnectorState) error {
+// stateObjectID returns the ID of the state object of a connector, or an
+// empty string if it has none
+func (w *WeaviateStore) stateObjectID(ctx context.Context, connectorID string) (string, error) {
 	where := filters.Where().
 		WithPath([]string{"connector_id"}).
 		WithOperator(filters.Equal).
-		WithValueString(state.ConnectorID)
+		WithValueString(connectorID)
 
 	resp, err := w.client.GraphQL().Get().
 		WithClassName(stateClassName).
@@ -894,50 +1460,123 @@ func (w *WeaviateStore) UpdateConnectorState(ctx context.Context, state *types.C
 		WithWhere(where).
 		Do(ctx)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if resp.Data["Get"] == nil || len(resp.Data["Get"].(map[string]interface{})[stateClassName].([]interface{})) == 0 {
-		log.Printf("Creating new connector state for %s %s", state.ConnectorType, state.ConnectorID)
-		_, err := w.client.Data().Creator().WithClassName(stateClassName).WithProperties(map[string]interface{}{
-			"connector_id": state.ConnectorID,
-			"type":         state.ConnectorType,
-			"user":         state.User,
-			"syncing":      state.Syncing,
-			"auth_valid":   state.AuthValid,
-			"lastSync":     state.LastSync,
-			"numDocuments": state.NumDocuments,
-			"numChunks":    state.NumChunks,
-			"numErrors":    state.NumErrors,
-		}).
-			WithID(state.ConnectorID).
-			Do(ctx)
-		return err
+		return "", nil
 	}
 
 	get := resp.Data["Get"].(map[string]interface{})
 	states := get["ConnectorState"].([]interface{})
 	c := states[0].(map[string]interface{})
 	addl := c["_additional"].(map[string]interface{})
-	objID := addl["id"].(string)
-
-	err = w.client.Data().Updater(). // replaces the entire object
-						WithID(objID).
-						WithClassName(stateClassName).
-						WithProperties(map[string]interface{}{
-			"connector_id": state.ConnectorID,
-			"type":         state.ConnectorType,
-			"user":         state.User,
-			"syncing":      state.Syncing,
-			"auth_valid":   state.AuthValid,
-			"lastSync":     state.LastSync,
-			"numDocuments": state.NumDocuments,
-			"numChunks":    state.NumChunks,
-			"numErrors":    state.NumErrors,
-		}).
-		Do(ctx)
-
-	return err
+	return addl["id"].(string), nil
+}
+
+// Add or update the connector state in Weaviate. Paused, the schedule and the
+// validity of auth are only set when the state is created, see
+// SetConnectorPaused, SetConnectorSchedule and SetConnectorAuth.
+func (w *WeaviateStore) UpdateConnectorState(ctx context.Context, state *types.ConnectorState) error {
+	objID, err := w.stateObjectID(ctx, state.ConnectorID)
+	if err != nil {
+		return err
+	}
+
+	if objID == "" {
+		log.Printf("Creating new connector state for %s %s", state.ConnectorType, state.ConnectorID)
+		_, err := w.client.Data().Creator().WithClassName(stateClassName).WithProperties(stateProperties(state)).
+			WithID(state.ConnectorID).
+			Do(ctx)
+		return err
+	}
+
+	// Every other property is replaced. Paused, schedule and auth are left
+	// out, so that a state read before they change, e.g. by a running sync,
+	// does not undo them.
+	props := stateProperties(state)
+	delete(props, "paused")
+	delete(props, "schedule")
+	delete(props, "auth_valid")
+	delete(props, "authError")
+	return w.client.Data().Updater().
+		WithMerge().
+		WithID(objID).
+		WithClassName(stateClassName).
+		WithProperties(props).
+		Do(ctx)
+}
+
+// SetConnectorPaused updates whether a connector is paused, leaving the rest
+// of its state untouched
+func (w *WeaviateStore) SetConnectorPaused(ctx context.Context, connectorID string, paused bool) (*types.ConnectorState, error) {
+	objID, err := w.stateObjectID(ctx, connectorID)
+	if err != nil {
+		return nil, err
+	}
+	if objID == "" {
+		return nil, ErrNoStateFound
+	}
+
+	err = w.client.Data().Updater().
+		WithMerge().
+		WithID(objID).
+		WithClassName(stateClassName).
+		WithProperties(map[string]interface{}{"paused": paused}).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return w.GetConnectorState(ctx, connectorID)
+}
+
+// SetConnectorSchedule updates the schedule of a connector, and when it is
+// next due unless nextSync is zero, leaving the rest of its state untouched
+func (w *WeaviateStore) SetConnectorSchedule(ctx context.Context, connectorID string, schedule string, nextSync time.Time) (*types.ConnectorState, error) {
+	objID, err := w.stateObjectID(ctx, connectorID)
+	if err != nil {
+		return nil, err
+	}
+	if objID == "" {
+		return nil, ErrNoStateFound
+	}
+
+	props := map[string]interface{}{"schedule": schedule}
+	if !nextSync.IsZero() {
+		props["nextSync"] = nextSync
+	}
+	err = w.client.Data().Updater().
+		WithMerge().
+		WithID(objID).
+		WithClassName(stateClassName).
+		WithProperties(props).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return w.GetConnectorState(ctx, connectorID)
+}
+
+// SetConnectorAuth updates whether the auth of a connector is valid, and the
+// reason it is not, leaving the rest of its state untouched
+func (w *WeaviateStore) SetConnectorAuth(ctx context.Context, connectorID string, valid bool, authError string) error {
+	objID, err := w.stateObjectID(ctx, connectorID)
+	if err != nil {
+		return err
+	}
+	if objID == "" {
+		return ErrNoStateFound
+	}
+
+	return w.client.Data().Updater().
+		WithMerge().
+		WithID(objID).
+		WithClassName(stateClassName).
+		WithProperties(map[string]interface{}{
+			"auth_valid": valid,
+			"authError":  authError,
+		}).
+		Do(ctx)
 }
 
 // Fetches all stored connector states from Weaviate, used to initialize the syncer after restart
@@ -945,21 +1584,14 @@ func (w *WeaviateStore) AllConnectorStates(ctx context.Context) ([]*types.Connec
 	resp, err := w.client.GraphQL().Get().
 		WithClassName(stateClassName).
 		WithFields(
-			[]graphql.Field{
-				{Name: "connector_id"},
-				{Name: "type"},
-				{Name: "user"},
-				{Name: "syncing"},
-				{Name: "auth_valid"},
-				{Name: "lastSync"},
-				{Name: "numDocuments"},
-				{Name: "numChunks"},
-				{Name: "numErrors"},
-			}...).
+			stateFields...).
 		Do(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("failed to get connector states: %s", resp.Errors[0].Message)
+	}
 
 	if resp.Data["Get"] == nil {
 		return nil, nil
@@ -977,22 +1609,7 @@ func (w *WeaviateStore) AllConnectorStates(ctx context.Context) ([]*types.Connec
 
 	res := []*types.ConnectorState{}
 	for _, state := range states {
-		c := state.(map[string]interface{})
-		lastSync, err := time.Parse(time.RFC3339, c["lastSync"].(string))
-		if err != nil {
-			log.Printf("Failed to parse last sync time: %s\n", err)
-		}
-		res = append(res, &types.ConnectorState{
-			ConnectorID:   c["connector_id"].(string),
-			ConnectorType: c["type"].(string),
-			User:          c["user"].(string),
-			Syncing:       c["syncing"].(bool),
-			AuthValid:     c["auth_valid"].(bool),
-			LastSync:      lastSync,
-			NumDocuments:  int(c["numDocuments"].(float64)),
-			NumChunks:     int(c["numChunks"].(float64)),
-			NumErrors:     int(c["numErrors"].(float64)),
-		})
+		res = append(res, parseConnectorState(state.(map[string]interface{})))
 	}
 	return res, nil
 }
@@ -1014,22 +1631,15 @@ func (w *WeaviateStore) GetConnectorState(ctx context.Context, connectorID strin
 	resp, err := w.client.GraphQL().Get().
 		WithClassName(stateClassName).
 		WithFields(
-			[]graphql.Field{
-				{Name: "connector_id"},
-				{Name: "type"},
-				{Name: "user"},
-				{Name: "syncing"},
-				{Name: "auth_valid"},
-				{Name: "lastSync"},
-				{Name: "numDocuments"},
-				{Name: "numChunks"},
-				{Name: "numErrors"},
-			}...).
+			stateFields...).
 		WithWhere(where).
 		Do(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("failed to get connector state: %s", resp.Errors[0].Message)
+	}
 
 	if resp.Data["Get"] == nil {
 		return nil, nil
@@ -1049,28 +1659,429 @@ func (w *WeaviateStore) GetConnectorState(ctx context.Context, connectorID strin
 		return nil, fmt.Errorf("multiple connector state objects found")
 	}
 
-	c := states[0].(map[string]interface{})
-	lastSync, err := time.Parse(time.RFC3339, c["lastSync"].(string))
+	return parseConnectorState(states[0].(map[string]interface{})), nil
+}
+
+// Create a Weaviate class schema for the user settings of connectors
+func (w *WeaviateStore) CreateConnectorSettingsClass(ctx context.Context, force bool) error {
+	if force {
+		w.client.Schema().ClassDeleter().WithClassName(settingsClassName).Do(ctx)
+	}
+
+	class := &models.Class{
+		Class:      settingsClassName,
+		Vectorizer: "none",
+		Properties: []*models.Property{
+			{
+				Name:     "connector_id",
+				DataType: []string{"text"},
+			},
+			{
+				Name:     "settings", // JSON encoded, as the type depends on the connector
+				DataType: []string{"text"},
+			},
+		},
+	}
+
+	exists, err := w.client.Schema().ClassExistenceChecker().WithClassName(settingsClassName).Do(ctx)
 	if err != nil {
-		log.Printf("Failed to parse last sync time: %s\n", err)
-	}
-
-	return &types.ConnectorState{
-		ConnectorID:   c["connector_id"].(string),
-		ConnectorType: c["type"].(string),
-		User:          c["user"].(string),
-		Syncing:       c["syncing"].(bool),
-		AuthValid:     c["auth_valid"].(bool),
-		LastSync:      lastSync,
-		NumDocuments:  int(c["numDocuments"].(float64)),
-		NumChunks:     int(c["numChunks"].(float64)),
-		NumErrors:     int(c["numErrors"].(float64)),
-	}, nil
+		return fmt.Errorf("failed to check for connector settings class: %v", err)
+	}
+	if exists {
+		return w.ensureProperties(ctx, settingsClassName, class.Properties)
+	}
+
+	return w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+}
+
+// getConnectorSettingsObject returns the object ID and JSON encoded settings
+// of a connector, or an empty ID if none were stored
+func (w *WeaviateStore) getConnectorSettingsObject(ctx context.Context, connectorID string) (string, string, error) {
+	where := filters.Where().
+		WithPath([]string{"connector_id"}).
+		WithOperator(filters.Equal).
+		WithValueString(connectorID)
+
+	resp, err := w.client.GraphQL().Get().
+		WithClassName(settingsClassName).
+		WithFields([]graphql.Field{
+			{Name: "settings"},
+			{Name: "_additional", Fields: []graphql.Field{{Name: "id"}}},
+		}...).
+		WithWhere(where).
+		Do(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.Errors) > 0 {
+		return "", "", fmt.Errorf("failed to get connector settings: %s", resp.Errors[0].Message)
+	}
+	if resp.Data["Get"] == nil {
+		return "", "", nil
+	}
+
+	get := resp.Data["Get"].(map[string]interface{})
+	objs, ok := get[settingsClassName].([]interface{})
+	if !ok || len(objs) == 0 {
+		return "", "", nil
+	}
+
+	obj := objs[0].(map[string]interface{})
+	objID := obj["_additional"].(map[string]interface{})["id"].(string)
+	settings, _ := obj["settings"].(string)
+	return objID, settings, nil
+}
+
+// GetConnectorSettings returns the JSON encoded settings of a connector, or
+// nil if the user never changed them
+func (w *WeaviateStore) GetConnectorSettings(ctx context.Context, connectorID string) ([]byte, error) {
+	objID, settings, err := w.getConnectorSettingsObject(ctx, connectorID)
+	if err != nil {
+		return nil, err
+	}
+	if objID == "" || settings == "" {
+		return nil, nil
+	}
+	return []byte(settings), nil
+}
+
+func (w *WeaviateStore) UpdateConnectorSettings(ctx context.Context, connectorID string, settings []byte) error {
+	objID, _, err := w.getConnectorSettingsObject(ctx, connectorID)
+	if err != nil {
+		return err
+	}
+
+	properties := map[string]interface{}{
+		"connector_id": connectorID,
+		"settings":     string(settings),
+	}
+	if objID == "" {
+		_, err = w.client.Data().Creator().
+			WithClassName(settingsClassName).
+			WithProperties(properties).
+			Do(ctx)
+		return err
+	}
+
+	return w.client.Data().Updater().
+		WithID(objID).
+		WithClassName(settingsClassName).
+		WithProperties(properties).
+		Do(ctx)
+}
+
+// Create a Weaviate class schema for the history of connector syncs
+func (w *WeaviateStore) CreateSyncRunClass(ctx context.Context, force bool) error {
+	if force {
+		w.client.Schema().ClassDeleter().WithClassName(syncRunClassName).Do(ctx)
+	}
+
+	class := &models.Class{
+		Class:      syncRunClassName,
+		Vectorizer: "none",
+		Properties: []*models.Property{
+			{Name: "connector_id", DataType: []string{"text"}},
+			{Name: "retry", DataType: []string{"boolean"}},
+			{Name: "started_at", DataType: []string{"date"}},
+			{Name: "ended_at", DataType: []string{"date"}},
+			{Name: "outcome", DataType: []string{"text"}},
+			{Name: "num_documents", DataType: []string{"int"}},
+			{Name: "num_chunks", DataType: []string{"int"}},
+			{Name: "num_errors", DataType: []string{"int"}},
+			{Name: "error", DataType: []string{"text"}},
+		},
+	}
+	return w.createOrUpdateClass(ctx, class)
 }
 
-func (w *WeaviateStore) DeleteDocumentById(ctx context.Context, documentId string) error {
+// Create a Weaviate class schema for the items that failed to sync
+func (w *WeaviateStore) CreateSyncErrorClass(ctx context.Context, force bool) error {
+	if force {
+		w.client.Schema().ClassDeleter().WithClassName(syncErrorClassName).Do(ctx)
+	}
+
+	class := &models.Class{
+		Class:      syncErrorClassName,
+		Vectorizer: "none",
+		Properties: []*models.Property{
+			{Name: "connector_id", DataType: []string{"text"}},
+			{Name: "run_id", DataType: []string{"text"}},
+			{Name: "document_id", DataType: []string{"text"}},
+			{Name: "document_name", DataType: []string{"text"}},
+			{Name: "stage", DataType: []string{"text"}},
+			{Name: "error", DataType: []string{"text"}},
+			{Name: "created_at", DataType: []string{"date"}},
+		},
+	}
+	return w.createOrUpdateClass(ctx, class)
+}
+
+// createOrUpdateClass creates a class, or adds its missing properties if it
+// already exists
+func (w *WeaviateStore) createOrUpdateClass(ctx context.Context, class *models.Class) error {
+	exists, err := w.client.Schema().ClassExistenceChecker().WithClassName(class.Class).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check for class %s: %v", class.Class, err)
+	}
+	if exists {
+		return w.ensureProperties(ctx, class.Class, class.Properties)
+	}
+	return w.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+}
+
+var syncRunFields = []graphql.Field{
+	{Name: "connector_id"},
+	{Name: "retry"},
+	{Name: "started_at"},
+	{Name: "ended_at"},
+	{Name: "outcome"},
+	{Name: "num_documents"},
+	{Name: "num_chunks"},
+	{Name: "num_errors"},
+	{Name: "error"},
+	{Name: "_additional", Fields: []graphql.Field{{Name: "id"}}},
+}
+
+func syncRunProperties(run *types.SyncRun) map[string]interface{} {
+	return map[string]interface{}{
+		"connector_id":  run.ConnectorID,
+		"retry":         run.Retry,
+		"started_at":    run.StartedAt,
+		"ended_at":      run.EndedAt,
+		"outcome":       string(run.Outcome),
+		"num_documents": run.NumDocuments,
+		"num_chunks":    run.NumChunks,
+		"num_errors":    run.NumErrors,
+		"error":         run.Error,
+	}
+}
+
+func parseSyncRun(m map[string]interface{}) *types.SyncRun {
+	run := &types.SyncRun{}
+	run.ID, _ = m["_additional"].(map[string]interface{})["id"].(string)
+	run.ConnectorID, _ = m["connector_id"].(string)
+	run.Retry, _ = m["retry"].(bool)
+	outcome, _ := m["outcome"].(string)
+	run.Outcome = types.SyncOutcome(outcome)
+	run.Error, _ = m["error"].(string)
+	run.StartedAt = parseDate(m["started_at"])
+	run.EndedAt = parseDate(m["ended_at"])
+	run.NumDocuments = parseInt(m["num_documents"])
+	run.NumChunks = parseInt(m["num_chunks"])
+	run.NumErrors = parseInt(m["num_errors"])
+	return run
+}
+
+// AddSyncRun records a new run and sets its ID
+func (w *WeaviateStore) AddSyncRun(ctx context.Context, run *types.SyncRun) error {
+	run.ID = uuid.NewString()
+	_, err := w.client.Data().Creator().
+		WithClassName(syncRunClassName).
+		WithID(run.ID).
+		WithProperties(syncRunProperties(run)).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to add sync run: %v", err)
+	}
+	return nil
+}
+
+func (w *WeaviateStore) UpdateSyncRun(ctx context.Context, run *types.SyncRun) error {
+	err := w.client.Data().Updater().
+		WithID(run.ID).
+		WithClassName(syncRunClassName).
+		WithProperties(syncRunProperties(run)).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update sync run: %v", err)
+	}
+	return nil
+}
+
+// ListSyncRuns returns the latest runs of a connector, most recent first
+func (w *WeaviateStore) ListSyncRuns(ctx context.Context, connectorID string, limit int) ([]*types.SyncRun, error) {
+	objs, err := w.listConnectorHistory(ctx, syncRunClassName, syncRunFields, "started_at", connectorID, limit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sync runs: %v", err)
+	}
+	runs := []*types.SyncRun{}
+	for _, obj := range objs {
+		runs = append(runs, parseSyncRun(obj))
+	}
+	return runs, nil
+}
+
+var syncErrorFields = []graphql.Field{
+	{Name: "connector_id"},
+	{Name: "run_id"},
+	{Name: "document_id"},
+	{Name: "document_name"},
+	{Name: "stage"},
+	{Name: "error"},
+	{Name: "created_at"},
+	{Name: "_additional", Fields: []graphql.Field{{Name: "id"}}},
+}
+
+func parseSyncError(m map[string]interface{}) *types.SyncError {
+	syncErr := &types.SyncError{}
+	syncErr.ID, _ = m["_additional"].(map[string]interface{})["id"].(string)
+	syncErr.ConnectorID, _ = m["connector_id"].(string)
+	syncErr.RunID, _ = m["run_id"].(string)
+	syncErr.DocumentID, _ = m["document_id"].(string)
+	syncErr.DocumentName, _ = m["document_name"].(string)
+	syncErr.Stage, _ = m["stage"].(string)
+	syncErr.Error, _ = m["error"].(string)
+	syncErr.CreatedAt = parseDate(m["created_at"])
+	return syncErr
+}
+
+// AddSyncErrors records items that failed to sync and sets their IDs
+func (w *WeaviateStore) AddSyncErrors(ctx context.Context, syncErrors []*types.SyncError) error {
+	if len(syncErrors) == 0 {
+		return nil
+	}
+	objects := []*models.Object{}
+	for _, syncErr := range syncErrors {
+		syncErr.ID = uuid.NewString()
+		objects = append(objects, &models.Object{
+			Class: syncErrorClassName,
+			ID:    strfmt.UUID(syncErr.ID),
+			Properties: map[string]interface{}{
+				"connector_id":  syncErr.ConnectorID,
+				"run_id":        syncErr.RunID,
+				"document_id":   syncErr.DocumentID,
+				"document_name": syncErr.DocumentName,
+				"stage":         syncErr.Stage,
+				"error":         syncErr.Error,
+				"created_at":    syncErr.CreatedAt,
+			},
+		})
+	}
+	_, err := w.client.Batch().ObjectsBatcher().WithObjects(objects...).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to add sync errors: %v", err)
+	}
+	return nil
+}
+
+// ListSyncErrors returns the latest item errors of a connector, most recent
+// first
+func (w *WeaviateStore) ListSyncErrors(ctx context.Context, connectorID string, limit int) ([]*types.SyncError, error) {
+	objs, err := w.listConnectorHistory(ctx, syncErrorClassName, syncErrorFields, "created_at", connectorID, limit, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sync errors: %v", err)
+	}
+	syncErrors := []*types.SyncError{}
+	for _, obj := range objs {
+		syncErrors = append(syncErrors, parseSyncError(obj))
+	}
+	return syncErrors, nil
+}
+
+func (w *WeaviateStore) DeleteSyncErrors(ctx context.Context, ids []string) error {
+	errs := []error{}
+	for _, id := range ids {
+		err := w.client.Data().Deleter().
+			WithClassName(syncErrorClassName).
+			WithID(id).
+			Do(ctx)
+		if err != nil {
+			// The others are deleted anyway
+			errs = append(errs, fmt.Errorf("failed to delete sync error %s: %v", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PruneSyncHistory deletes the runs and item errors of a connector recorded
+// before the given time, and beyond the latest maxRuns runs and maxErrors
+// errors
+func (w *WeaviateStore) PruneSyncHistory(ctx context.Context, connectorID string, maxRuns, maxErrors int, before time.Time) error {
+	err := w.pruneConnectorHistory(ctx, syncRunClassName, "started_at", connectorID, maxRuns, before)
+	if err != nil {
+		return fmt.Errorf("failed to prune sync runs: %v", err)
+	}
+	err = w.pruneConnectorHistory(ctx, syncErrorClassName, "created_at", connectorID, maxErrors, before)
+	if err != nil {
+		return fmt.Errorf("failed to prune sync errors: %v", err)
+	}
+	return nil
+}
+
+// listConnectorHistory returns the objects of a connector in a history class,
+// most recent first according to dateProperty
+func (w *WeaviateStore) listConnectorHistory(ctx context.Context, className string, fields []graphql.Field, dateProperty string, connectorID string, limit, offset int) ([]map[string]interface{}, error) {
+	where := filters.Where().
+		WithPath([]string{"connector_id"}).
+		WithOperator(filters.Equal).
+		WithValueString(connectorID)
+
+	resp, err := w.client.GraphQL().Get().
+		WithClassName(className).
+		WithFields(fields...).
+		WithWhere(where).
+		WithSort(graphql.Sort{Path: []string{dateProperty}, Order: graphql.Desc}).
+		WithLimit(limit).
+		WithOffset(offset).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, errors.New(resp.Errors[0].Message)
+	}
+	if resp.Data["Get"] == nil {
+		return nil, nil
+	}
+
+	get := resp.Data["Get"].(map[string]interface{})
+	objs, _ := get[className].([]interface{})
+	res := []map[string]interface{}{}
+	for _, obj := range objs {
+		res = append(res, obj.(map[string]interface{}))
+	}
+	return res, nil
+}
+
+func (w *WeaviateStore) pruneConnectorHistory(ctx context.Context, className, dateProperty, connectorID string, max int, before time.Time) error {
+	// Everything older than the oldest object to keep goes
+	oldest, err := w.listConnectorHistory(ctx, className, []graphql.Field{{Name: dateProperty}}, dateProperty, connectorID, 1, max-1)
+	if err != nil {
+		return err
+	}
+	if len(oldest) > 0 {
+		kept := parseDate(oldest[0][dateProperty])
+		if kept.After(before) {
+			before = kept
+		}
+	}
+
+	_, err = w.client.Batch().ObjectsBatchDeleter().
+		WithClassName(className).
+		WithWhere(filters.Where().
+			WithOperator(filters.And).
+			WithOperands([]*filters.WhereBuilder{
+				filters.Where().
+					WithPath([]string{"connector_id"}).
+					WithOperator(filters.Equal).
+					WithValueString(connectorID),
+				filters.Where().
+					WithPath([]string{dateProperty}).
+					WithOperator(filters.LessThan).
+					WithValueDate(before),
+			})).
+		Do(ctx)
+	return err
+}
+
+// DeleteDocumentById deletes a document and its chunks, returning the number
+// of chunks deleted
+func (w *WeaviateStore) DeleteDocumentById(ctx context.Context, documentId string) (int, error) {
 	// Cascade delete children chunks
-	w.DeleteDocumentChunksById(ctx, documentId)
+	numChunks, err := w.DeleteDocumentChunksById(ctx, documentId)
+	if err != nil {
+		log.Printf("Unable to delete chunks of document %s: %v", documentId, err)
+	}
 
 	// Delete the document
 	docDeleteErr := w.client.Data().Deleter().
@@ -1079,30 +2090,48 @@ func (w *WeaviateStore) DeleteDocumentById(ctx context.Context, documentId strin
 		Do(ctx)
 
 	if docDeleteErr != nil {
-		return fmt.Errorf("unable to delete document: %v", docDeleteErr)
+		return numChunks, fmt.Errorf("unable to delete document: %v", docDeleteErr)
 	}
 	log.Printf("Deleted document %s", documentId)
 
-	return nil
+	return numChunks, nil
 }
 
-func (w *WeaviateStore) DeleteDocumentChunksById(ctx context.Context, documentId string) error {
-	// Note: By default max objects that can be deleted is 10K
-	// Reference: https://weaviate.io/developers/weaviate/manage-data/delete#delete-multiple-objects
-	response, err := w.client.Batch().ObjectsBatchDeleter().
-		WithClassName(chunkClassName).
-		WithOutput("verbose").
-		WithWhere(filters.Where().
-			WithPath([]string{"documentid"}).
-			WithOperator(filters.Equal).
-			WithValueText(documentId)).
-		Do(ctx)
-
+func (w *WeaviateStore) DeleteDocumentChunksById(ctx context.Context, documentId string) (int, error) {
+	numChunks, err := w.deleteChunks(ctx, documentId)
 	if err != nil {
-		return fmt.Errorf("unable to delete chunks: %v", err)
+		return 0, err
 	}
-	log.Printf("For Document %s, deleted %v chunks", documentId, response.Results.Successful)
-	return nil
+	log.Printf("For Document %s, deleted %v chunks", documentId, numChunks)
+	return numChunks, nil
+}
+
+// deleteChunks deletes the chunks of a document from every live index, and
+// returns the number deleted from the active one
+func (w *WeaviateStore) deleteChunks(ctx context.Context, documentId string) (int, error) {
+	w.chunksLock.RLock()
+	defer w.chunksLock.RUnlock()
+	activeClass := w.chunkClass()
+	numChunks := 0
+	for _, className := range w.liveChunkClasses() {
+		// Note: By default max objects that can be deleted is 10K
+		// Reference: https://weaviate.io/developers/weaviate/manage-data/delete#delete-multiple-objects
+		response, err := w.client.Batch().ObjectsBatchDeleter().
+			WithClassName(className).
+			WithOutput("verbose").
+			WithWhere(filters.Where().
+				WithPath([]string{"documentid"}).
+				WithOperator(filters.Equal).
+				WithValueText(documentId)).
+			Do(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("unable to delete chunks from %s: %v", className, err)
+		}
+		if className == activeClass {
+			numChunks = int(response.Results.Successful)
+		}
+	}
+	return numChunks, nil
 }
 
 func (w *WeaviateStore) DeleteDocumentChunks(ctx context.Context, uniqueID string, connectorID string) error {
@@ -1116,21 +2145,11 @@ func (w *WeaviateStore) DeleteDocumentChunks(ctx context.Context, uniqueID strin
 		return nil
 	}
 
-	resp, err := w.client.Batch().ObjectsBatchDeleter().
-		WithClassName(chunkClassName).
-		WithOutput("verbose").
-		WithWhere(filters.Where().
-			WithPath([]string{"documentid"}).
-			WithOperator(filters.Equal).
-			WithValueText(docid)).
-		Do(ctx)
+	numDeletedChunks, err := w.deleteChunks(ctx, docid)
 	if err != nil {
-		return fmt.Errorf("unable to delete chunks: %v", err)
+		return err
 	}
-
-	log.Printf("%+v", resp)
-
-	numDeletedChunks := resp.Results.Successful
+	log.Printf("For Document %s, deleted %v chunks", docid, numDeletedChunks)
 
 	// Reduce the chunk count for the connector
 	state, err := w.GetConnectorState(ctx, connectorID)
@@ -1142,7 +2161,7 @@ func (w *WeaviateStore) DeleteDocumentChunks(ctx context.Context, uniqueID strin
 		return fmt.Errorf("connector state not found, unable to update chunk count")
 	}
 
-	state.NumChunks = state.NumChunks - int(numDeletedChunks)
+	state.NumChunks = state.NumChunks - numDeletedChunks
 	err = w.UpdateConnectorState(ctx, state)
 	if err != nil {
 		return fmt.Errorf("unable to update connector state: %v", err)
@@ -1242,10 +2261,26 @@ func (w *WeaviateStore) DeleteConnector(ctx context.Context, connector types.Con
 	if connectorDeletionErr != nil {
 		log.Printf("Failed to delete connector %s: %v", connectorID, connectorDeletionErr)
 	}
+	_, settingsDeletionErr := w.client.Batch().ObjectsBatchDeleter().
+		WithClassName(settingsClassName).
+		WithWhere(connectorDeleteWhere).
+		Do(ctx)
+	if settingsDeletionErr != nil {
+		log.Printf("Failed to delete settings of connector %s: %v", connectorID, settingsDeletionErr)
+	}
+	for _, className := range []string{syncRunClassName, syncErrorClassName} {
+		_, historyDeletionErr := w.client.Batch().ObjectsBatchDeleter().
+			WithClassName(className).
+			WithWhere(connectorDeleteWhere).
+			Do(ctx)
+		if historyDeletionErr != nil {
+			log.Printf("Failed to delete sync history of connector %s: %v", connectorID, historyDeletionErr)
+		}
+	}
 
 	// TODO Delete credentials for connector
 	keychainDeletionErr := keychain.DeleteTokenFromKeychain(connectorID, connector.Type())
-	if keychainDeletionErr != nil {
+	if keychainDeletionErr != nil && !errors.Is(keychainDeletionErr, keychain.ErrNotFound) {
 		return fmt.Errorf("failed to delete credentials for connector %s: %v", connectorID, keychainDeletionErr)
 	}
 	return nil
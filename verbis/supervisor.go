@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Delays between restarts of a process that keeps exiting
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 2 * time.Minute
+	// A process running for that long is considered stable, and the backoff
+	// is reset when it exits
+	stableRunDuration = time.Minute
+
+	healthCheckInterval = 10 * time.Second
+	healthCheckTimeout  = 5 * time.Second
+	// Time for a process to start serving before its health is checked
+	healthCheckGrace = time.Minute
+	// Number of consecutive failed health checks after which a process that
+	// is still running is restarted
+	maxFailedHealthChecks = 3
+
+	// Time for a process to exit after SIGTERM, before it is killed
+	stopTimeout = 15 * time.Second
+
+	// Relative to the data dir
+	processLogDir = "logs"
+	processPIDDir = "run"
+)
+
+// CmdSpec describes a process run by the supervisor
+type CmdSpec struct {
+	Name string // Short name, such as "ollama", used for logs and health
+	Path string
+	Args []string
+	Env  []string
+	// HealthCheck returns an error while the process is not serving
+	HealthCheck func(ctx context.Context) error
+}
+
+// ProcessHealth is the state of a supervised process
+type ProcessHealth struct {
+	Running  bool   `json:"running"`
+	Healthy  bool   `json:"healthy"`
+	PID      int    `json:"pid,omitempty"`
+	Restarts int    `json:"restarts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Supervisor runs processes until its context is cancelled, restarting them
+// with exponential backoff when they exit or stop passing health checks
+type Supervisor struct {
+	logDir string
+	pidDir string
+
+	lock   sync.Mutex
+	health map[string]*ProcessHealth
+	wg     sync.WaitGroup
+}
+
+func NewSupervisor(logDir, pidDir string) *Supervisor {
+	return &Supervisor{
+		logDir: logDir,
+		pidDir: pidDir,
+		health: map[string]*ProcessHealth{},
+	}
+}
+
+// Start stops any instance of the processes left over by a previous run, then
+// runs them in the background
+func (s *Supervisor) Start(ctx context.Context, specs []CmdSpec) error {
+	for _, dir := range []string{s.logDir, s.pidDir} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+	}
+	for _, spec := range specs {
+		s.stopStale(spec)
+		s.lock.Lock()
+		s.health[spec.Name] = &ProcessHealth{}
+		s.lock.Unlock()
+
+		s.wg.Add(1)
+		go func(spec CmdSpec) {
+			defer s.wg.Done()
+			s.supervise(ctx, spec)
+		}(spec)
+	}
+	return nil
+}
+
+// Wait blocks until all processes have stopped, once the context passed to
+// Start is cancelled
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// Health returns the state of each process by name
+func (s *Supervisor) Health() map[string]ProcessHealth {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := map[string]ProcessHealth{}
+	for name, h := range s.health {
+		res[name] = *h
+	}
+	return res
+}
+
+func (s *Supervisor) updateHealth(name string, f func(h *ProcessHealth)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(s.health[name])
+}
+
+func (s *Supervisor) supervise(ctx context.Context, spec CmdSpec) {
+	backoff := minRestartBackoff
+	for {
+		startedAt := time.Now()
+		err := s.runOnce(ctx, spec)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(startedAt) > stableRunDuration {
+			backoff = minRestartBackoff
+		}
+		log.Printf("Process %s exited: %v, restarting in %s", spec.Name, err, backoff)
+		s.updateHealth(spec.Name, func(h *ProcessHealth) {
+			h.Running = false
+			h.Healthy = false
+			h.PID = 0
+			h.Restarts++
+			h.Error = fmt.Sprintf("exited: %v", err)
+		})
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, maxRestartBackoff)
+	}
+}
+
+// runOnce runs the process until it exits, fails its health checks, or the
+// context is cancelled
+func (s *Supervisor) runOnce(ctx context.Context, spec CmdSpec) error {
+	logFile, err := os.OpenFile(filepath.Join(s.logDir, spec.Name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(spec.Path, spec.Args...)
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start: %v", err)
+	}
+	startedAt := time.Now()
+	log.Printf("Started process %s with pid %d", spec.Name, cmd.Process.Pid)
+	s.writePID(spec, cmd.Process.Pid)
+	defer s.removePID(spec)
+	s.updateHealth(spec.Name, func(h *ProcessHealth) {
+		h.Running = true
+		h.PID = cmd.Process.Pid
+		// Without a health check, a running process is assumed to serve
+		if spec.HealthCheck == nil {
+			h.Healthy = true
+			h.Error = ""
+		}
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	checks := time.NewTicker(healthCheckInterval)
+	defer checks.Stop()
+	failedChecks := 0
+	for {
+		select {
+		case err := <-done:
+			if err == nil {
+				err = errors.New("exit status 0")
+			}
+			return err
+		case <-ctx.Done():
+			stopProcess(spec.Name, cmd.Process, done)
+			return ctx.Err()
+		case <-checks.C:
+			if spec.HealthCheck == nil {
+				continue
+			}
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			err := spec.HealthCheck(checkCtx)
+			cancel()
+			if ctx.Err() != nil {
+				continue
+			}
+			s.updateHealth(spec.Name, func(h *ProcessHealth) {
+				h.Healthy = err == nil
+				if err != nil {
+					h.Error = err.Error()
+				} else {
+					h.Error = ""
+				}
+			})
+			if err == nil {
+				failedChecks = 0
+				continue
+			}
+			if time.Since(startedAt) < healthCheckGrace {
+				continue
+			}
+			failedChecks++
+			if failedChecks >= maxFailedHealthChecks {
+				log.Printf("Process %s failed %d health checks, last error: %v", spec.Name, failedChecks, err)
+				stopProcess(spec.Name, cmd.Process, done)
+				return fmt.Errorf("unhealthy: %v", err)
+			}
+		}
+	}
+}
+
+// stopProcess asks the process to exit, and kills it if it does not within
+// stopTimeout. done receives the result of its Wait.
+func stopProcess(name string, p *os.Process, done chan error) {
+	log.Printf("Stopping process %s", name)
+	err := p.Signal(syscall.SIGTERM)
+	if err != nil {
+		log.Printf("Failed to send SIGTERM to process %s: %v", name, err)
+	}
+	select {
+	case <-done:
+		log.Printf("Process %s stopped", name)
+	case <-time.After(stopTimeout):
+		log.Printf("Process %s did not stop in %s, killing it", name, stopTimeout)
+		if err := p.Kill(); err != nil {
+			log.Printf("Failed to kill process %s: %v", name, err)
+		}
+		<-done
+	}
+}
+
+func (s *Supervisor) pidFile(spec CmdSpec) string {
+	return filepath.Join(s.pidDir, spec.Name+".pid")
+}
+
+func (s *Supervisor) writePID(spec CmdSpec, pid int) {
+	err := os.WriteFile(s.pidFile(spec), []byte(strconv.Itoa(pid)), 0644)
+	if err != nil {
+		log.Printf("Failed to write pid file of process %s: %v", spec.Name, err)
+	}
+}
+
+func (s *Supervisor) removePID(spec CmdSpec) {
+	err := os.Remove(s.pidFile(spec))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove pid file of process %s: %v", spec.Name, err)
+	}
+}
+
+// stopStale stops the instance of the process recorded in its pid file, left
+// running if Verbis did not shut down cleanly. The pid is only trusted if it
+// still runs the same executable, as it may have been reused.
+func (s *Supervisor) stopStale(spec CmdSpec) {
+	b, err := os.ReadFile(s.pidFile(spec))
+	if err != nil {
+		return
+	}
+	defer s.removePID(spec)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return
+	}
+	out, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=").Output()
+	if err != nil || filepath.Base(strings.TrimSpace(string(out))) != filepath.Base(spec.Path) {
+		return
+	}
+
+	log.Printf("Stopping stale process %s with pid %d", spec.Name, pid)
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return
+	}
+	err = p.Signal(syscall.SIGTERM)
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		if p.Signal(syscall.Signal(0)) != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	log.Printf("Stale process %s did not stop in %s, killing it", spec.Name, stopTimeout)
+	p.Kill()
+}
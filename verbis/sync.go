@@ -5,8 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
@@ -21,34 +23,106 @@ import (
 
 const (
 	MinChunkSize = 10
+
+	// InventoryPeriod is the minimum time between two reconciliations of
+	// the stored documents of a connector with its inventory
+	InventoryPeriod = 1 * time.Hour
+	// InventoryMaxDeletedRatio is the largest share of the stored documents
+	// of a connector that an inventory may delete. Beyond it the inventory
+	// is taken for a failed or partial listing, and ignored.
+	InventoryMaxDeletedRatio = 0.5
+	// Connectors with fewer stored documents are not checked against
+	// InventoryMaxDeletedRatio
+	inventoryMinCheckedDocuments = 20
+
+	// MaxConcurrentSyncs caps the number of connectors syncing at once
+	MaxConcurrentSyncs = 2
+	// DefaultSyncInterval applies to connectors without a schedule
+	DefaultSyncInterval = 1 * time.Minute
+
+	// Bounds of the delay before retrying a failed sync, which doubles with
+	// every consecutive failure
+	minRetryBackoff = 1 * time.Minute
+	maxRetryBackoff = 1 * time.Hour
+
+	// Retention of the sync history of each connector. Runs and item errors
+	// are kept for SyncHistoryRetention, and within the given counts.
+	SyncHistoryRetention = 30 * 24 * time.Hour
+	MaxSyncRunsKept      = 100
+	MaxSyncErrorsKept    = 1000
 )
 
+// ErrRetryNotSupported is returned when retrying the failed items of a
+// connector that cannot sync individual documents
+var ErrRetryNotSupported = errors.New("connector does not support retrying items")
+
 type Syncer struct {
+	// connectors is guarded by scheduleLock, as the scheduler goroutine
+	// reads it while connectors are added and deleted
 	connectors        map[string]types.Connector
 	syncCheckPeriod   time.Duration
-	staleThreshold    time.Duration
 	posthogClient     posthog.Client
 	posthogDistinctID string
 	credentials       types.BuildCredentials
 	version           string
 	store             types.Store
+
+	inventoryLock sync.Mutex
+	lastInventory map[string]time.Time
+
+	// syncSlots holds a token for every running sync
+	syncSlots chan struct{}
+	// wake triggers a scheduling round before the next check period
+	wake chan struct{}
+
+	scheduleLock sync.Mutex
+	// priority holds the connectors to sync ahead of the others, regardless
+	// of their schedule
+	priority map[string]bool
+	// cancels holds the cancel functions of running syncs
+	cancels map[string]context.CancelFunc
+	// retries holds the failed items to sync again in the next run of each
+	// connector, instead of a regular sync
+	retries map[string]*pendingRetry
+	// updating holds the connectors whose settings are being updated, which
+	// are not synced meanwhile
+	updating map[string]bool
+
+	// Events reports the progress of syncs as they run
+	Events *util.EventBus
+}
+
+// pendingRetry lists the failed items of a connector to sync again, and the
+// errors recorded for them, which are cleared when the retry starts
+type pendingRetry struct {
+	uniqueIDs []string
+	errorIDs  []string
 }
 
 func NewSyncer(posthogClient posthog.Client, posthogDistinctID string, creds types.BuildCredentials, version string, st types.Store) *Syncer {
 	return &Syncer{
 		connectors:        map[string]types.Connector{},
 		syncCheckPeriod:   1 * time.Minute,
-		staleThreshold:    1 * time.Minute,
 		posthogClient:     posthogClient,
 		posthogDistinctID: posthogDistinctID,
 		credentials:       creds,
 		version:           version,
 		store:             st,
+		lastInventory:     map[string]time.Time{},
+		syncSlots:         make(chan struct{}, MaxConcurrentSyncs),
+		wake:              make(chan struct{}, 1),
+		priority:          map[string]bool{},
+		cancels:           map[string]context.CancelFunc{},
+		retries:           map[string]*pendingRetry{},
+		updating:          map[string]bool{},
+		Events:            util.NewEventBus(),
 	}
 }
 
 func (s *Syncer) Init(ctx context.Context) error {
+	s.scheduleLock.Lock()
 	s.connectors = map[string]types.Connector{}
+	s.scheduleLock.Unlock()
 
 	states, err := s.store.AllConnectorStates(ctx)
 	if err != nil {
@@ -56,9 +130,11 @@ func (s *Syncer) Init(ctx context.Context) error {
 	}
 	count := 0
 	for _, state := range states {
-		constructor, ok := connectors.AllConnectors[state.ConnectorType]
+		constructor, ok := connectors.ConnectorConstructor(state.ConnectorType)
 		if !ok {
-			return fmt.Errorf("unknown connector type %s", state.ConnectorType)
+			// The plugin implementing it may have been removed
+			log.Printf("Skipping connector %s of unknown type %s", state.ConnectorID, state.ConnectorType)
+			continue
 		}
 		c := constructor(s.credentials, s.store)
 		err = c.Init(ctx, state.ConnectorID)
@@ -70,48 +146,92 @@ func (s *Syncer) Init(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("failed to add connector %s: %s", state.ConnectorID, err)
 		}
+		s.closeInterruptedRun(ctx, state.ConnectorID)
 	}
 
 	log.Printf("Syncer initialized with %d connectors from stored states", count)
 	return nil
 }
 
+// closeInterruptedRun records the outcome of a run left running when the app
+// last quit
+func (s *Syncer) closeInterruptedRun(ctx context.Context, connectorID string) {
+	runs, err := s.store.ListSyncRuns(ctx, connectorID, 1)
+	if err != nil {
+		log.Printf("Failed to get last sync run of connector %s: %s", connectorID, err)
+		return
+	}
+	if len(runs) == 0 || runs[0].Outcome != types.SyncOutcomeRunning {
+		return
+	}
+	run := runs[0]
+	run.Outcome = types.SyncOutcomeCancelled
+	run.Error = "interrupted"
+	err = s.store.UpdateSyncRun(ctx, run)
+	if err != nil {
+		log.Printf("Failed to update interrupted sync run of connector %s: %s", connectorID, err)
+	}
+}
+
 func (s *Syncer) AddConnector(c types.Connector) error {
+	s.scheduleLock.Lock()
 	_, ok := s.connectors[c.ID()]
 	if !ok {
 		s.connectors[c.ID()] = c
 	}
+	s.scheduleLock.Unlock()
+	if !ok {
+		s.wakeUp()
+	}
 	return nil
 }
 
 func (s *Syncer) GetConnector(id string) types.Connector {
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
 	return s.connectors[id]
 }
 
+// listConnectors returns a snapshot of the connectors, to call them without
+// holding the lock
+func (s *Syncer) listConnectors() []types.Connector {
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+	conns := make([]types.Connector, 0, len(s.connectors))
+	for _, c := range s.connectors {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
 func (s *Syncer) DeleteConnector(ctx context.Context, connectorID string) error {
-	connector, ok := s.connectors[connectorID]
-	if !ok {
+	connector := s.GetConnector(connectorID)
+	if connector == nil {
 		return fmt.Errorf("connector %s not found", connectorID)
 	}
 	connector.Cancel()
+	s.CancelSync(connectorID)
 	err := s.store.DeleteConnector(ctx, connector)
 	if err != nil {
 		return fmt.Errorf("failed to delete connector %s: %s", connectorID, err)
 	}
+	s.scheduleLock.Lock()
 	delete(s.connectors, connectorID)
+	s.scheduleLock.Unlock()
 	return nil
 }
 
 func (s *Syncer) GetConnectorStates(ctx context.Context, fetch_all bool) ([]*types.ConnectorState, error) {
 	states := []*types.ConnectorState{}
-	for _, c := range s.connectors {
+	for _, c := range s.listConnectors() {
 		state, err := c.Status(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get state for %s: %s", c.ID(), err)
 		}
 
-		// Fetch all if explicitly requested, else only ones with AuthValid
-		if fetch_all || state.AuthValid {
+		// Fetch all if explicitly requested, else only ones with AuthValid,
+		// or that the user has to authenticate again
+		if fetch_all || state.AuthValid || state.AuthError != "" {
 			states = append(states, state)
 		}
 	}
@@ -127,22 +247,322 @@ func (s *Syncer) GetConnectorStates(ctx context.Context, fetch_all bool) ([]*typ
 	return states, nil
 }
 
-// On launch, and after every sync_period, find all connectors that are not
-// actively syncing
+// Run starts the syncs of connectors as they become due, at most
+// MaxConcurrentSyncs at a time. Connectors are checked when the earliest one
+// is due, when a sync completes, and at least every syncCheckPeriod.
 func (s *Syncer) Run(ctx context.Context) error {
 	defer log.Printf("Syncer has stopped")
 	for {
+		wait := s.scheduleSyncs(ctx)
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(s.syncCheckPeriod):
-			// TODO clean stale connectors
-			err := s.SyncNow(ctx)
+		case <-time.After(wait):
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Syncer) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// A scheduling round is already pending
+	}
+}
+
+// SyncNow syncs all connectors ahead of their schedule, and returns their IDs
+func (s *Syncer) SyncNow() []string {
+	s.scheduleLock.Lock()
+	ids := []string{}
+	for id := range s.connectors {
+		s.priority[id] = true
+		ids = append(ids, id)
+	}
+	s.scheduleLock.Unlock()
+	s.wakeUp()
+	return ids
+}
+
+// PrioritizeSync syncs a connector as soon as a slot is available, ahead of
+// scheduled syncs. It is used right after a connector is authenticated, and
+// when the user requests a sync. Paused connectors are not synced.
+func (s *Syncer) PrioritizeSync(connectorID string) {
+	s.scheduleLock.Lock()
+	s.priority[connectorID] = true
+	s.scheduleLock.Unlock()
+	s.wakeUp()
+}
+
+// RetryFailedItems syncs again the items of a connector recorded as failed,
+// as soon as a slot is available. It returns the number of items to retry.
+func (s *Syncer) RetryFailedItems(ctx context.Context, c types.Connector) (int, error) {
+	if _, ok := c.(types.ItemRetrier); !ok {
+		return 0, ErrRetryNotSupported
+	}
+	syncErrors, err := s.store.ListSyncErrors(ctx, c.ID(), MaxSyncErrorsKept)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sync errors: %s", err)
+	}
+
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+	retry := s.retries[c.ID()]
+	if retry == nil {
+		retry = &pendingRetry{}
+	}
+	pending := map[string]bool{}
+	for _, uniqueID := range retry.uniqueIDs {
+		pending[uniqueID] = true
+	}
+	// Errors may already be pending from an earlier call
+	pendingErrors := map[string]bool{}
+	for _, errorID := range retry.errorIDs {
+		pendingErrors[errorID] = true
+	}
+	for _, syncErr := range syncErrors {
+		if syncErr.DocumentID == "" {
+			// Failures of whole sources are retried by regular syncs
+			continue
+		}
+		if !pending[syncErr.DocumentID] {
+			pending[syncErr.DocumentID] = true
+			retry.uniqueIDs = append(retry.uniqueIDs, syncErr.DocumentID)
+		}
+		if !pendingErrors[syncErr.ID] {
+			pendingErrors[syncErr.ID] = true
+			retry.errorIDs = append(retry.errorIDs, syncErr.ID)
+		}
+	}
+	if len(retry.uniqueIDs) == 0 {
+		return 0, nil
+	}
+
+	s.retries[c.ID()] = retry
+	s.priority[c.ID()] = true
+	s.wakeUp()
+	return len(retry.uniqueIDs), nil
+}
+
+// scheduleSyncs starts the syncs of due connectors while slots are available,
+// and returns how long to wait until the next connector is due
+func (s *Syncer) scheduleSyncs(ctx context.Context) time.Duration {
+	type candidate struct {
+		connector types.Connector
+		nextSync  time.Time
+		priority  bool
+	}
+
+	now := time.Now()
+	wait := s.syncCheckPeriod
+	candidates := []candidate{}
+
+	// The states are read without the lock, which would otherwise block the
+	// API for as long as the store takes to answer
+	s.scheduleLock.Lock()
+	idle := []types.Connector{}
+	for id, c := range s.connectors {
+		if s.cancels[id] == nil && !s.updating[id] {
+			idle = append(idle, c)
+		}
+	}
+	s.scheduleLock.Unlock()
+
+	skipped := []string{}
+	for _, c := range idle {
+		state, err := c.Status(ctx)
+		if err != nil {
+			log.Printf("Failed to get state of connector %s: %s", c.ID(), err)
+			continue
+		}
+		if !state.AuthValid || state.Paused {
+			skipped = append(skipped, c.ID())
+			continue
+		}
+		candidates = append(candidates, candidate{c, state.NextSync, false})
+	}
+
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+	for _, id := range skipped {
+		delete(s.priority, id)
+	}
+	due := []candidate{}
+	for _, cand := range candidates {
+		id := cand.connector.ID()
+		if s.connectors[id] == nil || s.cancels[id] != nil || s.updating[id] {
+			// Deleted, started or being updated meanwhile
+			continue
+		}
+		cand.priority = s.priority[id]
+		if cand.priority || !now.Before(cand.nextSync) {
+			due = append(due, cand)
+		} else if cand.nextSync.Sub(now) < wait {
+			wait = cand.nextSync.Sub(now)
+		}
+	}
+	candidates = due
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority
+		}
+		return candidates[i].nextSync.Before(candidates[j].nextSync)
+	})
+
+	for _, cand := range candidates {
+		select {
+		case s.syncSlots <- struct{}{}:
+		default:
+			// All slots are taken, the remaining connectors are checked
+			// again when a sync completes
+			return wait
+		}
+
+		c := cand.connector
+		syncCtx, cancel := context.WithCancel(ctx)
+		s.cancels[c.ID()] = cancel
+		delete(s.priority, c.ID())
+		go func() {
+			defer func() {
+				cancel()
+				<-s.syncSlots
+				s.scheduleLock.Lock()
+				delete(s.cancels, c.ID())
+				s.scheduleLock.Unlock()
+				s.wakeUp()
+			}()
+			err := s.syncConnector(syncCtx, c)
 			if err != nil {
-				log.Printf("Failed to sync: %s\n", err)
+				log.Printf("Error syncing %s %s: %s", c.Type(), c.ID(), err)
 			}
+		}()
+	}
+	return wait
+}
+
+// UpdateSettings updates the settings of a connector that is not syncing.
+// No sync of the connector starts until the settings, and the reset of the
+// sync position they may require, are stored.
+func (s *Syncer) UpdateSettings(ctx context.Context, c types.Connector, data []byte) error {
+	s.scheduleLock.Lock()
+	if s.cancels[c.ID()] != nil || s.updating[c.ID()] {
+		s.scheduleLock.Unlock()
+		return connectors.ErrSettingsWhileSyncing
+	}
+	s.updating[c.ID()] = true
+	s.scheduleLock.Unlock()
+
+	defer func() {
+		s.scheduleLock.Lock()
+		delete(s.updating, c.ID())
+		s.scheduleLock.Unlock()
+		s.wakeUp()
+	}()
+	return c.UpdateSettings(ctx, data)
+}
+
+// CancelSync stops the running sync of a connector, if any. The sync resumes
+// from its last persisted position on the next run. It returns false if the
+// connector was not syncing.
+func (s *Syncer) CancelSync(connectorID string) bool {
+	s.scheduleLock.Lock()
+	defer s.scheduleLock.Unlock()
+	cancel, ok := s.cancels[connectorID]
+	if ok {
+		log.Printf("Cancelling sync of connector %s", connectorID)
+		cancel()
+	}
+	return ok
+}
+
+// SetPaused pauses or resumes the syncs of a connector. Pausing cancels the
+// running sync, if any.
+func (s *Syncer) SetPaused(ctx context.Context, c types.Connector, paused bool) (*types.ConnectorState, error) {
+	state, err := s.store.SetConnectorPaused(ctx, c.ID(), paused)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update connector state: %s", err)
+	}
+
+	if paused {
+		s.CancelSync(c.ID())
+	} else {
+		s.wakeUp()
+	}
+	return state, nil
+}
+
+// SetSchedule changes the sync schedule of a connector, and when it is next
+// due accordingly
+func (s *Syncer) SetSchedule(ctx context.Context, c types.Connector, schedule string) (*types.ConnectorState, error) {
+	if schedule != "" {
+		_, err := util.ParseSchedule(schedule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := c.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connector state: %s", err)
+	}
+	// Only the schedule is written, so that the state saved meanwhile by a
+	// running sync is kept
+	state.Schedule = schedule
+	var next time.Time
+	if state.SyncFailures == 0 && !state.LastSync.IsZero() {
+		next = nextSync(state, state.LastSync)
+	}
+	state, err = s.store.SetConnectorSchedule(ctx, c.ID(), schedule, next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update connector state: %s", err)
+	}
+	s.wakeUp()
+	return state, nil
+}
+
+// nextSync returns when a connector is next due, after a sync that ended at
+// now. Failed syncs are retried after a jittered exponential backoff.
+func nextSync(state *types.ConnectorState, now time.Time) time.Time {
+	if state.SyncFailures > 0 {
+		backoff := minRetryBackoff
+		for i := 1; i < state.SyncFailures && backoff < maxRetryBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		// +/- 20%, so that connectors failing together do not retry together
+		jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(backoff))
+		return now.Add(backoff + jitter)
+	}
+
+	if state.Schedule != "" {
+		schedule, err := util.ParseSchedule(state.Schedule)
+		if err == nil {
+			return schedule.Next(now)
 		}
+		log.Printf("Ignoring invalid schedule of connector %s: %s", state.ConnectorID, err)
 	}
+	return now.Add(DefaultSyncInterval)
+}
+
+// publish sends an event about a connector to the subscribers of Events
+func (s *Syncer) publish(c types.Connector, event types.Event) {
+	event.Time = time.Now()
+	event.ConnectorID = c.ID()
+	event.ConnectorType = string(c.Type())
+	s.Events.Publish(event)
+}
+
+// publishRun sends an event carrying a snapshot of a run
+func (s *Syncer) publishRun(c types.Connector, eventType types.EventType, run *types.SyncRun) {
+	snapshot := *run
+	s.publish(c, types.Event{
+		Type:  eventType,
+		RunID: run.ID,
+		Run:   &snapshot,
+	})
 }
 
 func hash(text string) string {
@@ -155,26 +575,70 @@ type chunkAddResult struct {
 	numChunks    int
 	numDocuments int
 	err          error
+	// The document and the step that failed, if err is set
+	document types.Document
+	stage    string
+	cursor   *types.SyncCursor
 }
 
-func (s *Syncer) chunkAdder(ctx context.Context, chunkChan chan types.ChunkSyncResult, resChan chan chunkAddResult) {
+func (s *Syncer) chunkAdder(ctx context.Context, c types.Connector, runID string, chunkChan chan types.ChunkSyncResult, resChan chan chunkAddResult) {
 	defer close(resChan)
+	// Progress of the run, reported with events
+	seen := map[string]bool{}
+	numChunks := 0
 	// TODO: hold buffer and add vectors in batches
 	for res := range chunkChan {
+		if ctx.Err() != nil {
+			// Cancelled: the remaining chunks are dropped, and so are the
+			// cursors after them, so that the next sync fetches them again
+			continue
+		}
+		if res.Cursor != nil {
+			// Passed on in order, so that it is only persisted after the
+			// chunks sent before it
+			resChan <- chunkAddResult{cursor: res.Cursor}
+			continue
+		}
+		if res.Tombstone != "" {
+			deleted := s.deleteTombstone(ctx, res.Tombstone)
+			if deleted.err == nil || ctx.Err() == nil {
+				resChan <- deleted
+			}
+			continue
+		}
 		if res.Err != nil {
 			resChan <- chunkAddResult{
-				err: fmt.Errorf("error processing chunk: %s", res.Err),
+				err:      res.Err,
+				document: res.Chunk.Document,
+				stage:    res.Stage,
 			}
 			continue
 		}
 		chunk := res.Chunk
+		if !seen[chunk.UniqueID] {
+			seen[chunk.UniqueID] = true
+			s.publish(c, types.Event{
+				Type:         types.EventDocumentProcessed,
+				RunID:        runID,
+				DocumentID:   chunk.UniqueID,
+				DocumentName: chunk.Name,
+				NumDocuments: len(seen),
+				NumChunks:    numChunks,
+			})
+		}
 
-		saneChunk := chunk.Text
-		saneName := chunk.Name
-		if !res.SkipClean {
-			saneChunk = util.CleanChunk(chunk.Text)
-			saneName = util.CleanChunk(chunk.Name)
+		sanitizer, err := util.SanitizerFor(chunk.ConnectorType)
+		if err != nil {
+			resChan <- chunkAddResult{
+				err:      fmt.Errorf("failed to get sanitizer: %s", err),
+				document: chunk.Document,
+				stage:    types.SyncStageIndex,
+			}
+			continue
 		}
+		sanitized := sanitizer.Sanitize(chunk.Text)
+		saneChunk := sanitized.Text
+		saneName := util.CleanChunk(chunk.Name)
 		log.Printf("New chunk, length: %d, sanitized: %d\n", len(chunk.Text), len(saneChunk))
 		if len(saneChunk) < MinChunkSize {
 			log.Printf("Skipping short chunk: %s\n", saneChunk)
@@ -183,9 +647,14 @@ func (s *Syncer) chunkAdder(ctx context.Context, chunkChan chan types.ChunkSyncR
 
 		chunkHash := hash(saneChunk)
 		exists, err := s.store.ChunkHashExists(ctx, chunkHash)
+		if err != nil && ctx.Err() != nil {
+			continue
+		}
 		if err != nil && !store.IsErrChunkNotFound(err) {
 			resChan <- chunkAddResult{
-				err: fmt.Errorf("failed to check chunk hash: %s", err),
+				err:      fmt.Errorf("failed to check chunk hash: %s", err),
+				document: chunk.Document,
+				stage:    types.SyncStageIndex,
 			}
 			continue
 		}
@@ -194,7 +663,9 @@ func (s *Syncer) chunkAdder(ctx context.Context, chunkChan chan types.ChunkSyncR
 			continue
 		}
 
+		chunk.RawText = chunk.Text
 		chunk.Text = saneChunk
+		chunk.URLs = sanitized.URLs
 		chunk.Name = saneName
 		chunk.Hash = chunkHash
 		addResp, err := s.store.AddVectors(ctx, []types.AddVectorItem{
@@ -202,9 +673,15 @@ func (s *Syncer) chunkAdder(ctx context.Context, chunkChan chan types.ChunkSyncR
 				Chunk: chunk,
 			},
 		})
+		if err != nil && ctx.Err() != nil {
+			// Not an error of the chunk, it is synced again next time
+			continue
+		}
 		if err != nil {
 			resChan <- chunkAddResult{
-				err: fmt.Errorf("failed to add vector: %s", err),
+				err:      fmt.Errorf("failed to add vector: %s", err),
+				document: chunk.Document,
+				stage:    types.SyncStageIndex,
 			}
 			continue
 		}
@@ -213,11 +690,117 @@ func (s *Syncer) chunkAdder(ctx context.Context, chunkChan chan types.ChunkSyncR
 			numChunks:    addResp.NumChunksAdded,
 			numDocuments: addResp.NumDocsAdded,
 		}
+		numChunks += addResp.NumChunksAdded
+		s.publish(c, types.Event{
+			Type:         types.EventChunkAdded,
+			RunID:        runID,
+			DocumentID:   chunk.UniqueID,
+			DocumentName: chunk.Name,
+			NumDocuments: len(seen),
+			NumChunks:    numChunks,
+		})
 		log.Printf("Added %d chunks, %d documents for source URL: %s\n", addResp.NumChunksAdded, addResp.NumDocsAdded, chunk.SourceURL)
 	}
 }
 
-func (s *Syncer) updateState(ctx context.Context, c types.Connector, numChunks, numDocs, numErrors int) {
+// deleteTombstone removes a document deleted at the source, returning the
+// negative counts of the removed objects
+func (s *Syncer) deleteTombstone(ctx context.Context, uniqueID string) chunkAddResult {
+	docID, err := s.store.GetDocumentID(ctx, uniqueID)
+	if err != nil {
+		return chunkAddResult{
+			err:      fmt.Errorf("failed to get deleted document %s: %s", uniqueID, err),
+			document: types.Document{UniqueID: uniqueID},
+			stage:    types.SyncStageDelete,
+		}
+	}
+	if docID == "" {
+		// Never indexed, or already deleted
+		return chunkAddResult{}
+	}
+
+	numChunks, err := s.store.DeleteDocumentById(ctx, docID)
+	if err != nil {
+		return chunkAddResult{
+			numChunks: -numChunks,
+			err:       fmt.Errorf("failed to delete document %s: %s", uniqueID, err),
+			document:  types.Document{UniqueID: uniqueID},
+			stage:     types.SyncStageDelete,
+		}
+	}
+	log.Printf("Deleted document %s, removed at the source", uniqueID)
+	return chunkAddResult{
+		numChunks:    -numChunks,
+		numDocuments: -1,
+	}
+}
+
+// reconcileInventory deletes the stored documents of a connector that no
+// longer exist at the source. It runs at most once per InventoryPeriod for
+// each connector.
+func (s *Syncer) reconcileInventory(ctx context.Context, c types.Connector) error {
+	lister, ok := c.(types.InventoryLister)
+	if !ok {
+		return nil
+	}
+
+	s.inventoryLock.Lock()
+	last := s.lastInventory[c.ID()]
+	s.inventoryLock.Unlock()
+	if time.Since(last) < InventoryPeriod {
+		return nil
+	}
+
+	inventory, err := lister.ListInventory(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list inventory: %s", err)
+	}
+	stored, err := s.store.ListDocumentIDs(ctx, c.ID())
+	if err != nil {
+		return fmt.Errorf("failed to list stored documents: %s", err)
+	}
+
+	live := map[string]bool{}
+	for _, uniqueID := range inventory {
+		live[uniqueID] = true
+	}
+	deleted := map[string]string{}
+	for uniqueID, docID := range stored {
+		if !live[uniqueID] {
+			deleted[uniqueID] = docID
+		}
+	}
+
+	if len(inventory) == 0 && len(stored) > 0 {
+		log.Printf("Inventory of %s %s is empty, keeping its %d documents", c.Type(), c.ID(), len(stored))
+		return nil
+	}
+	if len(stored) >= inventoryMinCheckedDocuments && float64(len(deleted)) > InventoryMaxDeletedRatio*float64(len(stored)) {
+		log.Printf("Inventory of %s %s would delete %d of its %d documents, keeping them", c.Type(), c.ID(), len(deleted), len(stored))
+		return nil
+	}
+
+	numChunks := 0
+	numDocs := 0
+	for uniqueID, docID := range deleted {
+		n, err := s.store.DeleteDocumentById(ctx, docID)
+		numChunks += n
+		if err != nil {
+			log.Printf("Failed to delete document %s: %s", uniqueID, err)
+			continue
+		}
+		numDocs++
+	}
+	log.Printf("Inventory of %s %s: %d live documents, deleted %d documents and %d chunks", c.Type(), c.ID(), len(inventory), numDocs, numChunks)
+	s.updateState(ctx, c, -numChunks, -numDocs, 0, nil)
+
+	s.inventoryLock.Lock()
+	s.lastInventory[c.ID()] = time.Now()
+	s.inventoryLock.Unlock()
+	return nil
+}
+
+func (s *Syncer) updateState(ctx context.Context, c types.Connector, numChunks, numDocs, numErrors int, cursors map[string]string) {
 	state, err := c.Status(ctx)
 	if err != nil {
 		log.Printf("Failed to get status: %s\n", err)
@@ -226,6 +809,16 @@ func (s *Syncer) updateState(ctx context.Context, c types.Connector, numChunks,
 	state.NumChunks += numChunks
 	state.NumDocuments += numDocs
 	state.NumErrors += numErrors
+	if len(cursors) > 0 && state.SyncCursors == nil {
+		state.SyncCursors = map[string]string{}
+	}
+	for key, value := range cursors {
+		if value == "" {
+			delete(state.SyncCursors, key)
+		} else {
+			state.SyncCursors[key] = value
+		}
+	}
 	err = c.UpdateConnectorState(ctx, state)
 	if err != nil {
 		log.Printf("Failed to update status: %s\n", err)
@@ -233,46 +826,70 @@ func (s *Syncer) updateState(ctx context.Context, c types.Connector, numChunks,
 	}
 }
 
-func (s *Syncer) stateUpdater(ctx context.Context, c types.Connector, resChan chan chunkAddResult, doneChan chan struct{}) {
+// stateUpdater persists the counts and cursors reported by the chunkAdder,
+// records item errors, and adds up the totals of the run
+func (s *Syncer) stateUpdater(ctx context.Context, c types.Connector, run *types.SyncRun, resChan chan chunkAddResult, doneChan chan struct{}) {
 	defer close(doneChan)
 
-	// countChan is expected to close before errChunkChan when the sync completes
-	numErrors := 0
-	numChunks := 0
-	numDocs := 0
-	updateEvery := 10 // Number of chunks after which we should update the state
+	// Counts, errors and cursors are persisted even once the sync is
+	// cancelled, as they describe the chunks already added
+	persistCtx := context.WithoutCancel(ctx)
+	updateEvery := 10 // Number of results after which we should update the state
 	counts := []chunkAddResult{}
+	cursors := map[string]string{}
+	syncErrors := []*types.SyncError{}
 
-	for res := range resChan {
-		if res.err == nil {
-			counts = append(counts, res)
-		} else {
-			log.Printf("Error processing chunk: %s\n", res.err)
-			numErrors++
-		}
-
-		if len(counts)+numErrors < updateEvery {
-			continue
-		}
-
-		numChunks = 0
-		numDocs = 0
+	flush := func() {
+		numChunks := 0
+		numDocs := 0
 		for _, prevCount := range counts {
 			numChunks += prevCount.numChunks
 			numDocs += prevCount.numDocuments
 		}
-		s.updateState(ctx, c, numChunks, numDocs, numErrors)
+		s.updateState(persistCtx, c, numChunks, numDocs, len(syncErrors), cursors)
+		err := s.store.AddSyncErrors(persistCtx, syncErrors)
+		if err != nil {
+			log.Printf("Failed to record sync errors: %s\n", err)
+		}
+
+		run.NumChunks += numChunks
+		run.NumDocuments += numDocs
+		run.NumErrors += len(syncErrors)
 		counts = []chunkAddResult{}
-		numErrors = 0
+		cursors = map[string]string{}
+		syncErrors = []*types.SyncError{}
 	}
 
-	numChunks = 0
-	numDocs = 0
-	for _, prevCount := range counts {
-		numChunks += prevCount.numChunks
-		numDocs += prevCount.numDocuments
+	for res := range resChan {
+		if res.cursor != nil {
+			cursors[res.cursor.Key] = res.cursor.Value
+		} else if res.err == nil {
+			counts = append(counts, res)
+		} else {
+			log.Printf("Error processing chunk: %s\n", res.err)
+			syncErr := types.SyncError{
+				ConnectorID:  c.ID(),
+				RunID:        run.ID,
+				DocumentID:   res.document.UniqueID,
+				DocumentName: res.document.Name,
+				Stage:        res.stage,
+				Error:        res.err.Error(),
+				CreatedAt:    time.Now(),
+			}
+			syncErrors = append(syncErrors, &syncErr)
+			snapshot := syncErr
+			s.publish(c, types.Event{
+				Type:  types.EventSyncError,
+				RunID: run.ID,
+				Error: &snapshot,
+			})
+		}
+
+		if len(counts)+len(cursors)+len(syncErrors) >= updateEvery {
+			flush()
+		}
 	}
-	s.updateState(ctx, c, numChunks, numDocs, numErrors)
+	flush()
 }
 
 func copyState(state *types.ConnectorState) (*types.ConnectorState, error) {
@@ -289,7 +906,9 @@ func copyState(state *types.ConnectorState) (*types.ConnectorState, error) {
 	return newState, nil
 }
 
-func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *types.ConnectorState) error {
+// connectorSync runs a sync of a connector, or a retry of its failed items
+// if retry is set, and records it in the sync history
+func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *types.ConnectorState, retry *pendingRetry) error {
 	// Keep a copy of the current connector state to calculate diffs
 	prevState, err := copyState(state)
 	if err != nil {
@@ -297,6 +916,24 @@ func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *ty
 	}
 
 	syncStartTime := time.Now()
+	run := &types.SyncRun{
+		ConnectorID: c.ID(),
+		Retry:       retry != nil,
+		StartedAt:   syncStartTime,
+		Outcome:     types.SyncOutcomeRunning,
+	}
+	err = s.store.AddSyncRun(ctx, run)
+	if err != nil {
+		log.Printf("Failed to record sync run of %s %s: %s", c.Type(), c.ID(), err)
+	}
+	if retry != nil {
+		// Items failing again are recorded anew
+		err = s.store.DeleteSyncErrors(ctx, retry.errorIDs)
+		if err != nil {
+			log.Printf("Failed to clear retried sync errors of %s %s: %s", c.Type(), c.ID(), err)
+		}
+	}
+	s.publishRun(c, types.EventSyncStarted, run)
 
 	// The channel where all chunks are sent. Closed by c.Sync when done
 	chunkChan := make(chan types.ChunkSyncResult)
@@ -318,25 +955,20 @@ func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *ty
 	// - Fetches from the connector and document conversions (in Sync)
 	// - Embeddings generation and addition to weaviate (in chunkAdder)
 	// - Periodic updates to the connector state (in stateUpdater)
-	go c.Sync(state.LastSync, chunkChan, errChanSync)
-	go s.chunkAdder(ctx, chunkChan, chunkAddResChan)
-	go s.stateUpdater(ctx, c, chunkAddResChan, doneChan)
+	if retry != nil {
+		log.Printf("Retrying %d failed items of %s %s", len(retry.uniqueIDs), c.Type(), c.ID())
+		go c.(types.ItemRetrier).RetryItems(ctx, retry.uniqueIDs, chunkChan, errChanSync)
+	} else {
+		go c.Sync(ctx, state.LastSync, chunkChan, errChanSync)
+	}
+	go s.chunkAdder(ctx, c, run.ID, chunkChan, chunkAddResChan)
+	go s.stateUpdater(ctx, c, run, chunkAddResChan, doneChan)
 
 	syncError := ""
+	done := false
 	select {
 	case <-ctx.Done():
-		// The sync time has not been updated, so the next sync will pick up the same chunks
-		log.Printf("Syncer: Context cancelled")
-		state, err := c.Status(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to get status for %s: %s", c.ID(), err)
-		}
-		state.Syncing = false
-		err = c.UpdateConnectorState(ctx, state)
-		if err != nil {
-			return fmt.Errorf("unable to update last sync for %s: %s", c.ID(), err)
-		}
-		break
+		log.Printf("Sync for connector %s %s cancelled", c.Type(), c.ID())
 	case err := <-errChanSync:
 		if err != nil {
 			log.Printf("Sync for connector %s %s completed with error: %s", c.Type(), c.ID(), err)
@@ -345,15 +977,59 @@ func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *ty
 			log.Printf("Unexpected close for errChanSync")
 		}
 	case <-doneChan:
+		done = true
+	}
+
+	// Wait for the connector and the pipeline to stop, so that their last
+	// state updates do not race with the final one, and so that no error is
+	// sent after errChanSync is closed
+	for !done {
+		select {
+		case err := <-errChanSync:
+			log.Printf("Sync for connector %s %s: %s", c.Type(), c.ID(), err)
+		case <-doneChan:
+			done = true
+		}
+	}
+
+	cancelled := ctx.Err() != nil
+	// The outcome of the sync is recorded even if it was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	if syncError == "" && !cancelled && retry == nil {
+		err = s.reconcileInventory(ctx, c)
+		if err != nil {
+			log.Printf("Failed to reconcile deletions for %s %s: %s", c.Type(), c.ID(), err)
+		}
 	}
 
 	state, err = c.Status(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get status for %s: %s", c.ID(), err)
 	}
-	if syncError == "" {
-		// Only update the sync time if the overall sync was successful (even if there were chunk errors)
-		state.LastSync = syncStartTime
+	run.Error = syncError
+	switch {
+	case cancelled:
+		// The sync time is not updated, so the next sync picks up from the
+		// last persisted cursors
+		syncError = "cancelled"
+		run.Outcome = types.SyncOutcomeCancelled
+	case syncError == "":
+		run.Outcome = types.SyncOutcomeSuccess
+		if retry == nil {
+			// Only update the sync time if the overall sync was successful (even if there were chunk errors)
+			state.LastSync = syncStartTime
+			state.SyncFailures = 0
+		}
+	default:
+		run.Outcome = types.SyncOutcomeFailed
+		if retry == nil {
+			state.SyncFailures++
+		}
+	}
+	if retry == nil {
+		// Retries leave the schedule of regular syncs unchanged
+		state.NextSync = nextSync(state, time.Now())
 	}
 	state.Syncing = false
 	err = c.UpdateConnectorState(ctx, state)
@@ -361,6 +1037,15 @@ func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *ty
 		return fmt.Errorf("unable to update last sync for %s: %s", c.ID(), err)
 	}
 	syncDoneTime := time.Now()
+	s.recordRun(ctx, c, run, syncDoneTime)
+	s.publishRun(c, types.EventSyncFinished, run)
+	if prevState.AuthValid && !state.AuthValid {
+		s.publish(c, types.Event{
+			Type:      types.EventAuthInvalidated,
+			RunID:     run.ID,
+			AuthError: state.AuthError,
+		})
+	}
 
 	// Only report sync events if the state has changed to avoid spamming posthog
 	num_synced_chunks := state.NumChunks - prevState.NumChunks
@@ -409,71 +1094,50 @@ func (s *Syncer) connectorSync(ctx context.Context, c types.Connector, state *ty
 	return nil
 }
 
-func (s *Syncer) ASyncNow(ctx context.Context) {
-	log.Printf("Attempting async sync")
-	go func() {
-		err := s.SyncNow(ctx)
-		if err != nil {
-			log.Printf("Failed to sync: %s\n", err)
-		}
-	}()
-}
-
-// maybeSyncConnector returns an error only if the entire sync should halt
-func (s *Syncer) maybeSyncConnector(ctx context.Context, wg *sync.WaitGroup, c types.Connector) error {
-	log.Printf("Checking status for connector %s %s\n", c.Type(), c.ID())
-
+// syncConnector syncs a connector, which the scheduler only runs once at a
+// time
+func (s *Syncer) syncConnector(ctx context.Context, c types.Connector) error {
 	state, err := s.store.SetConnectorSyncing(ctx, c.ID(), true)
 	if store.IsSyncingAlreadyExpected(err) {
-		log.Printf("Connector %s %s already syncing", c.Type(), c.ID())
-		return nil
+		// Syncs of a connector are only started one at a time, so the flag
+		// was left by a sync that did not complete
+		log.Printf("Clearing stale syncing state of %s %s", c.Type(), c.ID())
+		err = nil
 	}
 	if err != nil {
 		return fmt.Errorf("failed to set connector %s %s to syncing state: %s", c.Type(), c.ID(), err)
 	}
-	unlock := true
 
-	if !state.AuthValid {
-		log.Printf("Auth required for %s %s", c.Type(), c.ID())
-	} else {
-		if time.Since(state.LastSync) > s.staleThreshold {
-			log.Printf("Sync required for %s %s", c.Type(), c.ID())
-			unlock = false
-			wg.Add(1)
-			go func(c types.Connector) {
-				defer wg.Done()
-				new_err := s.connectorSync(ctx, c, state)
-				if new_err != nil {
-					log.Printf("Error syncing %s %s: %s", c.Type(), c.ID(), new_err)
-				}
-			}(c)
-		} else {
-			log.Printf("Sync not required for %s", c.ID())
-		}
-	}
+	s.scheduleLock.Lock()
+	retry := s.retries[c.ID()]
+	delete(s.retries, c.ID())
+	s.scheduleLock.Unlock()
 
-	// Unlock syncing state
-	if unlock {
-		_, err = s.store.SetConnectorSyncing(ctx, c.ID(), false)
-		if err != nil {
-			log.Printf("Failed to set connector %s %s to not syncing state: %s", c.Type(), c.ID(), err)
+	log.Printf("Sync required for %s %s", c.Type(), c.ID())
+	err = s.connectorSync(ctx, c, state, retry)
+	if err != nil {
+		// The sync stopped before recording its end
+		_, clearErr := s.store.SetConnectorSyncing(context.WithoutCancel(ctx), c.ID(), false)
+		if clearErr != nil && !store.IsSyncingAlreadyExpected(clearErr) {
+			log.Printf("Failed to clear syncing state of %s %s: %s", c.Type(), c.ID(), clearErr)
 		}
 	}
-
-	return nil
+	return err
 }
 
-func (s *Syncer) SyncNow(ctx context.Context) error {
-	// TODO: Debug logging
-	// log.Printf("SyncNow started")
-	wg := sync.WaitGroup{}
-	for _, c := range s.connectors {
-		err := s.maybeSyncConnector(ctx, &wg, c)
+// recordRun stores the outcome of a run, and prunes the sync history of the
+// connector
+func (s *Syncer) recordRun(ctx context.Context, c types.Connector, run *types.SyncRun, endedAt time.Time) {
+	run.EndedAt = endedAt
+	if run.ID != "" {
+		err := s.store.UpdateSyncRun(ctx, run)
 		if err != nil {
-			return fmt.Errorf("failed to trigger sync for connector %s %s: %s", c.Type(), c.ID(), err)
+			log.Printf("Failed to record sync run of %s %s: %s", c.Type(), c.ID(), err)
 		}
 	}
-	wg.Wait()
-	// log.Printf("SyncNow complete")
-	return nil
+
+	err := s.store.PruneSyncHistory(ctx, c.ID(), MaxSyncRunsKept, MaxSyncErrorsKept, endedAt.Add(-SyncHistoryRetention))
+	if err != nil {
+		log.Printf("Failed to prune sync history of %s %s: %s", c.Type(), c.ID(), err)
+	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// fakeSyncStore serves the sync errors of a connector, the other methods of
+// the store are not expected to be called
+type fakeSyncStore struct {
+	types.Store
+	syncErrors []*types.SyncError
+}
+
+func (f *fakeSyncStore) ListSyncErrors(ctx context.Context, connectorID string, limit int) ([]*types.SyncError, error) {
+	return f.syncErrors, nil
+}
+
+type fakeRetrier struct {
+	types.Connector
+}
+
+func (f *fakeRetrier) ID() string {
+	return "connector"
+}
+
+func (f *fakeRetrier) RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan types.ChunkSyncResult, errChan chan error) {
+	close(chunkChan)
+}
+
+func TestRetryFailedItems(t *testing.T) {
+	st := &fakeSyncStore{
+		syncErrors: []*types.SyncError{
+			{ID: "e1", DocumentID: "doc1"},
+			{ID: "e2", DocumentID: "doc1"},
+			{ID: "e3", DocumentID: "doc2"},
+			// Failure of a whole source
+			{ID: "e4"},
+		},
+	}
+	s := NewSyncer(nil, "", types.BuildCredentials{}, "", st)
+	c := &fakeRetrier{}
+
+	for i := 0; i < 2; i++ {
+		n, err := s.RetryFailedItems(context.Background(), c)
+		if err != nil {
+			t.Fatalf("RetryFailedItems: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("call %d: %d items to retry, want 2", i, n)
+		}
+	}
+
+	retry := s.retries[c.ID()]
+	if want := []string{"doc1", "doc2"}; !slices.Equal(retry.uniqueIDs, want) {
+		t.Errorf("uniqueIDs = %v, want %v", retry.uniqueIDs, want)
+	}
+	if want := []string{"e1", "e2", "e3"}; !slices.Equal(retry.errorIDs, want) {
+		t.Errorf("errorIDs = %v, want %v", retry.errorIDs, want)
+	}
+	if !s.priority[c.ID()] {
+		t.Error("connector was not prioritized")
+	}
+}
@@ -32,18 +32,70 @@ type Connector interface {
 
 	UpdateConnectorState(ctx context.Context, state *ConnectorState) error
 	Status(ctx context.Context) (*ConnectorState, error)
-	AuthSetup(ctx context.Context) error
-	AuthCallback(ctx context.Context, code string) error
-	Sync(lastSync time.Time, chunkChan chan ChunkSyncResult, errChan chan error)
+
+	// Settings returns the user configuration of the connector, and
+	// SettingsSchema describes it as a JSON schema
+	Settings() interface{}
+	SettingsSchema() map[string]interface{}
+	// UpdateSettings validates and stores JSON encoded settings, which apply
+	// from the next sync
+	UpdateSettings(ctx context.Context, settings []byte) error
+
+	// AuthSetup starts an OAuth flow with a new auth session, unless the
+	// connector already has a token, and returns the URL for the user to
+	// visit, if any. AuthCallback completes it with the code returned by the
+	// provider and the PKCE code verifier of the session.
+	AuthSetup(ctx context.Context) (string, error)
+	AuthCallback(ctx context.Context, code string, verifier string) error
+	// Sync sends the documents changed since lastSync to chunkChan, and
+	// closes it when done. Cancelling ctx stops the sync.
+	Sync(ctx context.Context, lastSync time.Time, chunkChan chan ChunkSyncResult, errChan chan error)
 }
 
 type ChunkSyncResult struct {
 	Chunk Chunk
+	// Err reports an item that failed to sync. Chunk.Document identifies the
+	// item, if known, and Stage is the step that failed, one of the
+	// SyncStage constants.
 	Err   error
+	Stage string
+	// Cursor, if set, is persisted in the connector state once all
+	// previously sent chunks have been processed. Results carrying a cursor
+	// do not carry a chunk.
+	Cursor *SyncCursor
+	// Tombstone, if set, is the UniqueID of a document that was deleted at
+	// the source. Its document and chunks are removed from the store.
+	Tombstone string
+}
+
+// Steps of the sync of an item, reported with its errors
+const (
+	SyncStageFetch  = "fetch"
+	SyncStageParse  = "parse"
+	SyncStageIndex  = "index"
+	SyncStageDelete = "delete"
+)
+
+// ItemRetrier is implemented by connectors that can sync individual documents
+// again, to retry those that failed in previous syncs
+type ItemRetrier interface {
+	// RetryItems syncs the documents with the given UniqueIDs, and follows
+	// the same contract as Sync
+	RetryItems(ctx context.Context, uniqueIDs []string, chunkChan chan ChunkSyncResult, errChan chan error)
+}
+
+// InventoryLister is implemented by connectors that can list the UniqueIDs of
+// all their documents that still exist at the source. After a successful
+// sync the syncer periodically deletes the stored documents of the connector
+// that are missing from the inventory. Connectors that report tombstones for
+// all deletions do not need to implement it.
+type InventoryLister interface {
+	ListInventory(ctx context.Context) ([]string, error)
+}
 
-	// if SkipClean is set to true, the chunk will not be cleaned by the syncer
-	// This is used in connectors such as Slack where newlines are needed to
-	// indicate different messages. The connector takes over the responsibility
-	// of sanitizing the content appropriately with util.CleanChunk
-	SkipClean bool
+// SyncCursor records how far a connector has synced one of its sources, or
+// checkpoints a listing in progress. An empty Value removes the cursor.
+type SyncCursor struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
 }
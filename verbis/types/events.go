@@ -0,0 +1,40 @@
+package types
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	EventSyncStarted       EventType = "sync_started"
+	EventDocumentProcessed EventType = "document_processed"
+	EventChunkAdded        EventType = "chunk_added"
+	EventSyncError         EventType = "sync_error"
+	EventSyncFinished      EventType = "sync_finished"
+	EventAuthInvalidated   EventType = "auth_invalidated"
+)
+
+// Event reports the progress of the syncs of a connector
+type Event struct {
+	Type          EventType `json:"type"`
+	Time          time.Time `json:"time"`
+	ConnectorID   string    `json:"connector_id"`
+	ConnectorType string    `json:"connector_type"`
+	RunID         string    `json:"run_id,omitempty"`
+
+	// Run is set on sync_started and sync_finished
+	Run *SyncRun `json:"run,omitempty"`
+	// The document being processed, on document_processed and chunk_added
+	DocumentID   string `json:"document_id,omitempty"`
+	DocumentName string `json:"document_name,omitempty"`
+	// NumDocuments and NumChunks count the documents processed and the
+	// chunks added so far in the run, on document_processed and chunk_added
+	NumDocuments int `json:"num_documents,omitempty"`
+	NumChunks    int `json:"num_chunks,omitempty"`
+	// Error is set on sync_error
+	Error *SyncError `json:"error,omitempty"`
+	// AuthError is why the user has to authenticate again, on
+	// auth_invalidated
+	AuthError string `json:"auth_error,omitempty"`
+}
@@ -2,14 +2,17 @@ package types
 
 import (
 	"context"
+	"time"
 )
 
 type Store interface {
 	ChunkHashExists(ctx context.Context, hash string) (bool, error)
 	GetChunkByHash(ctx context.Context, hash string) (*Chunk, error)
+	GetChunkNeighbours(ctx context.Context, chunks []*Chunk, window int) (map[string][]*Chunk, error)
 	GetDocument(ctx context.Context, uniqueID string) (*Document, error)
+	GetDocumentChunks(ctx context.Context, uniqueID string) ([]*Chunk, error)
 	AddVectors(ctx context.Context, items []AddVectorItem) (*AddVectorResponse, error)
-	HybridSearch(ctx context.Context, query string, vector []float32) ([]*Chunk, error)
+	HybridSearch(ctx context.Context, index *EmbeddingIndex, query string, vector []float32) ([]*Chunk, error)
 	UpdateConfig(ctx context.Context, cfg *Config) error
 	GetConfig(ctx context.Context) (*Config, error)
 	CreateConfigClass(ctx context.Context, force bool) error
@@ -23,12 +26,47 @@ type Store interface {
 	ConversationAppend(ctx context.Context, conversationID string, items []HistoryItem, chunks []*Chunk) error
 	SetConnectorSyncing(ctx context.Context, connectorID string, syncing bool) (*ConnectorState, error)
 	UpdateConnectorState(ctx context.Context, state *ConnectorState) error
+	SetConnectorPaused(ctx context.Context, connectorID string, paused bool) (*ConnectorState, error)
+	SetConnectorSchedule(ctx context.Context, connectorID string, schedule string, nextSync time.Time) (*ConnectorState, error)
+	SetConnectorAuth(ctx context.Context, connectorID string, valid bool, authError string) error
 	AllConnectorStates(ctx context.Context) ([]*ConnectorState, error)
 	GetConnectorState(ctx context.Context, connectorID string) (*ConnectorState, error)
-	DeleteDocumentById(ctx context.Context, documentId string) error
-	DeleteDocumentChunksById(ctx context.Context, documentId string) error
+	CreateConnectorSettingsClass(ctx context.Context, force bool) error
+	GetConnectorSettings(ctx context.Context, connectorID string) ([]byte, error)
+	UpdateConnectorSettings(ctx context.Context, connectorID string, settings []byte) error
+	CreateSyncRunClass(ctx context.Context, force bool) error
+	CreateSyncErrorClass(ctx context.Context, force bool) error
+	AddSyncRun(ctx context.Context, run *SyncRun) error
+	UpdateSyncRun(ctx context.Context, run *SyncRun) error
+	ListSyncRuns(ctx context.Context, connectorID string, limit int) ([]*SyncRun, error)
+	AddSyncErrors(ctx context.Context, syncErrors []*SyncError) error
+	ListSyncErrors(ctx context.Context, connectorID string, limit int) ([]*SyncError, error)
+	DeleteSyncErrors(ctx context.Context, ids []string) error
+	PruneSyncHistory(ctx context.Context, connectorID string, maxRuns, maxErrors int, before time.Time) error
+	GetDocumentID(ctx context.Context, uniqueID string) (string, error)
+	ListDocumentIDs(ctx context.Context, connectorID string) (map[string]string, error)
+	DeleteDocumentById(ctx context.Context, documentId string) (int, error)
+	DeleteDocumentChunksById(ctx context.Context, documentId string) (int, error)
 	DeleteDocumentChunks(ctx context.Context, uniqueID string, connectorID string) error
 	DeleteConnector(ctx context.Context, connector Connector) error
+	ActiveEmbeddingIndex() *EmbeddingIndex
+	ListEmbeddingIndexes(ctx context.Context) ([]*EmbeddingIndex, error)
+	UsesWeaviateVectorizer(ctx context.Context) (bool, error)
+	CreateEmbeddingIndex(ctx context.Context, model string) (*EmbeddingIndex, error)
+	UpdateEmbeddingIndex(ctx context.Context, index *EmbeddingIndex) error
+	CountChunks(ctx context.Context) (int, error)
+	CopyChunks(ctx context.Context, index *EmbeddingIndex, limit int) (int, error)
+	ActivateEmbeddingIndex(ctx context.Context, id string) error
+	RollbackEmbeddingIndex(ctx context.Context) error
+	DeleteEmbeddingIndex(ctx context.Context, id string) error
+	FailEmbeddingIndex(ctx context.Context, index *EmbeddingIndex, buildErr error) error
+}
+
+// Embedder computes the vectors of chunks and queries with the model of an
+// index, so that both are embedded the same way
+type Embedder interface {
+	EmbedDocuments(ctx context.Context, index *EmbeddingIndex, texts []string) ([][]float32, error)
+	EmbedQuery(ctx context.Context, index *EmbeddingIndex, text string) ([]float32, error)
 }
 
 type AddVectorResponse struct {
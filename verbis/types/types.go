@@ -4,16 +4,21 @@ import (
 	"time"
 )
 
-// Add a vector to Weaviate
+// Add a chunk to Weaviate, embedded by the store with the model of each index
 type AddVectorItem struct {
 	Chunk
-	Vector []float32
 }
 
 type Source struct {
 	Title string `json:"title"`
 	URL   string `json:"url"`
 	Type  string `json:"type"`
+
+	// Location of the cited chunk within the document, when known
+	Section string `json:"section,omitempty"`
+	Page    int    `json:"page,omitempty"`
+	// Sender of the cited email, if the source is an email
+	From string `json:"from,omitempty"`
 }
 
 type HistoryItem struct {
@@ -23,15 +28,107 @@ type HistoryItem struct {
 }
 
 type ConnectorState struct {
-	ConnectorID   string    `json:"connector_id"`
-	User          string    `json:"user"`
-	ConnectorType string    `json:"connector_type"`
-	AuthValid     bool      `json:"auth_valid"`
-	Syncing       bool      `json:"syncing"`
-	LastSync      time.Time `json:"last_sync"`
-	NumDocuments  int       `json:"num_documents"`
-	NumChunks     int       `json:"num_chunks"`
-	NumErrors     int       `json:"num_errors"`
+	ConnectorID   string `json:"connector_id"`
+	User          string `json:"user"`
+	ConnectorType string `json:"connector_type"`
+	AuthValid     bool   `json:"auth_valid"`
+	// AuthError is why the connector requires the user to authenticate
+	// again, if its auth was invalidated
+	AuthError    string    `json:"auth_error,omitempty"`
+	Syncing      bool      `json:"syncing"`
+	LastSync     time.Time `json:"last_sync"`
+	NumDocuments int       `json:"num_documents"`
+	NumChunks    int       `json:"num_chunks"`
+	NumErrors    int       `json:"num_errors"`
+	// SyncCursors hold the sync position of each source of the connector,
+	// such as a Slack channel, keyed by source
+	SyncCursors map[string]string `json:"sync_cursors,omitempty"`
+	// Schedule is a sync interval such as "30m", or a cron expression. The
+	// default interval applies if empty.
+	Schedule string `json:"schedule"`
+	// NextSync is when the connector is next due to sync
+	NextSync time.Time `json:"next_sync"`
+	// SyncFailures counts the consecutive failed syncs, which delay the next
+	// attempt
+	SyncFailures int `json:"sync_failures"`
+	// Paused connectors are not synced until resumed
+	Paused bool `json:"paused"`
+}
+
+type EmbeddingIndexStatus string
+
+const (
+	EmbeddingIndexPending  EmbeddingIndexStatus = "pending" // Its model is being pulled
+	EmbeddingIndexBuilding EmbeddingIndexStatus = "building"
+	EmbeddingIndexActive   EmbeddingIndexStatus = "active"
+	EmbeddingIndexPrevious EmbeddingIndexStatus = "previous" // Kept for rollback
+	EmbeddingIndexFailed   EmbeddingIndexStatus = "failed"
+)
+
+// EmbeddingIndex is a class of chunks vectorized with one embeddings model
+type EmbeddingIndex struct {
+	ID          string               `json:"id"`
+	ClassName   string               `json:"class_name"`
+	Model       string               `json:"model"`
+	Dimensions  int                  `json:"dimensions"` // Recorded with the first vectors
+	Prefixed    bool                 `json:"prefixed"`   // Embedded with the task prefixes of the model, false if by weaviate in older versions
+	Status      EmbeddingIndexStatus `json:"status"`
+	Total       int                  `json:"total"` // Chunks to copy while building
+	Done        int                  `json:"done"`
+	Cursor      string               `json:"-"` // ID of the last chunk copied
+	Error       string               `json:"error,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	ActivatedAt time.Time            `json:"activated_at"`
+}
+
+// Live tells whether chunks are written to the index, which requires its
+// model to vectorize them
+func (i *EmbeddingIndex) Live() bool {
+	return i.Status != EmbeddingIndexPending && i.Status != EmbeddingIndexFailed
+}
+
+// InProgress tells whether the index is being built
+func (i *EmbeddingIndex) InProgress() bool {
+	return i.Status == EmbeddingIndexPending || i.Status == EmbeddingIndexBuilding
+}
+
+type SyncOutcome string
+
+const (
+	SyncOutcomeRunning   SyncOutcome = "running"
+	SyncOutcomeSuccess   SyncOutcome = "success"
+	SyncOutcomeFailed    SyncOutcome = "failed"
+	SyncOutcomeCancelled SyncOutcome = "cancelled"
+)
+
+// SyncRun records a sync of a connector
+type SyncRun struct {
+	ID          string `json:"id"`
+	ConnectorID string `json:"connector_id"`
+	// Retry runs only sync the items that failed in previous runs
+	Retry     bool        `json:"retry"`
+	StartedAt time.Time   `json:"started_at"`
+	EndedAt   time.Time   `json:"ended_at"`
+	Outcome   SyncOutcome `json:"outcome"`
+	// Number of documents and chunks added, net of deletions
+	NumDocuments int `json:"num_documents"`
+	NumChunks    int `json:"num_chunks"`
+	NumErrors    int `json:"num_errors"`
+	// Error is the error that failed the run
+	Error string `json:"error,omitempty"`
+}
+
+// SyncError records an item that failed to sync
+type SyncError struct {
+	ID          string `json:"id"`
+	ConnectorID string `json:"connector_id"`
+	RunID       string `json:"run_id"`
+	// DocumentID is the UniqueID of the document, if known
+	DocumentID   string    `json:"document_id,omitempty"`
+	DocumentName string    `json:"document_name,omitempty"`
+	Stage        string    `json:"stage,omitempty"`
+	Error        string    `json:"error"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 type Chunk struct {
@@ -39,6 +136,26 @@ type Chunk struct {
 	Text     string `json:"text"`
 	Hash     string `json:"hash"`
 
+	// Ordinal is the position of the chunk within its document. Connectors
+	// that append to documents incrementally, such as Slack, number chunks in
+	// the order they are added.
+	Ordinal int `json:"ordinal"`
+	// PageStart and PageEnd are the 1-based pages that the chunk spans, or 0
+	// if the document is not paginated
+	PageStart int `json:"page_start,omitempty"`
+	PageEnd   int `json:"page_end,omitempty"`
+	// HeadingPath lists the section headings enclosing the chunk, outermost
+	// first
+	HeadingPath []string `json:"heading_path,omitempty"`
+	// StartOffset and EndOffset are the character offsets of the chunk in the
+	// content extracted from the document
+	StartOffset int `json:"start_offset"`
+	EndOffset   int `json:"end_offset"`
+	// RawText is the content of the chunk before sanitisation
+	RawText string `json:"raw_text,omitempty"`
+	// URLs are the links extracted from the chunk content by the sanitizer
+	URLs []string `json:"urls,omitempty"`
+
 	// The following fields are only filled in when the chunk is a search result
 	Score        float64 `json:"score"`
 	ExplainScore string  `json:"explain_score"`
@@ -51,8 +168,19 @@ type Document struct {
 	ConnectorType string    `json:"connector_type"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
+	// Metadata holds connector specific attributes of the document, such as
+	// the sender of an email. See the DocumentMetadata keys.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
+// Well-known keys of Document.Metadata
+const (
+	DocumentMetadataFrom     = "from"
+	DocumentMetadataTo       = "to"
+	DocumentMetadataCc       = "cc"
+	DocumentMetadataThreadID = "thread_id"
+)
+
 type Conversation struct {
 	ID          string        `json:"id"`
 	History     []HistoryItem `json:"history"`
@@ -78,4 +206,7 @@ type Config struct {
 	// (right now we're opt out telemetry)
 
 	EnableTelemetry bool `json:"enable_telemetry"`
+	// GenerationModel is the model selected to answer prompts, the default
+	// one if empty
+	GenerationModel string `json:"generation_model,omitempty"`
 }
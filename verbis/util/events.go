@@ -0,0 +1,55 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/verbis-ai/verbis/verbis/types"
+)
+
+// eventBufferSize is the number of events buffered for each subscriber
+const eventBufferSize = 256
+
+// EventBus broadcasts events to all subscribers. Publishing never blocks:
+// subscribers that fall behind by more than eventBufferSize events miss the
+// newer ones.
+type EventBus struct {
+	lock        sync.Mutex
+	subscribers map[chan types.Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: map[chan types.Event]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving all events published from now on,
+// and a function to call once done with it
+func (b *EventBus) Subscribe() (<-chan types.Event, func()) {
+	ch := make(chan types.Event, eventBufferSize)
+	b.lock.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.lock.Unlock()
+
+	unsubscribe := func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+func (b *EventBus) Publish(event types.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// The subscriber is not keeping up
+		}
+	}
+}
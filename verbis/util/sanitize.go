@@ -0,0 +1,237 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Names of the sanitisation rules, in the order in which they are applied
+const (
+	RuleBOM          = "bom"
+	RuleURLs         = "urls"
+	RulePayloads     = "payloads"
+	RuleControlChars = "control_chars"
+	RuleWhitespace   = "whitespace"
+	RuleNewlines     = "newlines"
+)
+
+// SanitizeRule is a named, individually toggleable step of the content
+// sanitisation pipeline
+type SanitizeRule struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	apply func(input string, res *SanitizeResult) string
+}
+
+// SanitizeResult holds the cleaned text along with anything that a rule
+// removed from it but is worth keeping as metadata
+type SanitizeResult struct {
+	Text string
+	URLs []string
+}
+
+var (
+	// Matches characters used in major human languages. This includes basic
+	// Latin, Latin-1 Supplement, Greek, Cyrillic, Hebrew, Arabic, etc.
+	disallowedCharsRegex = regexp.MustCompile(`[^\p{L}\p{M}\p{N}\p{P}\p{Zs}\n]`)
+	urlRegex             = regexp.MustCompile(`http[s]?://[^\s<>"|]+`)
+	payloadCandidate     = regexp.MustCompile(`[a-zA-Z0-9+/=\-_]{40,}`)
+	quotedPrintableByte  = regexp.MustCompile(`=[0-9A-F]{2}`)
+	horizontalSpaceRegex = regexp.MustCompile(`[^\S\n]+`)
+	spaceAroundNewline   = regexp.MustCompile(` ?\n ?`)
+	manyNewlinesRegex    = regexp.MustCompile(`\n{3,}`)
+	anySpaceRegex        = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeRules lists all available rules in the order in which they are
+// applied
+var SanitizeRules = []SanitizeRule{
+	{
+		Name:        RuleBOM,
+		Description: "Remove the UTF-8 byte order mark present in some text files",
+		apply: func(input string, _ *SanitizeResult) string {
+			return strings.TrimPrefix(input, "\xEF\xBB\xBF")
+		},
+	},
+	{
+		Name:        RuleURLs,
+		Description: "Move URLs out of the text and into the chunk metadata",
+		apply: func(input string, res *SanitizeResult) string {
+			return urlRegex.ReplaceAllStringFunc(input, func(url string) string {
+				url = strings.TrimRight(url, ".,;:!?)]}'>")
+				res.URLs = append(res.URLs, url)
+				return " "
+			})
+		},
+	},
+	{
+		Name:        RulePayloads,
+		Description: "Remove long base64 and quoted-printable blocks, keeping keys, tokens and hashes",
+		apply: func(input string, _ *SanitizeResult) string {
+			return payloadCandidate.ReplaceAllStringFunc(input, func(s string) string {
+				if isEncodedPayload(s) {
+					return ""
+				}
+				return s
+			})
+		},
+	},
+	{
+		Name:        RuleControlChars,
+		Description: "Replace control characters, symbols and emoji with spaces, keeping newlines",
+		apply: func(input string, _ *SanitizeResult) string {
+			return disallowedCharsRegex.ReplaceAllString(input, " ")
+		},
+	},
+	{
+		Name:        RuleWhitespace,
+		Description: "Collapse runs of spaces and blank lines",
+		apply: func(input string, _ *SanitizeResult) string {
+			input = horizontalSpaceRegex.ReplaceAllString(input, " ")
+			input = spaceAroundNewline.ReplaceAllString(input, "\n")
+			return manyNewlinesRegex.ReplaceAllString(input, "\n\n")
+		},
+	},
+	{
+		Name:        RuleNewlines,
+		Description: "Join all lines into a single one",
+		apply: func(input string, _ *SanitizeResult) string {
+			return anySpaceRegex.ReplaceAllString(input, " ")
+		},
+	},
+}
+
+// Shortest run with + or / that is taken for base64, longer than keys such as
+// AWS secret keys
+const minBase64Length = 60
+
+// isEncodedPayload tells apart base64 and quoted-printable blocks from long but
+// meaningful identifiers. API keys and access tokens, commit SHAs and other
+// hex strings are kept: base64 is only recognised by its padding, or by + and
+// / in runs longer than keys.
+func isEncodedPayload(s string) bool {
+	if len(quotedPrintableByte.FindAllString(s, -1)) >= 3 {
+		return true
+	}
+
+	var upper, lower, digit, slash bool
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case r == '+' || r == '/':
+			slash = true
+		}
+	}
+	if !upper || !lower || !digit || strings.ContainsAny(s, "-_") {
+		return false
+	}
+	if strings.HasSuffix(s, "=") && len(s)%4 == 0 {
+		return true
+	}
+	return slash && len(s) >= minBase64Length
+}
+
+// DefaultSanitizeRules are applied to content of connector types that are
+// not listed in ConnectorSanitizeRules
+var DefaultSanitizeRules = []string{
+	RuleBOM,
+	RuleControlChars,
+	RuleURLs,
+	RuleWhitespace,
+	RuleNewlines,
+}
+
+// ConnectorSanitizeRules holds the rules enabled for each connector type. It
+// is overridden by the daemon config with SetConnectorSanitizeRules.
+var ConnectorSanitizeRules = map[string][]string{
+	// Email bodies are full of tracking links and inline encoded payloads
+	"gmail":   {RuleBOM, RuleControlChars, RuleURLs, RulePayloads, RuleWhitespace, RuleNewlines},
+	"outlook": {RuleBOM, RuleControlChars, RuleURLs, RulePayloads, RuleWhitespace, RuleNewlines},
+	// Newlines separate the messages of a conversation
+	"slack": {RuleBOM, RuleControlChars, RuleURLs, RuleWhitespace},
+}
+
+// Sanitizer applies a set of sanitisation rules to content
+type Sanitizer struct {
+	rules []SanitizeRule
+}
+
+// NewSanitizer returns a sanitizer applying the named rules. Rules are always
+// applied in the order of SanitizeRules, regardless of the order of names.
+func NewSanitizer(names []string) (*Sanitizer, error) {
+	enabled := map[string]bool{}
+	for _, name := range names {
+		enabled[name] = true
+	}
+
+	s := &Sanitizer{}
+	for _, rule := range SanitizeRules {
+		if enabled[rule.Name] {
+			s.rules = append(s.rules, rule)
+			delete(enabled, rule.Name)
+		}
+	}
+	for name := range enabled {
+		return nil, fmt.Errorf("unknown sanitize rule %s", name)
+	}
+	return s, nil
+}
+
+// SetConnectorSanitizeRules replaces the rules of the given connector types,
+// after checking that every rule exists
+func SetConnectorSanitizeRules(rules map[string][]string) error {
+	for connectorType, names := range rules {
+		_, err := NewSanitizer(names)
+		if err != nil {
+			return fmt.Errorf("invalid rules for %s: %v", connectorType, err)
+		}
+	}
+	for connectorType, names := range rules {
+		ConnectorSanitizeRules[connectorType] = names
+	}
+	return nil
+}
+
+// SanitizerFor returns the sanitizer configured for a connector type
+func SanitizerFor(connectorType string) (*Sanitizer, error) {
+	names, ok := ConnectorSanitizeRules[connectorType]
+	if !ok {
+		names = DefaultSanitizeRules
+	}
+	return NewSanitizer(names)
+}
+
+// RuleNames returns the names of the rules applied by the sanitizer
+func (s *Sanitizer) RuleNames() []string {
+	names := make([]string, 0, len(s.rules))
+	for _, rule := range s.rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+func (s *Sanitizer) Sanitize(input string) SanitizeResult {
+	res := SanitizeResult{}
+	for _, rule := range s.rules {
+		input = rule.apply(input, &res)
+	}
+	res.Text = strings.TrimSpace(input)
+	return res
+}
+
+// CleanChunk sanitizes the input with the default rules
+func CleanChunk(input string) string {
+	s, err := NewSanitizer(DefaultSanitizeRules)
+	if err != nil {
+		return strings.TrimSpace(input)
+	}
+	return s.Sanitize(input).Text
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	valid := []string{"30m", " 6h ", "* * * * *", "0 9 * * 1-5", "*/15 8-18 * * *", "0 0 1,15 * *", "30 2 * 1-3 0,7"}
+	for _, spec := range valid {
+		_, err := ParseSchedule(spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", spec, err)
+		}
+	}
+
+	invalid := []string{"", "30s", "0 9 * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"}
+	for _, spec := range invalid {
+		_, err := ParseSchedule(spec)
+		if err == nil {
+			t.Errorf("ParseSchedule(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.Local)
+	}
+	// Wednesday
+	now := at(2024, time.January, 10, 9, 30).Add(10 * time.Second)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"30m", now.Add(30 * time.Minute)},
+		{"* * * * *", at(2024, time.January, 10, 9, 31)},
+		{"*/15 * * * *", at(2024, time.January, 10, 9, 45)},
+		{"0 9 * * *", at(2024, time.January, 11, 9, 0)},
+		{"0 9 * * 1-5", at(2024, time.January, 11, 9, 0)},
+		{"0 9 * * 6", at(2024, time.January, 13, 9, 0)},
+		// 0 and 7 are both Sunday
+		{"0 9 * * 7", at(2024, time.January, 14, 9, 0)},
+		{"0 0 1 * *", at(2024, time.February, 1, 0, 0)},
+		{"0 12 29 2 *", at(2024, time.February, 29, 12, 0)},
+		// Either the day of month or the day of week matches
+		{"0 9 20 * 5", at(2024, time.January, 12, 9, 0)},
+		{"0 9 11 * 5", at(2024, time.January, 11, 9, 0)},
+		{"0 0 1 1 *", at(2025, time.January, 1, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule: %v", err)
+			}
+			if got := schedule.Next(now); !got.Equal(tt.want) {
+				t.Errorf("Next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextImpossible(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local)
+	if got := schedule.Next(now); got.Before(now.AddDate(4, 0, 0)) {
+		t.Errorf("Next = %s, expected no time within 4 years", got)
+	}
+}
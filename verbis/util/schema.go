@@ -0,0 +1,107 @@
+package util
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONSchema describes the type of v as a JSON schema, so that clients can
+// render a form for it. Struct fields are named after their json tag, and
+// the following struct tags are supported:
+//
+//	title:"..."        a human readable name for the field
+//	description:"..."  a longer explanation of the field
+//	enum:"a,b,c"       the allowed values of a string, or of the items of a list of strings
+//	minimum:"n"        the minimum value of a number
+//	maximum:"n"        the maximum value of a number
+func JSONSchema(v interface{}) map[string]interface{} {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaForType(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaForType(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		order := []string{}
+		addStructFields(t, properties, &order)
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+			// JSON objects are unordered, clients should lay fields out in
+			// this order
+			"propertyOrder": order,
+		}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func addStructFields(t reflect.Type, properties map[string]interface{}, order *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			// Embedded struct fields are flattened, as in encoding/json
+			addStructFields(field.Type, properties, order)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := schemaForType(field.Type)
+		if title := field.Tag.Get("title"); title != "" {
+			schema["title"] = title
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			values := strings.Split(enum, ",")
+			if items, ok := schema["items"].(map[string]interface{}); ok {
+				items["enum"] = values
+			} else {
+				schema["enum"] = values
+			}
+		}
+		for _, key := range []string{"minimum", "maximum"} {
+			if value, err := strconv.ParseFloat(field.Tag.Get(key), 64); err == nil {
+				schema[key] = value
+			}
+		}
+
+		properties[name] = schema
+		*order = append(*order, name)
+	}
+}
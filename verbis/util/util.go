@@ -4,16 +4,46 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
 )
 
 var (
 	OllamaFile   = "ollama"
 	WeaviateFile = "weaviate"
+
+	// Overrides of the data and dist directories, set from the daemon config
+	dataDir string
+	distDir string
 )
 
+// SetDataDir sets the directory holding all Verbis data, ~/.verbis by default
+func SetDataDir(dir string) {
+	dataDir = dir
+}
+
+// DataPath returns the path of the given file relative to the data directory
+func DataPath(rel ...string) (string, error) {
+	dir := dataDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to get user home directory: %w", err)
+		}
+		dir = filepath.Join(home, ".verbis")
+	}
+	return filepath.Join(append([]string{dir}, rel...)...), nil
+}
+
+// SetDistDir sets the directory holding the bundled binaries and models,
+// instead of looking for them next to the executable
+func SetDistDir(dir string) {
+	distDir = dir
+}
+
 func GetDistPath() (string, error) {
+	if distDir != "" {
+		return distDir, nil
+	}
+
 	// Get the path of the executable
 	exePath, err := os.Executable()
 	if err != nil {
@@ -63,67 +93,3 @@ func binariesPresent(path string) error {
 
 	return nil
 }
-
-func CleanChunk(input string) string {
-	// The UTF-8 BOM is sometimes present in text files, and should be removed
-	bom := []byte{0xEF, 0xBB, 0xBF}
-	input = strings.TrimPrefix(input, string(bom))
-
-	// Define a regex pattern that matches characters used in major human languages
-	// This includes basic Latin, Latin-1 Supplement, Greek, Cyrillic, Hebrew, Arabic, etc.
-	disallowedChars := regexp.MustCompile(`[^\p{L}\p{M}\p{N}\p{P}\p{Zs}]`)
-	input = disallowedChars.ReplaceAllString(input, " ")
-
-	// Remove URLs
-	urlRegex := regexp.MustCompile(`http[s]?://[^\s]+`)
-	input = urlRegex.ReplaceAllString(input, "")
-
-	// Remove non-readable text and payloads (based on patterns in your example)
-	payloadRegex := regexp.MustCompile(`[a-zA-Z0-9\-_]{20,}`)
-	input = payloadRegex.ReplaceAllString(input, "")
-
-	// Remove extra whitespace
-	input = regexp.MustCompile(`\s+`).ReplaceAllString(input, " ")
-	input = strings.TrimSpace(input)
-
-	return input
-}
-
-// Split the text into units (words, in this case)
-func wordSplitter(sourceText string) []string {
-	// Replace multiple whitespaces
-	re := regexp.MustCompile(`\s+`)
-	sourceText = re.ReplaceAllString(sourceText, " ")
-	// Split by single whitespace
-	return strings.Split(sourceText, " ")
-}
-
-func ChunkText(text string, chunkSize int, overlapFraction float64) []string {
-	textWords := wordSplitter(text)
-	overlapInt := int(float64(chunkSize) * overlapFraction)
-	var chunks []string
-
-	for i := 0; i < len(textWords); i += chunkSize {
-		startIndex := max(i-overlapInt, 0)
-		endIndex := min(i+chunkSize, len(textWords))
-		chunkWords := textWords[startIndex:endIndex]
-		chunk := strings.Join(chunkWords, " ")
-		chunks = append(chunks, chunk)
-	}
-
-	return chunks
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}